@@ -12,9 +12,13 @@ import (
 	"gitlab.com/etke.cc/go/mxidwc"
 	"maunium.net/go/mautrix/id"
 
+	"gitlab.com/etke.cc/postmoogle/bot/config"
 	"gitlab.com/etke.cc/postmoogle/utils"
 )
 
+// defaultBanlistTTL is the duration of automatic bans in hours
+const defaultBanlistTTL = 24
+
 func parseMXIDpatterns(patterns []string, defaultPattern string) ([]*regexp.Regexp, error) {
 	if len(patterns) == 0 && defaultPattern != "" {
 		patterns = []string{defaultPattern}
@@ -87,40 +91,34 @@ func (b *Bot) allowReply(actorID id.UserID, targetRoomID id.RoomID) bool {
 	return !cfg.NoReplies()
 }
 
-func (b *Bot) isReserved(mailbox string) bool {
-	for _, reserved := range b.mbxc.Reserved {
-		if mailbox == reserved {
-			return true
-		}
-	}
-	return false
+// isMapped checks if mailbox (or alias) exists
+func (b *Bot) isMapped(mailbox string) bool {
+	_, ok := b.getMapping(mailbox)
+	return ok
 }
 
-// IsGreylisted checks if host is in greylist
-func (b *Bot) IsGreylisted(addr net.Addr) bool {
-	if b.cfg.GetBot().Greylist() == 0 {
+// isAvailable checks if mailbox (or alias) is not reserved and not taken by another room
+func (b *Bot) isAvailable(roomID id.RoomID, mailbox string) bool {
+	if mailbox == "" || b.isReserved(mailbox) {
 		return false
 	}
+	existingID, ok := b.getMapping(mailbox)
 
-	greylist := b.cfg.GetGreylist()
-	greylistedAt, ok := greylist.Get(addr)
-	if !ok {
-		b.log.Debug().Str("addr", addr.String()).Msg("greylisting")
-		greylist.Add(addr)
-		err := b.cfg.SetGreylist(greylist)
-		if err != nil {
-			b.log.Error().Err(err).Str("addr", addr.String()).Msg("cannot update greylist")
+	return !ok || existingID == "" || existingID == roomID
+}
+
+func (b *Bot) isReserved(mailbox string) bool {
+	for _, reserved := range b.mbxc.Reserved {
+		if mailbox == reserved {
+			return true
 		}
-		return true
 	}
-	duration := time.Duration(b.cfg.GetBot().Greylist()) * time.Minute
-
-	return greylistedAt.Add(duration).After(time.Now().UTC())
+	return false
 }
 
-// IsBanned checks if address is banned
+// IsBanned checks if address is banned, either permanently or temporary
 func (b *Bot) IsBanned(addr net.Addr) bool {
-	return b.cfg.GetBanlist().Has(addr)
+	return b.cfg.GetBanlist().Has(addr) || b.cfg.GetAutoBanlist().Has(addr)
 }
 
 // IsTrusted checks if address is a trusted (proxy)
@@ -136,25 +134,53 @@ func (b *Bot) IsTrusted(addr net.Addr) bool {
 	return false
 }
 
-// Ban an address
-func (b *Bot) Ban(addr net.Addr) {
+// ban an address temporary, for the configured banlist TTL
+func (b *Bot) ban(addr net.Addr, reason string) {
+	b.log.Debug().Str("addr", addr.String()).Str("reason", reason).Msg("attempting to ban")
+	ttl := b.getBanlistTTL()
+	err := b.cfg.UpdateAutoBanlist(func(banlist config.ExpiringList) bool {
+		banlist.Add(addr, ttl, reason)
+		return true
+	})
+	if err != nil {
+		b.log.Error().Err(err).Str("addr", addr.String()).Msg("cannot update banlist")
+	}
+}
+
+// PruneBanlist removes expired automatic bans
+func (b *Bot) PruneBanlist() {
 	if !b.cfg.GetBot().BanlistEnabled() {
 		return
 	}
-	if b.IsTrusted(addr) {
+	_, window := b.getBanlistThresholds()
+	b.offences.pruneAll(window)
+
+	var count int
+	err := b.cfg.UpdateAutoBanlist(func(banlist config.ExpiringList) bool {
+		count = banlist.Prune()
+		return count > 0
+	})
+	if err != nil {
+		b.log.Error().Err(err).Msg("cannot update banlist")
 		return
 	}
-	b.log.Debug().Str("addr", addr.String()).Msg("attempting to ban")
-	banlist := b.cfg.GetBanlist()
-	banlist.Add(addr)
-	err := b.cfg.SetBanlist(banlist)
-	if err != nil {
-		b.log.Error().Err(err).Str("addr", addr.String()).Msg("cannot update banlist")
+	if count > 0 {
+		b.log.Info().Int("count", count).Msg("pruned expired bans")
 	}
 }
 
-// AllowAuth check if SMTP login (email) and password are valid
-func (b *Bot) AllowAuth(email, password string) (id.RoomID, bool) {
+func (b *Bot) getBanlistTTL() time.Duration {
+	ttl := b.cfg.GetBot().BanlistTTL()
+	if ttl == 0 {
+		ttl = defaultBanlistTTL
+	}
+
+	return time.Duration(ttl) * time.Hour
+}
+
+// AllowAuth check if SMTP login (email) and password are valid.
+// Unknown and locked mailboxes are rejected before the (expensive) password check
+func (b *Bot) AllowAuth(email, password string, addr net.Addr) (id.RoomID, bool) {
 	var suffix bool
 	for _, domain := range b.domains {
 		if strings.HasSuffix(email, "@"+domain) {
@@ -166,10 +192,15 @@ func (b *Bot) AllowAuth(email, password string) (id.RoomID, bool) {
 		return "", false
 	}
 
-	roomID, ok := b.getMapping(utils.Mailbox(email))
+	mailbox := utils.Mailbox(email)
+	roomID, ok := b.getMapping(mailbox)
 	if !ok {
 		return "", false
 	}
+	if until := b.logins.lockedUntil(mailbox); !until.IsZero() {
+		b.log.Warn().Str("email", email).Str("addr", utils.AddrIP(addr)).Time("until", until).Msg("mailbox is locked")
+		return "", false
+	}
 	cfg, err := b.cfg.GetRoom(roomID)
 	if err != nil {
 		b.log.Error().Err(err).Msg("failed to retrieve settings")
@@ -185,5 +216,11 @@ func (b *Bot) AllowAuth(email, password string) (id.RoomID, bool) {
 	if err != nil {
 		b.log.Warn().Err(err).Str("email", email).Msg("Password is not valid")
 	}
-	return roomID, allow
+	if !allow {
+		b.failAuth(mailbox, roomID, addr)
+		return roomID, false
+	}
+
+	b.logins.reset(mailbox)
+	return roomID, true
 }
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// autoreplyHandle distinguishes room autoreply from sieve vacation responses
+const autoreplyHandle = "autoreply"
+
+// roomAutoreply sends out-of-office response to the sender of the email (if the room's autoreply is active)
+// and posts a notice about it into the email's thread
+func (b *Bot) roomAutoreply(roomID id.RoomID, cfg config.Room, eml *email.Email) {
+	if !cfg.AutoreplyActive(time.Now().UTC()) {
+		return
+	}
+	sent := b.sendAutoreply(roomID, cfg, eml, &autoreply{
+		Subject: cfg.AutoreplySubject(),
+		Body:    cfg.AutoreplyBody(),
+		Days:    cfg.AutoreplyInterval(),
+		Handle:  autoreplyHandle,
+	})
+	if !sent {
+		return
+	}
+
+	threadID := b.getThreadID(roomID, eml.MessageID, "")
+	content := format.RenderMarkdown("autoreply has been sent to "+email.Address(eml.EnvelopeFrom), true, true)
+	content.MsgType = event.MsgNotice
+	content.RelatesTo = utils.RelatesTo(!cfg.NoThreads(), threadID)
+	if _, err := b.lp.Send(roomID, &event.Content{Parsed: &content}); err != nil {
+		b.log.Error().Err(utils.UnwrapError(err)).Str("roomID", roomID.String()).Msg("cannot send autoreply notice")
+	}
+}
+
+func (b *Bot) runAutoreply(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.sendAutoreplySettings(ctx)
+		return
+	}
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+
+	var msg string
+	switch commandSlice[1] {
+	case "on", "off":
+		cfg.Set(config.RoomAutoreply, strconv.FormatBool(commandSlice[1] == "on"))
+		msg = "autoreply has been turned " + commandSlice[1]
+		if commandSlice[1] == "on" && cfg.AutoreplyBody() == "" {
+			msg += ", but it has no body yet. Set it with `" + b.prefix + " autoreply body TEXT`"
+		}
+	case "subject":
+		cfg.Set(config.RoomAutoreplySubject, strings.Join(strings.Fields(b.rawArgument(ctx, commandAutoreply)), " "))
+		msg = "autoreply subject has been updated"
+	case "body":
+		cfg.Set(config.RoomAutoreplyBody, b.rawArgument(ctx, commandAutoreply))
+		msg = "autoreply body has been updated"
+	case "start", "end":
+		key := config.RoomAutoreplyStart
+		if commandSlice[1] == "end" {
+			key = config.RoomAutoreplyEnd
+		}
+		value := ""
+		if len(commandSlice) > 2 && commandSlice[2] != "none" {
+			if _, err := time.Parse(config.AutoreplyDateFormat, commandSlice[2]); err != nil {
+				b.SendError(ctx, evt.RoomID, "invalid date, use YYYY-MM-DD format or `none`, kupo.")
+				return
+			}
+			value = commandSlice[2]
+		}
+		cfg.Set(key, value)
+		msg = "autoreply " + commandSlice[1] + " date has been updated"
+	case "interval":
+		if len(commandSlice) < 3 || utils.Int(commandSlice[2]) < 1 {
+			b.SendError(ctx, evt.RoomID, "interval should be a positive number of days, kupo.")
+			return
+		}
+		cfg.Set(config.RoomAutoreplyInterval, strconv.Itoa(utils.Int(commandSlice[2])))
+		msg = "autoreply interval has been updated"
+	default:
+		b.sendAutoreplySettings(ctx)
+		return
+	}
+
+	if err := b.cfg.SetRoom(evt.RoomID, cfg); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+		return
+	}
+	b.SendNotice(ctx, evt.RoomID, msg+", kupo")
+}
+
+func (b *Bot) sendAutoreplySettings(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+	orNone := func(value string) string {
+		if value == "" {
+			return "none"
+		}
+		return value
+	}
+
+	var msg strings.Builder
+	msg.WriteString("Autoreply is **")
+	if cfg.Autoreply() {
+		msg.WriteString("on")
+	} else {
+		msg.WriteString("off")
+	}
+	msg.WriteString("**")
+	if cfg.Autoreply() && !cfg.AutoreplyActive(time.Now().UTC()) {
+		msg.WriteString(" (inactive right now)")
+	}
+	msg.WriteString(", kupo.\n\n")
+	msg.WriteString(fmt.Sprintf("* subject: %s\n", orNone(cfg.AutoreplySubject())))
+	msg.WriteString(fmt.Sprintf("* start: %s\n", orNone(cfg.Get(config.RoomAutoreplyStart))))
+	msg.WriteString(fmt.Sprintf("* end: %s\n", orNone(cfg.Get(config.RoomAutoreplyEnd))))
+	msg.WriteString(fmt.Sprintf("* interval: %d day(s)\n", cfg.AutoreplyInterval()))
+	if body := cfg.AutoreplyBody(); body != "" {
+		msg.WriteString("* body:\n```\n")
+		msg.WriteString(body)
+		msg.WriteString("\n```\n")
+	} else {
+		msg.WriteString("* body: none\n")
+	}
+
+	msg.WriteString("\nUsage: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" autoreply on|off`, `subject TEXT`, `body TEXT`, `start YYYY-MM-DD|none`, `end YYYY-MM-DD|none` (inclusive, UTC), ")
+	msg.WriteString("`interval DAYS` (how often the same sender may get the autoreply). ")
+	msg.WriteString("Autoreplies are not sent to mailing lists, bulk or automatic emails, and emails without sender.")
+
+	b.SendNotice(ctx, evt.RoomID, msg.String())
+}
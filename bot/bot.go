@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/getsentry/sentry-go"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/rs/zerolog"
 	"gitlab.com/etke.cc/linkpearl"
 	"maunium.net/go/mautrix/event"
@@ -22,6 +23,7 @@ import (
 type MBXConfig struct {
 	Reserved   []string
 	Activation string
+	Separator  string
 }
 
 // Bot represents matrix bot
@@ -41,7 +43,12 @@ type Bot struct {
 	log                     *zerolog.Logger
 	lp                      *linkpearl.Linkpearl
 	mu                      utils.Mutex
+	greylistMu              sync.Mutex
+	expiringMu              sync.Mutex
+	vip                     *lru.Cache[id.RoomID, vipPatterns]
 	q                       *queue.Queue
+	offences                *offences
+	logins                  *logins
 	handledMembershipEvents sync.Map
 }
 
@@ -68,7 +75,10 @@ func New(
 		log:        log,
 		lp:         lp,
 		mu:         utils.NewMutex(),
+		vip:        newVIPCache(),
 		q:          q,
+		offences:   newOffences(),
+		logins:     newLogins(),
 	}
 	users, err := b.initBotUsers()
 	if err != nil {
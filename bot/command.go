@@ -16,20 +16,41 @@ import (
 )
 
 const (
-	commandHelp          = "help"
-	commandStop          = "stop"
-	commandSend          = "send"
-	commandDKIM          = "dkim"
-	commandCatchAll      = config.BotCatchAll
-	commandUsers         = config.BotUsers
-	commandQueueBatch    = config.BotQueueBatch
-	commandQueueRetries  = config.BotQueueRetries
-	commandDelete        = "delete"
-	commandBanlist       = "banlist"
-	commandBanlistAdd    = "banlist:add"
-	commandBanlistRemove = "banlist:remove"
-	commandBanlistReset  = "banlist:reset"
-	commandMailboxes     = "mailboxes"
+	commandHelp               = "help"
+	commandStop               = "stop"
+	commandSend               = "send"
+	commandDKIM               = "dkim"
+	commandCatchAll           = config.BotCatchAll
+	commandUsers              = config.BotUsers
+	commandQueueBatch         = config.BotQueueBatch
+	commandQueueRetries       = config.BotQueueRetries
+	commandDelete             = "delete"
+	commandBanlist            = "banlist"
+	commandBanlistAdd         = "banlist:add"
+	commandBanlistRemove      = "banlist:remove"
+	commandBanlistReset       = "banlist:reset"
+	commandMailboxes          = "mailboxes"
+	commandRoutes             = "routes"
+	commandRoutesAdd          = "routes:add"
+	commandRoutesRemove       = "routes:remove"
+	commandRoutesMove         = "routes:move"
+	commandSubscribe          = "subscribe"
+	commandUnsubscribe        = "unsubscribe"
+	commandSubscribers        = config.RoomSubscribers
+	commandSubscribersApprove = "subscribers:approve"
+	commandSubscribersRemove  = "subscribers:remove"
+	commandRules              = "rules"
+	commandRulesAdd           = "rules:add"
+	commandRulesRemove        = "rules:remove"
+	commandRulesMove          = "rules:move"
+	commandSieve              = "sieve"
+	commandAutoreply          = config.RoomAutoreply
+	commandMailingList        = config.RoomList
+	commandMailingListAdd     = "list:add"
+	commandMailingListRemove  = "list:remove"
+	commandTemplate           = config.RoomTemplate
+	commandSource             = "source"
+	commandHeaders            = "headers"
 )
 
 type (
@@ -77,6 +98,18 @@ func (b *Bot) initCommands() commandList {
 			sanitizer:   utils.Mailbox,
 			allowed:     b.allowOwner,
 		},
+		{
+			key:         config.RoomAliases,
+			description: "Get or set aliases of the room's mailbox (comma-separated list), emails to them will be delivered to the room, and you can send emails as any of them",
+			sanitizer:   utils.SanitizeStringSlice,
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         config.RoomTags,
+			description: "Get or set routing of subaddressed emails (mailbox+tag@domain), comma-separated list of `tag=thread` (post into a dedicated thread) or `tag=mailbox` (deliver to another mailbox)",
+			sanitizer:   utils.SanitizeStringSlice,
+			allowed:     b.allowOwner,
+		},
 		{
 			key:         config.RoomDomain,
 			description: "Get or set default domain of the room",
@@ -185,6 +218,66 @@ func (b *Bot) initCommands() commandList {
 			sanitizer: utils.SanitizeBoolString,
 			allowed:   b.allowOwner,
 		},
+		{
+			key: config.RoomEML,
+			description: fmt.Sprintf(
+				"Get or set `%s` of the room (`true` - attach the original email as .eml file; `false` - don't attach it)",
+				config.RoomEML,
+			),
+			sanitizer: utils.SanitizeBoolString,
+			allowed:   b.allowOwner,
+		},
+		{
+			key: config.RoomMaxSize,
+			description: fmt.Sprintf(
+				"Get or set `%s` of the room (max size of the message in KiB, up to 60, default is 48; bigger messages are truncated and the original email is attached)",
+				config.RoomMaxSize,
+			),
+			sanitizer: utils.SanitizeIntString,
+			allowed:   b.allowOwner,
+		},
+		{
+			key: config.RoomRetention,
+			description: fmt.Sprintf(
+				"Get or set `%s` of the room (days to retain the original emails for the `source` and `headers` commands; `0` - don't retain)",
+				config.RoomRetention,
+			),
+			sanitizer: utils.SanitizeIntString,
+			allowed:   b.allowOwner,
+		},
+		{
+			key: config.RoomForward,
+			description: fmt.Sprintf(
+				"Get or set `%s` addresses of the room (comma-separated list), copies of incoming emails will be sent to them",
+				config.RoomForward,
+			),
+			sanitizer: utils.SanitizeStringSlice,
+			allowed:   b.allowOwner,
+		},
+		{
+			key: config.RoomDigest,
+			description: fmt.Sprintf(
+				"Get or set `%s` of the room (`hourly`, `daily` or count of emails - post emails as a single summary message; `off` - post each email immediately)",
+				config.RoomDigest,
+			),
+			sanitizer: sanitizeDigest,
+			allowed:   b.allowOwner,
+		},
+		{
+			key:         commandTemplate,
+			description: "Get or set templates of the email messages (`template text TEMPLATE`, `template html TEMPLATE` or `template reset`)",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandSource,
+			description: "Reply to an email with this command to get its original source as .eml file (requires `retention`)",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandHeaders,
+			description: "Reply to an email with this command to get its full headers (requires `retention`)",
+			allowed:     b.allowOwner,
+		},
 		{allowed: b.allowOwner, description: "mailbox antispam"}, // delimiter
 		{
 			key:         config.RoomSpamcheckMX,
@@ -219,6 +312,106 @@ func (b *Bot) initCommands() commandList {
 			sanitizer: utils.SanitizeStringSlice,
 			allowed:   b.allowOwner,
 		},
+		{allowed: b.allowOwner, description: "mailbox rules"}, // delimiter
+		{
+			key:         commandRules,
+			description: "Show rules of incoming emails (by sender, domain, subject or header) and their order",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandRulesAdd,
+			description: "Add a rule: `FIELD PATTERN ACTION TARGET`, e.g. `from *@github.com thread GitHub` or `subject /\\[URGENT\\]/ mention @oncall:example.com`",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandRulesRemove,
+			description: "Remove a rule by its number",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandRulesMove,
+			description: "Move a rule to another position: `FROM TO`",
+			allowed:     b.allowOwner,
+		},
+		{
+			key: config.RoomVIP,
+			description: fmt.Sprintf(
+				"Get or set `%s` senders of the room (comma-separated list of wildcards or /regular expressions/), eg: `*@bigcustomer.com,ceo@example.com`",
+				config.RoomVIP,
+			),
+			sanitizer: utils.SanitizeStringSlice,
+			allowed:   b.allowOwner,
+		},
+		{
+			key: config.RoomVIPPriority,
+			description: fmt.Sprintf(
+				"Get or set `%s` of the room (`true` - treat urgent and high priority emails as VIP; `false` - ignore email priority)",
+				config.RoomVIPPriority,
+			),
+			sanitizer: utils.SanitizeBoolString,
+			allowed:   b.allowOwner,
+		},
+		{
+			key: config.RoomVIPMentions,
+			description: fmt.Sprintf(
+				"Get or set `%s` of the room (comma-separated list of users or `@room` to mention in VIP emails, room owner by default)",
+				config.RoomVIPMentions,
+			),
+			sanitizer: utils.SanitizeStringSlice,
+			allowed:   b.allowOwner,
+		},
+		{
+			key:         commandSieve,
+			description: "Get or set Sieve filter script of the room (`sieve SCRIPT`, upload a `.sieve` file or `sieve reset`)",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandAutoreply,
+			description: "Get or set out-of-office autoreply: `on|off`, `subject TEXT`, `body TEXT`, `start YYYY-MM-DD`, `end YYYY-MM-DD`, `interval DAYS`",
+			allowed:     b.allowOwner,
+		},
+		{allowed: b.allowOwner, description: "mailbox subscriptions"}, // delimiter
+		{
+			key:         commandSubscribe,
+			description: "Subscribe the room to another mailbox (read-only copies of emails, the mailbox owner should approve it)",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandUnsubscribe,
+			description: "Unsubscribe the room from another mailbox",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandSubscribers,
+			description: "Show rooms subscribed to the room's mailbox and pending subscription requests",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandSubscribersApprove,
+			description: "Approve subscription request of the room",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandSubscribersRemove,
+			description: "Remove subscriber room or reject its subscription request",
+			allowed:     b.allowOwner,
+		},
+		{allowed: b.allowOwner, description: "mailing list"}, // delimiter
+		{
+			key:         commandMailingList,
+			description: "Show members of the mailing list or turn the mailing list mode `on|off` (emails to the mailbox are redistributed to the members)",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandMailingListAdd,
+			description: "Add external addresses to the mailing list members",
+			allowed:     b.allowOwner,
+		},
+		{
+			key:         commandMailingListRemove,
+			description: "Remove external addresses from the mailing list members",
+			allowed:     b.allowOwner,
+		},
 		{allowed: b.allowAdmin, description: "server options"}, // delimiter
 		{
 			key:         config.BotAdminRoom,
@@ -237,7 +430,7 @@ func (b *Bot) initCommands() commandList {
 		},
 		{
 			key:         commandCatchAll,
-			description: "Get or set catch-all mailbox",
+			description: "Get or set catch-all mailbox, globally or per domain (`catch-all DOMAIN MAILBOX`)",
 			allowed:     b.allowAdmin,
 		},
 		{
@@ -252,9 +445,35 @@ func (b *Bot) initCommands() commandList {
 			sanitizer:   utils.SanitizeIntString,
 			allowed:     b.allowAdmin,
 		},
+		{
+			key:         config.BotVERP,
+			description: "Enable/disable VERP envelope sender (mailbox+bounce-token@domain) of outgoing emails to match bounces to the sent emails",
+			sanitizer:   utils.SanitizeBoolString,
+			allowed:     b.allowAdmin,
+		},
 		{
 			key:         commandMailboxes,
-			description: "Show the list of all mailboxes",
+			description: "Show the list of all mailboxes, or which mailbox (or routing rule) an address matches, if provided",
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         commandRoutes,
+			description: "Show pattern-based mailbox routing rules",
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         commandRoutesAdd,
+			description: "Add a routing rule: wildcard (`alerts-*`) or regex (`/^invoice\\..+$/`) pattern and target mailbox",
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         commandRoutesRemove,
+			description: "Remove a routing rule by its pattern",
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         commandRoutesMove,
+			description: "Move a routing rule to the position (e.g. `routes:move alerts-* 1`), rules are checked in order",
 			allowed:     b.allowAdmin,
 		},
 		{
@@ -268,19 +487,73 @@ func (b *Bot) initCommands() commandList {
 			description: "Set automatic greylisting duration in minutes (0 - disabled)",
 			allowed:     b.allowAdmin,
 		},
+		{
+			key:         config.BotGreylistRetry,
+			description: "Set greylisting retry window in hours, if sender doesn't retry within it, greylisting starts over (default: 48)",
+			sanitizer:   utils.SanitizeIntString,
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         config.BotGreylistExpiry,
+			description: "Set expiration of automatically whitelisted senders in days (default: 35)",
+			sanitizer:   utils.SanitizeIntString,
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         config.BotGreylistProviders,
+			description: "Set well-known email providers (comma-separated list of domains), senders from them are whitelisted if they pass SPF or DKIM checks",
+			sanitizer:   utils.SanitizeStringSlice,
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         config.BotAuthAttempts,
+			description: "Set count of failed SMTP logins, after which the mailbox will be locked (default: 10)",
+			sanitizer:   utils.SanitizeIntString,
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         config.BotAuthLockout,
+			description: "Set duration of mailbox lockout after failed SMTP logins in minutes (default: 15)",
+			sanitizer:   utils.SanitizeIntString,
+			allowed:     b.allowAdmin,
+		},
 		{
 			key:         commandBanlist,
 			description: "Enable/disable banlist and show current values",
 			allowed:     b.allowAdmin,
 		},
+		{
+			key:         config.BotBanlistTTL,
+			description: "Set duration of automatic bans in hours (default: 24)",
+			sanitizer:   utils.SanitizeIntString,
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         config.BotBanlistThreshold,
+			description: "Set score of a host, after which it will be banned automatically (default: 10)",
+			sanitizer:   utils.SanitizeIntString,
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         config.BotBanlistWindow,
+			description: "Set sliding window of offences in minutes (default: 60)",
+			sanitizer:   utils.SanitizeIntString,
+			allowed:     b.allowAdmin,
+		},
+		{
+			key:         config.BotBanlistWeights,
+			description: "Set points per offence (comma-separated list of offence=points, offences: auth, address, nouser, spam, helo; default: auth=5,address=3,nouser=2,spam=5,helo=3)",
+			sanitizer:   utils.SanitizeStringSlice,
+			allowed:     b.allowAdmin,
+		},
 		{
 			key:         commandBanlistAdd,
-			description: "Ban an IP",
+			description: "Ban an IP or network (CIDR) permanently",
 			allowed:     b.allowAdmin,
 		},
 		{
 			key:         commandBanlistRemove,
-			description: "Unban an IP",
+			description: "Unban an IP or network (CIDR)",
 			allowed:     b.allowAdmin,
 		},
 		{
@@ -307,6 +580,10 @@ func (b *Bot) handle(ctx context.Context) {
 	if content.MsgType == event.MsgNotice {
 		return
 	}
+	if content.MsgType == event.MsgFile && strings.HasSuffix(strings.ToLower(content.Body), ".sieve") {
+		b.onSieveFile(ctx, content)
+		return
+	}
 	message := strings.TrimSpace(content.Body)
 	commandSlice := b.parseCommand(message, true)
 	if commandSlice == nil {
@@ -350,6 +627,10 @@ func (b *Bot) handle(ctx context.Context) {
 		b.runDelete(ctx, commandSlice)
 	case config.BotGreylist:
 		b.runGreylist(ctx, commandSlice)
+	case config.BotGreylistRetry, config.BotGreylistExpiry, config.BotGreylistProviders, config.BotBanlistTTL,
+		config.BotBanlistThreshold, config.BotBanlistWindow, config.BotBanlistWeights,
+		config.BotAuthAttempts, config.BotAuthLockout, config.BotVERP:
+		b.runBotOption(ctx, commandSlice)
 	case commandBanlist:
 		b.runBanlist(ctx, commandSlice)
 	case commandBanlistAdd:
@@ -359,7 +640,49 @@ func (b *Bot) handle(ctx context.Context) {
 	case commandBanlistReset:
 		b.runBanlistReset(ctx)
 	case commandMailboxes:
-		b.sendMailboxes(ctx)
+		b.sendMailboxes(ctx, commandSlice)
+	case commandRoutes:
+		b.runRoutes(ctx)
+	case commandRoutesAdd:
+		b.runRoutesAdd(ctx)
+	case commandRoutesRemove:
+		b.runRoutesRemove(ctx)
+	case commandRoutesMove:
+		b.runRoutesMove(ctx)
+	case commandRules:
+		b.runRules(ctx)
+	case commandRulesAdd:
+		b.runRulesAdd(ctx)
+	case commandRulesRemove:
+		b.runRulesRemove(ctx, commandSlice)
+	case commandRulesMove:
+		b.runRulesMove(ctx, commandSlice)
+	case commandSieve:
+		b.runSieve(ctx, commandSlice)
+	case commandAutoreply:
+		b.runAutoreply(ctx, commandSlice)
+	case commandTemplate:
+		b.runTemplate(ctx, commandSlice)
+	case commandSource:
+		b.runSource(ctx)
+	case commandHeaders:
+		b.runHeaders(ctx)
+	case commandMailingList:
+		b.runList(ctx, commandSlice)
+	case commandMailingListAdd:
+		b.runListAdd(ctx, commandSlice)
+	case commandMailingListRemove:
+		b.runListRemove(ctx, commandSlice)
+	case commandSubscribe:
+		b.runSubscribe(ctx, commandSlice)
+	case commandUnsubscribe:
+		b.runUnsubscribe(ctx, commandSlice)
+	case commandSubscribers:
+		b.runSubscribers(ctx)
+	case commandSubscribersApprove:
+		b.runSubscribersApprove(ctx)
+	case commandSubscribersRemove:
+		b.runSubscribersRemove(ctx)
 	default:
 		b.handleOption(ctx, commandSlice)
 	}
@@ -382,6 +705,19 @@ func (b *Bot) parseCommand(message string, toLower bool) []string {
 	return strings.Split(strings.TrimSpace(message), " ")
 }
 
+// rawArgument returns text after the `COMMAND SUBCOMMAND` as is, without forced lower case and with new lines
+func (b *Bot) rawArgument(ctx context.Context, command string) string {
+	body := eventFromContext(ctx).Content.AsMessage().Body
+	body = strings.TrimSpace(body[strings.Index(body, b.prefix)+len(b.prefix):])
+	body = strings.TrimSpace(body[len(command):])
+	idx := strings.IndexAny(body, " \n")
+	if idx == -1 {
+		return ""
+	}
+
+	return strings.TrimSpace(body[idx:])
+}
+
 func (b *Bot) sendIntroduction(ctx context.Context, roomID id.RoomID) {
 	var msg strings.Builder
 	msg.WriteString("Hello, kupo!\n\n")
@@ -460,17 +796,18 @@ func (b *Bot) runSend(ctx context.Context) {
 		return
 	}
 	commandSlice := b.parseCommand(evt.Content.AsMessage().Body, false)
-	to, subject, body, err := utils.ParseSend(commandSlice)
+	to, sender, subject, body, err := utils.ParseSend(commandSlice)
 	if err == utils.ErrInvalidArgs {
 		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf(
 			"Usage:\n"+
 				"```\n"+
-				"%s send someone@example.com\n"+
+				"%s send someone@example.com[,another@example.com] [from:alias]\n"+
 				"Subject goes here on a line of its own\n"+
 				"Email content goes here\n"+
 				"on as many lines\n"+
 				"as you want.\n"+
-				"```",
+				"```\n"+
+				"where optional `from:alias` is one of the mailbox aliases to send email from",
 			b.prefix))
 		return
 	}
@@ -486,6 +823,24 @@ func (b *Bot) runSend(ctx context.Context) {
 		b.SendNotice(ctx, evt.RoomID, "mailbox is not configured, kupo")
 		return
 	}
+	domain := utils.SanitizeDomain(cfg.Domain())
+	if sender != "" {
+		mailbox = utils.Mailbox(sender)
+		if strings.Contains(sender, "@") {
+			domain = utils.SanitizeDomain(utils.Hostname(sender))
+		}
+		var allowed bool
+		for _, item := range cfg.Mailboxes() {
+			if item == mailbox {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			b.SendError(ctx, evt.RoomID, "you can send emails only from the room's mailbox or its aliases")
+			return
+		}
+	}
 
 	var htmlBody string
 	if !cfg.NoHTML() {
@@ -504,18 +859,25 @@ func (b *Bot) runSend(ctx context.Context) {
 	b.mu.Lock(evt.RoomID.String())
 	defer b.mu.Unlock(evt.RoomID.String())
 
-	domain := utils.SanitizeDomain(cfg.Domain())
 	from := mailbox + "@" + domain
+	// email to the mailing list address is sent to all its members
+	tos, members := listRecipients(cfg, domain, tos)
 	ID := email.MessageID(evt.ID, domain)
 	for _, to := range tos {
 		recipients := []string{to}
 		eml := email.New(ID, "", " "+ID, subject, from, to, to, "", body, htmlBody, nil, nil)
+		if members[to] {
+			listAddress := cfg.Mailbox() + "@" + domain
+			eml.To = listAddress
+			eml.ReplyTo = listAddress
+			eml.List = listHeaders(listAddress)
+		}
 		data := eml.Compose(b.cfg.GetBot().DKIMPrivateKey())
 		if data == "" {
 			b.SendError(ctx, evt.RoomID, "email body is empty")
 			return
 		}
-		queued, err := b.Sendmail(evt.ID, from, to, data)
+		queued, err := b.Sendmail(evt.RoomID, evt.ID, from, to, data)
 		if queued {
 			b.log.Error().Err(err).Msg("cannot send email")
 			b.saveSentMetadata(ctx, queued, evt.ID, recipients, eml, cfg)
@@ -15,8 +15,12 @@ import (
 	"gitlab.com/etke.cc/postmoogle/utils"
 )
 
-func (b *Bot) sendMailboxes(ctx context.Context) {
+func (b *Bot) sendMailboxes(ctx context.Context, commandSlice []string) {
 	evt := eventFromContext(ctx)
+	if len(commandSlice) > 1 {
+		b.sendMailboxMatch(ctx, commandSlice[1])
+		return
+	}
 	mailboxes := map[string]config.Room{}
 	slice := []string{}
 	b.rooms.Range(func(key any, value any) bool {
@@ -39,6 +43,10 @@ func (b *Bot) sendMailboxes(ctx context.Context) {
 		if err != nil {
 			b.log.Error().Err(err).Msg("cannot retrieve settings")
 		}
+		// aliases are listed alongside with their mailboxes
+		if config.Mailbox() != "" && config.Mailbox() != mailbox {
+			return true
+		}
 
 		mailboxes[mailbox] = config
 		slice = append(slice, mailbox)
@@ -57,14 +65,47 @@ func (b *Bot) sendMailboxes(ctx context.Context) {
 		cfg := mailboxes[mailbox]
 		msg.WriteString("* `")
 		msg.WriteString(utils.EmailsList(mailbox, cfg.Domain()))
+		for _, alias := range cfg.Aliases() {
+			msg.WriteString("`, `")
+			msg.WriteString(utils.EmailsList(alias, cfg.Domain()))
+		}
 		msg.WriteString("` by ")
 		msg.WriteString(cfg.Owner())
+		if patterns := b.routesOf(mailbox); len(patterns) > 0 {
+			msg.WriteString(", routed by `")
+			msg.WriteString(strings.Join(patterns, "`, `"))
+			msg.WriteString("`")
+		}
 		msg.WriteString("\n")
 	}
 
 	b.SendNotice(ctx, evt.RoomID, msg.String())
 }
 
+// sendMailboxMatch shows how the address is mapped to a room: exact match, subaddress, routing rule or catch-all
+func (b *Bot) sendMailboxMatch(ctx context.Context, address string) {
+	evt := eventFromContext(ctx)
+	mailbox := utils.Mailbox(address)
+	var domain string
+	if strings.Contains(address, "@") {
+		domain = utils.Hostname(address)
+	}
+	var msg string
+	if roomID, ok := b.getMapping(mailbox); ok {
+		msg = fmt.Sprintf("`%s` is an existing mailbox (or alias) of the %s room", mailbox, roomID)
+	} else if base, tag := utils.Subaddress(mailbox, b.isMapped); tag != "" {
+		msg = fmt.Sprintf("`%s` is the `%s` mailbox with the `%s` tag", mailbox, base, tag)
+	} else if route, _, routed := b.getRoute(mailbox); routed {
+		msg = fmt.Sprintf("`%s` matches the `%s` routing rule and will be delivered to the `%s` mailbox", mailbox, route.Pattern, route.Mailbox)
+	} else if catchAll := b.cfg.GetBot().CatchAllOf(domain); catchAll != "" && b.isMapped(catchAll) {
+		msg = fmt.Sprintf("`%s` doesn't match any mailbox or routing rule and will be delivered to the `%s` catch-all mailbox", mailbox, catchAll)
+	} else {
+		msg = fmt.Sprintf("`%s` doesn't match any mailbox or routing rule, emails will be rejected", mailbox)
+	}
+
+	b.SendNotice(ctx, evt.RoomID, msg)
+}
+
 func (b *Bot) runDelete(ctx context.Context, commandSlice []string) {
 	evt := eventFromContext(ctx)
 	if len(commandSlice) < 2 {
@@ -81,6 +122,11 @@ func (b *Bot) runDelete(ctx context.Context, commandSlice []string) {
 	roomID := v.(id.RoomID)
 
 	b.rooms.Delete(mailbox)
+	if cfg, err := b.cfg.GetRoom(roomID); err == nil {
+		for _, item := range cfg.Mailboxes() {
+			b.rooms.Delete(item)
+		}
+	}
 	err := b.cfg.SetRoom(roomID, config.Room{})
 	if err != nil {
 		b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
@@ -172,41 +218,102 @@ func (b *Bot) runCatchAll(ctx context.Context, commandSlice []string) {
 	evt := eventFromContext(ctx)
 	cfg := b.cfg.GetBot()
 	if len(commandSlice) < 2 {
-		var msg strings.Builder
-		msg.WriteString("Currently: `")
-		if cfg.CatchAll() != "" {
-			msg.WriteString(cfg.CatchAll())
-			msg.WriteString(" (")
-			msg.WriteString(utils.EmailsList(cfg.CatchAll(), ""))
-			msg.WriteString(")")
-		} else {
-			msg.WriteString("not set")
-		}
-		msg.WriteString("`\n\n")
-		msg.WriteString("Usage: `")
-		msg.WriteString(b.prefix)
-		msg.WriteString(" catch-all MAILBOX`")
-		msg.WriteString("where mailbox is valid and existing mailbox name\n")
-
-		b.SendNotice(ctx, evt.RoomID, msg.String())
+		b.sendCatchAll(ctx, cfg)
 		return
 	}
 
-	mailbox := utils.Mailbox(commandSlice[1])
-	_, ok := b.GetMapping(mailbox)
-	if !ok {
+	// !pm catch-all MAILBOX or !pm catch-all DOMAIN MAILBOX
+	var domain string
+	mailbox := commandSlice[1]
+	if len(commandSlice) > 2 {
+		domain = strings.ToLower(commandSlice[1])
+		mailbox = commandSlice[2]
+		if utils.SanitizeDomain(domain) != domain {
+			b.SendError(ctx, evt.RoomID, "domain is not managed by postmoogle, kupo.")
+			return
+		}
+	}
+	mailbox = utils.Mailbox(mailbox)
+	if mailbox == "reset" {
+		mailbox = ""
+	}
+	if _, ok := b.getMapping(mailbox); mailbox != "" && !ok {
 		b.SendError(ctx, evt.RoomID, "mailbox does not exist, kupo.")
 		return
 	}
 
-	cfg.Set(config.BotCatchAll, mailbox)
+	if domain == "" {
+		cfg.Set(config.BotCatchAll, mailbox)
+	} else {
+		domains := cfg.CatchAllDomains()
+		domains[domain] = mailbox
+		if mailbox == "" {
+			delete(domains, domain)
+		}
+		cfg.SetCatchAllDomains(domains)
+	}
 	err := b.cfg.SetBot(cfg)
 	if err != nil {
 		b.Error(ctx, evt.RoomID, "cannot save bot options: %v", err)
 		return
 	}
 
-	b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Catch-all is set to: `%s` (%s).", mailbox, utils.EmailsList(mailbox, "")))
+	switch {
+	case mailbox == "" && domain == "":
+		b.SendNotice(ctx, evt.RoomID, "Global catch-all has been removed.")
+	case mailbox == "":
+		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Catch-all of the `%s` domain has been removed.", domain))
+	case domain == "":
+		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Global catch-all is set to: `%s` (%s).", mailbox, utils.EmailsList(mailbox, "")))
+	default:
+		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Catch-all of the `%s` domain is set to: `%s` (%s@%s).", domain, mailbox, mailbox, domain))
+	}
+}
+
+func (b *Bot) sendCatchAll(ctx context.Context, cfg config.Bot) {
+	evt := eventFromContext(ctx)
+	var msg strings.Builder
+	msg.WriteString("Global catch-all: `")
+	if cfg.CatchAll() != "" {
+		msg.WriteString(cfg.CatchAll())
+		msg.WriteString(" (")
+		msg.WriteString(utils.EmailsList(cfg.CatchAll(), ""))
+		msg.WriteString(")")
+	} else {
+		msg.WriteString("not set")
+	}
+	msg.WriteString("`\n\n")
+
+	domains := cfg.CatchAllDomains()
+	if len(domains) > 0 {
+		msg.WriteString("Per-domain catch-alls (used instead of the global one):\n")
+		slice := make([]string, 0, len(domains))
+		for domain := range domains {
+			slice = append(slice, domain)
+		}
+		sort.Strings(slice)
+		for _, domain := range slice {
+			msg.WriteString("* `")
+			msg.WriteString(domain)
+			msg.WriteString("` ➡️ `")
+			msg.WriteString(domains[domain])
+			msg.WriteString("@")
+			msg.WriteString(domain)
+			msg.WriteString("`\n")
+		}
+		msg.WriteString("\n")
+	}
+
+	msg.WriteString("Usage:\n")
+	msg.WriteString("* `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" catch-all MAILBOX` - set global catch-all, used for all domains without their own catch-all\n")
+	msg.WriteString("* `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" catch-all DOMAIN MAILBOX` - set catch-all of the domain\n")
+	msg.WriteString("where mailbox is valid and existing mailbox name, use `reset` instead of mailbox to remove the catch-all\n")
+
+	b.SendNotice(ctx, evt.RoomID, msg.String())
 }
 
 func (b *Bot) runAdminRoom(ctx context.Context, commandSlice []string) {
@@ -245,24 +352,28 @@ func (b *Bot) runAdminRoom(ctx context.Context, commandSlice []string) {
 
 func (b *Bot) printGreylist(ctx context.Context, roomID id.RoomID) {
 	cfg := b.cfg.GetBot()
-	greylist := b.cfg.GetGreylist()
 	var msg strings.Builder
-	size := len(greylist)
 	duration := cfg.Greylist()
 	msg.WriteString("Currently: `")
 	if duration == 0 {
-		msg.WriteString("disabled")
+		msg.WriteString("disabled`")
 	} else {
-		msg.WriteString(cfg.Get(config.BotGreylist))
-		msg.WriteString("min")
-	}
-	msg.WriteString("`")
-	if size > 0 {
-		msg.WriteString(", total known: ")
-		msg.WriteString(strconv.Itoa(size))
-		msg.WriteString(" hosts (`")
-		msg.WriteString(strings.Join(greylist.Slice(), "`, `"))
-		msg.WriteString("`)\n\n")
+		delay, retry, expiry := b.getGreylistSettings()
+		msg.WriteString(delay.String())
+		msg.WriteString("` delay, `")
+		msg.WriteString(retry.String())
+		msg.WriteString("` retry window, `")
+		msg.WriteString(expiry.String())
+		msg.WriteString("` whitelist expiration")
+	}
+	greylisted := len(b.cfg.GetGreylist())
+	whitelisted := len(b.cfg.GetGreylistWhitelist())
+	if greylisted > 0 || whitelisted > 0 {
+		msg.WriteString(", total greylisted: ")
+		msg.WriteString(strconv.Itoa(greylisted))
+		msg.WriteString(", total whitelisted: ")
+		msg.WriteString(strconv.Itoa(whitelisted))
+		msg.WriteString("\n\n")
 	}
 	if duration == 0 {
 		msg.WriteString("\n\nTo enable greylist: `")
@@ -290,35 +401,110 @@ func (b *Bot) runGreylist(ctx context.Context, commandSlice []string) {
 	b.SendNotice(ctx, evt.RoomID, "greylist duration has been updated")
 }
 
-func (b *Bot) runBanlist(ctx context.Context, commandSlice []string) {
+func (b *Bot) runBotOption(ctx context.Context, commandSlice []string) {
 	evt := eventFromContext(ctx)
 	cfg := b.cfg.GetBot()
+	name := commandSlice[0]
 	if len(commandSlice) < 2 {
-		banlist := b.cfg.GetBanlist()
-		var msg strings.Builder
-		size := len(banlist)
-		if size > 0 {
-			msg.WriteString("Currently: `")
-			msg.WriteString(cfg.Get(config.BotBanlistEnabled))
-			msg.WriteString("`, total: ")
-			msg.WriteString(strconv.Itoa(size))
-			msg.WriteString(" hosts (`")
-			msg.WriteString(strings.Join(banlist.Slice(), "`, `"))
-			msg.WriteString("`)\n\n")
+		value := cfg.Get(name)
+		if value == "" {
+			value = "default"
+		}
+		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` is `%s`\n\nTo set it to a new value, send a `%s %s VALUE` command.", name, value, b.prefix, name))
+		return
+	}
+
+	value := strings.Join(commandSlice[1:], "")
+	if cmd := b.commands.get(name); cmd != nil && cmd.sanitizer != nil {
+		value = cmd.sanitizer(value)
+	}
+	cfg.Set(name, value)
+	err := b.cfg.SetBot(cfg)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot set bot config: %v", err)
+		return
+	}
+	b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("`%s` has been set to `%s`", name, value))
+}
+
+func (b *Bot) printBanlist(ctx context.Context, roomID id.RoomID) {
+	cfg := b.cfg.GetBot()
+	banlist := b.cfg.GetBanlist()
+	autobanlist := b.cfg.GetAutoBanlist()
+	autobanlist.Prune()
+	threshold, window := b.getBanlistThresholds()
+	b.offences.pruneAll(window)
+	var msg strings.Builder
+	msg.WriteString("Currently: `")
+	msg.WriteString(strconv.FormatBool(cfg.BanlistEnabled()))
+	msg.WriteString("`, automatic bans duration: `")
+	msg.WriteString(b.getBanlistTTL().String())
+	msg.WriteString("`, threshold: `")
+	msg.WriteString(strconv.Itoa(threshold))
+	msg.WriteString("` points within `")
+	msg.WriteString(window.String())
+	msg.WriteString("`\n\n")
+	msg.WriteString("Offence weights: ")
+	for i, kind := range []string{utils.OffenceAuth, utils.OffenceAddress, utils.OffenceNoUser, utils.OffenceSpam, utils.OffenceHelo} {
+		if i > 0 {
+			msg.WriteString(", ")
+		}
+		msg.WriteString("`")
+		msg.WriteString(kind)
+		msg.WriteString("=")
+		msg.WriteString(strconv.Itoa(b.getOffenceWeight(kind)))
+		msg.WriteString("`")
+	}
+	msg.WriteString("\n\n")
+	if size := len(banlist); size > 0 {
+		msg.WriteString("Permanently banned: ")
+		msg.WriteString(strconv.Itoa(size))
+		msg.WriteString(" hosts/networks (`")
+		msg.WriteString(strings.Join(banlist.Slice(), "`, `"))
+		msg.WriteString("`)\n\n")
+	}
+	if size := len(autobanlist); size > 0 {
+		msg.WriteString("Temporary banned: ")
+		msg.WriteString(strconv.Itoa(size))
+		msg.WriteString(" hosts\n")
+		for _, item := range autobanlist.Slice() {
+			msg.WriteString("* `")
+			msg.WriteString(item)
+			msg.WriteString("`\n")
 		}
-		if !cfg.BanlistEnabled() {
-			msg.WriteString("To enable banlist, send `")
-			msg.WriteString(b.prefix)
-			msg.WriteString(" banlist true`\n\n")
+		msg.WriteString("\n")
+	}
+	if offences := b.offences.slice(); len(offences) > 0 {
+		msg.WriteString("Offence counters: ")
+		msg.WriteString(strconv.Itoa(len(offences)))
+		msg.WriteString(" hosts\n")
+		for _, item := range offences {
+			msg.WriteString("* `")
+			msg.WriteString(item)
+			msg.WriteString("`\n")
 		}
-		msg.WriteString("To ban somebody: `")
+		msg.WriteString("\n")
+	}
+	if !cfg.BanlistEnabled() {
+		msg.WriteString("To enable banlist, send `")
 		msg.WriteString(b.prefix)
-		msg.WriteString(" banlist:add IP1 IP2 IP3...`")
-		msg.WriteString("where each ip is IPv4 or IPv6\n")
+		msg.WriteString(" banlist true`\n\n")
+	}
+	msg.WriteString("To ban somebody: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" banlist:add IP1 IP2 CIDR1...`")
+	msg.WriteString("where each item is IPv4 or IPv6 address or network in CIDR notation (e.g. `192.0.2.0/24`)\n")
 
-		b.SendNotice(ctx, evt.RoomID, msg.String())
+	b.SendNotice(ctx, roomID, msg.String())
+}
+
+func (b *Bot) runBanlist(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.printBanlist(ctx, evt.RoomID)
 		return
 	}
+	cfg := b.cfg.GetBot()
 	value := utils.SanitizeBoolString(commandSlice[1])
 	cfg.Set(config.BotBanlistEnabled, value)
 	err := b.cfg.SetBot(cfg)
@@ -340,14 +526,14 @@ func (b *Bot) runBanlistAdd(ctx context.Context, commandSlice []string) {
 	}
 	banlist := b.cfg.GetBanlist()
 
-	ips := commandSlice[1:]
-	for _, ip := range ips {
-		addr, err := net.ResolveIPAddr("ip", ip)
+	items := commandSlice[1:]
+	for _, item := range items {
+		ipnet, err := utils.ParseNetwork(item)
 		if err != nil {
-			b.Error(ctx, evt.RoomID, "cannot add %s to banlist: %v", ip, err)
+			b.Error(ctx, evt.RoomID, "cannot add %s to banlist: %v", item, err)
 			return
 		}
-		banlist.Add(addr)
+		banlist.AddNetwork(ipnet)
 	}
 
 	err := b.cfg.SetBanlist(banlist)
@@ -371,14 +557,16 @@ func (b *Bot) runBanlistRemove(ctx context.Context, commandSlice []string) {
 	}
 	banlist := b.cfg.GetBanlist()
 
-	ips := commandSlice[1:]
-	for _, ip := range ips {
-		addr, err := net.ResolveIPAddr("ip", ip)
+	items := commandSlice[1:]
+	networks := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		ipnet, err := utils.ParseNetwork(item)
 		if err != nil {
-			b.Error(ctx, evt.RoomID, "cannot remove %s from banlist: %v", ip, err)
+			b.Error(ctx, evt.RoomID, "cannot remove %s from banlist: %v", item, err)
 			return
 		}
-		banlist.Remove(addr)
+		banlist.RemoveNetwork(ipnet)
+		networks = append(networks, ipnet)
 	}
 
 	err := b.cfg.SetBanlist(banlist)
@@ -386,6 +574,16 @@ func (b *Bot) runBanlistRemove(ctx context.Context, commandSlice []string) {
 		b.Error(ctx, evt.RoomID, "cannot set banlist: %v", err)
 		return
 	}
+	err = b.cfg.UpdateAutoBanlist(func(autobanlist config.ExpiringList) bool {
+		for _, ipnet := range networks {
+			autobanlist.RemoveNetwork(ipnet)
+		}
+		return true
+	})
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot set automatic banlist: %v", err)
+		return
+	}
 
 	b.SendNotice(ctx, evt.RoomID, "banlist has been updated, kupo")
 }
@@ -402,6 +600,12 @@ func (b *Bot) runBanlistReset(ctx context.Context) {
 		b.Error(ctx, evt.RoomID, "cannot set banlist: %v", err)
 		return
 	}
+	err = b.cfg.SetAutoBanlist(config.ExpiringList{})
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot set automatic banlist: %v", err)
+		return
+	}
+	b.offences.clear()
 
 	b.SendNotice(ctx, evt.RoomID, "banlist has been reset, kupo")
 }
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/raja/argon2pw"
+	"maunium.net/go/mautrix/id"
 
 	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
 	"gitlab.com/etke.cc/postmoogle/utils"
 )
 
@@ -19,13 +22,15 @@ func (b *Bot) runStop(ctx context.Context) {
 		return
 	}
 
-	mailbox := cfg.Get(config.RoomMailbox)
-	if mailbox == "" {
+	mailboxes := cfg.Mailboxes()
+	if len(mailboxes) == 0 {
 		b.SendNotice(ctx, evt.RoomID, "that room is not configured yet")
 		return
 	}
 
-	b.rooms.Delete(mailbox)
+	for _, mailbox := range mailboxes {
+		b.rooms.Delete(mailbox)
+	}
 
 	err = b.cfg.SetRoom(evt.RoomID, config.Room{})
 	if err != nil {
@@ -64,6 +69,13 @@ func (b *Bot) getOption(ctx context.Context, name string) {
 	if name == config.RoomMailbox {
 		value = utils.EmailsList(value, cfg.Domain())
 	}
+	if name == config.RoomAliases {
+		aliases := cfg.Aliases()
+		for i, alias := range aliases {
+			aliases[i] = utils.EmailsList(alias, cfg.Domain())
+		}
+		value = strings.Join(aliases, "`, `")
+	}
 
 	msg := fmt.Sprintf("`%s` of this room is `%s`\n"+
 		"To set it to a new value, send a `%s %s VALUE` command.",
@@ -91,12 +103,56 @@ func (b *Bot) setOption(ctx context.Context, name, value string) {
 		return
 	}
 	if name == config.RoomMailbox {
-		existingID, ok := b.getMapping(value)
-		if (ok && existingID != "" && existingID != evt.RoomID) || b.isReserved(value) {
+		if !b.isAvailable(evt.RoomID, value) {
 			b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Mailbox `%s` (%s) already taken, kupo", value, utils.EmailsList(value, "")))
 			return
 		}
 	}
+	if name == config.RoomAliases {
+		aliases := utils.StringSlice(value)
+		for i, alias := range aliases {
+			alias = utils.Mailbox(strings.TrimSpace(alias))
+			if !b.isAvailable(evt.RoomID, alias) {
+				b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Mailbox `%s` (%s) already taken, kupo", alias, utils.EmailsList(alias, "")))
+				return
+			}
+			aliases[i] = alias
+		}
+		value = strings.Join(aliases, ",")
+	}
+	if name == config.RoomVIP {
+		// get original value, without forced lower case
+		patterns := config.SplitPatterns(strings.Join(b.parseCommand(evt.Content.AsMessage().Body, false)[1:], " "))
+		for _, pattern := range patterns {
+			if err := config.ValidatePattern(pattern); err != nil {
+				b.SendError(ctx, evt.RoomID, err.Error())
+				return
+			}
+		}
+		value = strings.Join(patterns, ",")
+	}
+	if name == config.RoomVIPMentions {
+		mentions := utils.StringSlice(value)
+		for i, mention := range mentions {
+			mention = strings.TrimSpace(mention)
+			if mention != vipMentionRoom {
+				if _, _, err := id.UserID(mention).Parse(); err != nil {
+					b.SendError(ctx, evt.RoomID, fmt.Sprintf("`%s` is neither a valid user ID nor %s, kupo", mention, vipMentionRoom))
+					return
+				}
+			}
+			mentions[i] = mention
+		}
+		value = strings.Join(mentions, ",")
+	}
+	if name == config.RoomForward {
+		for _, address := range utils.StringSlice(value) {
+			if !email.AddressValid(address) {
+				b.SendError(ctx, evt.RoomID, fmt.Sprintf("`%s` is not a valid email address, kupo", address))
+				return
+			}
+		}
+	}
 
 	cfg, err := b.cfg.GetRoom(evt.RoomID)
 	if err != nil {
@@ -116,6 +172,17 @@ func (b *Bot) setOption(ctx context.Context, name, value string) {
 	old := cfg.Get(name)
 	cfg.Set(name, value)
 
+	if name == config.RoomAliases {
+		for _, alias := range utils.StringSlice(old) {
+			b.rooms.Delete(alias)
+		}
+		if cfg.Active() {
+			for _, alias := range cfg.Aliases() {
+				b.rooms.Store(alias, evt.RoomID)
+			}
+		}
+	}
+
 	if name == config.RoomMailbox {
 		cfg.Set(config.RoomOwner, evt.Sender.String())
 		if old != "" {
@@ -123,6 +190,11 @@ func (b *Bot) setOption(ctx context.Context, name, value string) {
 		}
 		active := b.ActivateMailbox(evt.Sender, evt.RoomID, value)
 		cfg.Set(config.RoomActive, strconv.FormatBool(active))
+		if active {
+			for _, alias := range cfg.Aliases() {
+				b.rooms.Store(alias, evt.RoomID)
+			}
+		}
 		value = fmt.Sprintf("%s@%s", value, utils.SanitizeDomain(cfg.Domain()))
 	}
 
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"sort"
 	"strings"
 
 	"maunium.net/go/mautrix/id"
@@ -16,12 +17,24 @@ const (
 	BotAdminRoom           = "adminroom"
 	BotUsers               = "users"
 	BotCatchAll            = "catch-all"
+	BotCatchAllDomains     = "catch-all:domains"
 	BotDKIMSignature       = "dkim.pub"
 	BotDKIMPrivateKey      = "dkim.pem"
+	BotSRSSecret           = "srs.secret"
 	BotQueueBatch          = "queue:batch"
 	BotQueueRetries        = "queue:retries"
+	BotVERP                = "verp"
+	BotAuthAttempts        = "auth:attempts"
+	BotAuthLockout         = "auth:lockout"
 	BotBanlistEnabled      = "banlist:enabled"
+	BotBanlistTTL          = "banlist:ttl"
+	BotBanlistThreshold    = "banlist:threshold"
+	BotBanlistWindow       = "banlist:window"
+	BotBanlistWeights      = "banlist:weights"
 	BotGreylist            = "greylist"
+	BotGreylistRetry       = "greylist:retry"
+	BotGreylistExpiry      = "greylist:expiry"
+	BotGreylistProviders   = "greylist:providers"
 	BotMautrix015Migration = "mautrix015migration"
 )
 
@@ -57,31 +70,120 @@ func (s Bot) Users() []string {
 	return []string{value}
 }
 
-// CatchAll option
+// CatchAll option (global catch-all mailbox, used as a fallback for domains without their own catch-all)
 func (s Bot) CatchAll() string {
 	return s.Get(BotCatchAll)
 }
 
+// CatchAllDomains option (domain=mailbox pairs)
+func (s Bot) CatchAllDomains() map[string]string {
+	pairs := utils.StringSlice(s.Get(BotCatchAllDomains))
+	domains := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		domain, mailbox, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		domains[strings.TrimSpace(domain)] = strings.TrimSpace(mailbox)
+	}
+
+	return domains
+}
+
+// SetCatchAllDomains option
+func (s Bot) SetCatchAllDomains(domains map[string]string) {
+	pairs := make([]string, 0, len(domains))
+	for domain, mailbox := range domains {
+		pairs = append(pairs, domain+"="+mailbox)
+	}
+	sort.Strings(pairs)
+	s.Set(BotCatchAllDomains, strings.Join(pairs, ","))
+}
+
+// CatchAllOf returns catch-all mailbox of the domain, or the global catch-all mailbox
+func (s Bot) CatchAllOf(domain string) string {
+	if mailbox := s.CatchAllDomains()[strings.ToLower(domain)]; mailbox != "" {
+		return mailbox
+	}
+
+	return s.CatchAll()
+}
+
 // AdminRoom option
 func (s Bot) AdminRoom() id.RoomID {
 	return id.RoomID(s.Get(BotAdminRoom))
 }
 
+// AuthAttempts option (count of failed SMTP logins before mailbox lockout)
+func (s Bot) AuthAttempts() int {
+	return utils.Int(s.Get(BotAuthAttempts))
+}
+
+// AuthLockout option (duration of mailbox lockout in minutes)
+func (s Bot) AuthLockout() int {
+	return utils.Int(s.Get(BotAuthLockout))
+}
+
+// VERP option (use VERP envelope sender for outgoing emails)
+func (s Bot) VERP() bool {
+	return utils.Bool(s.Get(BotVERP))
+}
+
 // BanlistEnabled option
 func (s Bot) BanlistEnabled() bool {
 	return utils.Bool(s.Get(BotBanlistEnabled))
 }
 
+// BanlistTTL option (duration of automatic bans in hours)
+func (s Bot) BanlistTTL() int {
+	return utils.Int(s.Get(BotBanlistTTL))
+}
+
+// BanlistThreshold option (score of a host, after which it will be banned)
+func (s Bot) BanlistThreshold() int {
+	return utils.Int(s.Get(BotBanlistThreshold))
+}
+
+// BanlistWindow option (sliding window of offences in minutes)
+func (s Bot) BanlistWindow() int {
+	return utils.Int(s.Get(BotBanlistWindow))
+}
+
+// BanlistWeights option (offence=points pairs)
+func (s Bot) BanlistWeights() []string {
+	return utils.StringSlice(s.Get(BotBanlistWeights))
+}
+
 // Greylist option (duration in minutes)
 func (s Bot) Greylist() int {
 	return utils.Int(s.Get(BotGreylist))
 }
 
+// GreylistRetry option (retry window in hours)
+func (s Bot) GreylistRetry() int {
+	return utils.Int(s.Get(BotGreylistRetry))
+}
+
+// GreylistExpiry option (whitelist expiration in days)
+func (s Bot) GreylistExpiry() int {
+	return utils.Int(s.Get(BotGreylistExpiry))
+}
+
+// GreylistProviders option (well-known email providers' domains)
+func (s Bot) GreylistProviders() []string {
+	return utils.StringSlice(s.Get(BotGreylistProviders))
+}
+
 // DKIMSignature (DNS TXT record)
 func (s Bot) DKIMSignature() string {
 	return s.Get(BotDKIMSignature)
 }
 
+// SRSSecret of the Sender Rewriting Scheme, keep it secret
+func (s Bot) SRSSecret() string {
+	return s.Get(BotSRSSecret)
+}
+
 // DKIMPrivateKey keep it secret
 func (s Bot) DKIMPrivateKey() string {
 	return s.Get(BotDKIMPrivateKey)
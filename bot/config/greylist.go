@@ -0,0 +1,72 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// account data key
+const acGreylistWhitelistKey = "cc.etke.postmoogle.greylist.whitelist"
+
+// Greylist config, map of key = timestamp, where key is either
+// greylisted triplet (network, sender, recipient) or whitelisted pair (network, sender)
+type Greylist map[string]string
+
+// GreylistTriplet returns greylist key of (network, sender, recipient) triplet
+func GreylistTriplet(network, from, to string) string {
+	return strings.Join([]string{network, strings.ToLower(from), strings.ToLower(to)}, "|")
+}
+
+// GreylistPair returns whitelist key of (network, sender) pair
+func GreylistPair(network, from string) string {
+	return network + "|" + strings.ToLower(from)
+}
+
+// Get when the key was added or seen last time
+func (g Greylist) Get(key string) (time.Time, bool) {
+	value := g[key]
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC1123Z, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// Whitelisted checks if (network, sender) pair is whitelisted, returns the matched key
+func (g Greylist) Whitelisted(network, from string) (string, bool) {
+	key := GreylistPair(network, from)
+	if _, ok := g.Get(key); ok {
+		return key, true
+	}
+
+	return "", false
+}
+
+// Set key's timestamp
+func (g Greylist) Set(key string, t time.Time) {
+	g[key] = t.UTC().Format(time.RFC1123Z)
+}
+
+// Remove the key
+func (g Greylist) Remove(key string) {
+	delete(g, key)
+}
+
+// Prune entries older than maxAge and entries with invalid keys (e.g. from older versions), returns count of removed entries
+func (g Greylist) Prune(maxAge time.Duration) int {
+	var count int
+	now := time.Now().UTC()
+	for key := range g {
+		t, ok := g.Get(key)
+		if !ok || !strings.Contains(key, "|") || t.Add(maxAge).Before(now) {
+			delete(g, key)
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGreylistWhitelisted(t *testing.T) {
+	whitelist := Greylist{}
+	whitelist.Set(GreylistPair("192.0.2.0/24", "Sender@Example.com"), time.Now())
+	whitelist.Set("*|other@example.com", time.Now())
+
+	if key, ok := whitelist.Whitelisted("192.0.2.0/24", "sender@example.com"); !ok || key != "192.0.2.0/24|sender@example.com" {
+		t.Errorf("Whitelisted() = %q, %t, want the pair", key, ok)
+	}
+	if _, ok := whitelist.Whitelisted("198.51.100.0/24", "sender@example.com"); ok {
+		t.Error("Whitelisted() from another network = true, want false")
+	}
+	if _, ok := whitelist.Whitelisted("198.51.100.0/24", "other@example.com"); ok {
+		t.Error("Whitelisted() of any network entry = true, want false")
+	}
+}
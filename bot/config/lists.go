@@ -3,6 +3,7 @@ package config
 import (
 	"net"
 	"sort"
+	"strings"
 	"time"
 
 	"gitlab.com/etke.cc/postmoogle/utils"
@@ -10,11 +11,12 @@ import (
 
 // account data keys
 const (
-	acBanlistKey  = "cc.etke.postmoogle.banlist"
-	acGreylistKey = "cc.etke.postmoogle.greylist"
+	acBanlistKey     = "cc.etke.postmoogle.banlist"
+	acAutoBanlistKey = "cc.etke.postmoogle.banlist.auto"
+	acGreylistKey    = "cc.etke.postmoogle.greylist"
 )
 
-// List config
+// List config, map of IP or CIDR = timestamp
 type List map[string]string
 
 // Slice returns slice of ban- or greylist items
@@ -28,10 +30,31 @@ func (l List) Slice() []string {
 	return slice
 }
 
-// Has addr in ban- or greylist
+// Has addr in ban- or greylist, either as is or within one of the networks
 func (l List) Has(addr net.Addr) bool {
-	_, ok := l[utils.AddrIP(addr)]
-	return ok
+	key := utils.AddrIP(addr)
+	if _, ok := l[key]; ok {
+		return true
+	}
+
+	ip := net.ParseIP(key)
+	if ip == nil {
+		return false
+	}
+	for item := range l {
+		if !strings.Contains(item, "/") {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(item)
+		if err != nil {
+			continue
+		}
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Get when addr was added in ban- or greylist
@@ -58,6 +81,16 @@ func (l List) Add(addr net.Addr) {
 	l[key] = time.Now().UTC().Format(time.RFC1123Z)
 }
 
+// AddNetwork adds an IP or CIDR network to ban- or greylist
+func (l List) AddNetwork(ipnet *net.IPNet) {
+	key := networkKey(ipnet)
+	if _, ok := l[key]; ok {
+		return
+	}
+
+	l[key] = time.Now().UTC().Format(time.RFC1123Z)
+}
+
 // Remove an addr from ban- or greylist
 func (l List) Remove(addr net.Addr) {
 	key := utils.AddrIP(addr)
@@ -67,3 +100,91 @@ func (l List) Remove(addr net.Addr) {
 
 	delete(l, key)
 }
+
+// RemoveNetwork removes an IP or CIDR network from ban- or greylist
+func (l List) RemoveNetwork(ipnet *net.IPNet) {
+	delete(l, networkKey(ipnet))
+}
+
+// ExpiringList config, map of IP = "expiration timestamp|reason"
+type ExpiringList map[string]string
+
+// Slice returns slice of items with expiration and reason
+func (l ExpiringList) Slice() []string {
+	slice := make([]string, 0, len(l))
+	for item := range l {
+		expires, reason, ok := l.get(item)
+		if !ok {
+			continue
+		}
+		slice = append(slice, item+" until "+expires.Format(time.RFC1123Z)+" ("+reason+")")
+	}
+	sort.Strings(slice)
+
+	return slice
+}
+
+// Has addr in the list and it's not expired yet
+func (l ExpiringList) Has(addr net.Addr) bool {
+	expires, _, ok := l.get(utils.AddrIP(addr))
+	if !ok {
+		return false
+	}
+
+	return expires.After(time.Now().UTC())
+}
+
+// Add an addr to the list for ttl with a reason
+func (l ExpiringList) Add(addr net.Addr, ttl time.Duration, reason string) {
+	expires := time.Now().UTC().Add(ttl).Format(time.RFC1123Z)
+	l[utils.AddrIP(addr)] = expires + "|" + reason
+}
+
+// RemoveNetwork removes all IPs within the network from the list
+func (l ExpiringList) RemoveNetwork(ipnet *net.IPNet) {
+	for item := range l {
+		ip := net.ParseIP(item)
+		if ip != nil && ipnet.Contains(ip) {
+			delete(l, item)
+		}
+	}
+}
+
+// Prune expired (and invalid) items, returns count of removed items
+func (l ExpiringList) Prune() int {
+	var count int
+	now := time.Now().UTC()
+	for item := range l {
+		expires, _, ok := l.get(item)
+		if !ok || expires.Before(now) {
+			delete(l, item)
+			count++
+		}
+	}
+
+	return count
+}
+
+func (l ExpiringList) get(key string) (time.Time, string, bool) {
+	value := l[key]
+	if value == "" {
+		return time.Time{}, "", false
+	}
+	expiresStr, reason, _ := strings.Cut(value, "|")
+	expires, err := time.Parse(time.RFC1123Z, expiresStr)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	return expires, reason, true
+}
+
+// networkKey returns list key of a network: plain IP for single-address networks and CIDR for others
+func networkKey(ipnet *net.IPNet) string {
+	ones, bits := ipnet.Mask.Size()
+	if ones == bits {
+		return ipnet.IP.String()
+	}
+
+	return ipnet.String()
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/rs/zerolog"
 	"gitlab.com/etke.cc/linkpearl"
 	"maunium.net/go/mautrix/id"
@@ -13,6 +16,12 @@ type Manager struct {
 	mu  utils.Mutex
 	log *zerolog.Logger
 	lp  *linkpearl.Linkpearl
+
+	// autoBanlistMu guards read-modify-write of the automatic banlist
+	autoBanlistMu sync.Mutex
+	// routes is the cache of compiled routing rules, nil = not loaded yet
+	routes   []*Route
+	routesMu sync.Mutex
 }
 
 // New config manager
@@ -96,14 +105,72 @@ func (m *Manager) SetBanlist(cfg List) error {
 	return utils.UnwrapError(m.lp.SetAccountData(acBanlistKey, cfg))
 }
 
+// GetAutoBanlist config
+func (m *Manager) GetAutoBanlist() ExpiringList {
+	if !m.GetBot().BanlistEnabled() {
+		return make(ExpiringList, 0)
+	}
+
+	m.autoBanlistMu.Lock()
+	defer m.autoBanlistMu.Unlock()
+	return m.getAutoBanlist()
+}
+
+// SetAutoBanlist config
+func (m *Manager) SetAutoBanlist(cfg ExpiringList) error {
+	if !m.GetBot().BanlistEnabled() {
+		return nil
+	}
+
+	m.autoBanlistMu.Lock()
+	defer m.autoBanlistMu.Unlock()
+	return m.setAutoBanlist(cfg)
+}
+
+// UpdateAutoBanlist reads, updates and saves the automatic banlist under a single lock,
+// the update func returns false if the banlist has not been changed
+func (m *Manager) UpdateAutoBanlist(update func(ExpiringList) bool) error {
+	if !m.GetBot().BanlistEnabled() {
+		return nil
+	}
+
+	m.autoBanlistMu.Lock()
+	defer m.autoBanlistMu.Unlock()
+	banlist := m.getAutoBanlist()
+	if !update(banlist) {
+		return nil
+	}
+	return m.setAutoBanlist(banlist)
+}
+
+func (m *Manager) getAutoBanlist() ExpiringList {
+	config, err := m.lp.GetAccountData(acAutoBanlistKey)
+	if err != nil {
+		m.log.Error().Err(utils.UnwrapError(err)).Msg("cannot get automatic banlist")
+	}
+	if config == nil {
+		config = make(ExpiringList, 0)
+		return config
+	}
+	return config
+}
+
+func (m *Manager) setAutoBanlist(cfg ExpiringList) error {
+	if cfg == nil {
+		cfg = make(ExpiringList, 0)
+	}
+
+	return utils.UnwrapError(m.lp.SetAccountData(acAutoBanlistKey, cfg))
+}
+
 // GetGreylist config
-func (m *Manager) GetGreylist() List {
+func (m *Manager) GetGreylist() Greylist {
 	config, err := m.lp.GetAccountData(acGreylistKey)
 	if err != nil {
-		m.log.Error().Err(utils.UnwrapError(err)).Msg("cannot get banlist")
+		m.log.Error().Err(utils.UnwrapError(err)).Msg("cannot get greylist")
 	}
 	if config == nil {
-		config = make(List, 0)
+		config = make(Greylist, 0)
 		return config
 	}
 
@@ -111,6 +178,113 @@ func (m *Manager) GetGreylist() List {
 }
 
 // SetGreylist config
-func (m *Manager) SetGreylist(cfg List) error {
+func (m *Manager) SetGreylist(cfg Greylist) error {
 	return utils.UnwrapError(m.lp.SetAccountData(acGreylistKey, cfg))
 }
+
+// GetGreylistWhitelist config
+func (m *Manager) GetGreylistWhitelist() Greylist {
+	config, err := m.lp.GetAccountData(acGreylistWhitelistKey)
+	if err != nil {
+		m.log.Error().Err(utils.UnwrapError(err)).Msg("cannot get greylist whitelist")
+	}
+	if config == nil {
+		config = make(Greylist, 0)
+		return config
+	}
+
+	return config
+}
+
+// SetGreylistWhitelist config
+func (m *Manager) SetGreylistWhitelist(cfg Greylist) error {
+	return utils.UnwrapError(m.lp.SetAccountData(acGreylistWhitelistKey, cfg))
+}
+
+// GetRoutes config
+func (m *Manager) GetRoutes() Routes {
+	config, err := m.lp.GetAccountData(acRoutesKey)
+	if err != nil {
+		m.log.Error().Err(utils.UnwrapError(err)).Msg("cannot get routes")
+	}
+	if config == nil {
+		config = make(Routes, 0)
+		return config
+	}
+
+	return config
+}
+
+// GetCompiledRoutes returns cached ordered list of valid routing rules, the returned rules must not be modified
+func (m *Manager) GetCompiledRoutes() []*Route {
+	m.routesMu.Lock()
+	defer m.routesMu.Unlock()
+	if m.routes != nil {
+		return m.routes
+	}
+
+	config, err := m.lp.GetAccountData(acRoutesKey)
+	if err != nil {
+		m.log.Error().Err(utils.UnwrapError(err)).Msg("cannot get routes")
+		return Routes(config).Slice()
+	}
+	m.routes = Routes(config).Slice()
+
+	return m.routes
+}
+
+// UpdateRoutes modifies routing rules under the lock, so concurrent changes are not lost.
+// Routes are not changed if the update returns an error, the error is returned as is
+func (m *Manager) UpdateRoutes(update func([]*Route) ([]*Route, error)) error {
+	m.routesMu.Lock()
+	defer m.routesMu.Unlock()
+
+	config, err := m.lp.GetAccountData(acRoutesKey)
+	if err != nil {
+		return fmt.Errorf("cannot get routes: %w", utils.UnwrapError(err))
+	}
+	routes, err := update(Routes(config).Slice())
+	if err != nil {
+		return err
+	}
+
+	m.routes = nil
+	if err := m.lp.SetAccountData(acRoutesKey, NewRoutes(routes)); err != nil {
+		return fmt.Errorf("cannot set routes: %w", utils.UnwrapError(err))
+	}
+	return nil
+}
+
+// GetRules config of the room
+func (m *Manager) GetRules(roomID id.RoomID) Rules {
+	config, err := m.lp.GetRoomAccountData(roomID, acRulesKey)
+	if err != nil {
+		m.log.Error().Err(utils.UnwrapError(err)).Msg("cannot get rules")
+	}
+	if config == nil {
+		config = make(Rules, 0)
+		return config
+	}
+
+	return config
+}
+
+// SetRules config of the room
+func (m *Manager) SetRules(roomID id.RoomID, cfg Rules) error {
+	return utils.UnwrapError(m.lp.SetRoomAccountData(roomID, acRulesKey, cfg))
+}
+
+// GetSieve script of the room
+func (m *Manager) GetSieve(roomID id.RoomID) string {
+	config, err := m.lp.GetRoomAccountData(roomID, acSieveKey)
+	if err != nil {
+		m.log.Error().Err(utils.UnwrapError(err)).Msg("cannot get sieve script")
+	}
+
+	return config["script"]
+}
+
+// SetSieve script of the room
+func (m *Manager) SetSieve(roomID id.RoomID, script string) error {
+	return utils.UnwrapError(m.lp.SetRoomAccountData(roomID, acSieveKey, map[string]string{"script": script}))
+}
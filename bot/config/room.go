@@ -2,38 +2,85 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"gitlab.com/etke.cc/postmoogle/email"
 	"gitlab.com/etke.cc/postmoogle/utils"
 )
 
-// account data key
-const acRoomKey = "cc.etke.postmoogle.settings"
+// account data keys
+const (
+	acRoomKey  = "cc.etke.postmoogle.settings"
+	acSieveKey = "cc.etke.postmoogle.sieve"
+)
+
+// RoomTagThread is a tag rule target to post emails with the tag into a dedicated thread
+const RoomTagThread = "thread"
 
 type Room map[string]string
 
 // option keys
 const (
-	RoomActive        = ".active"
-	RoomOwner         = "owner"
-	RoomMailbox       = "mailbox"
-	RoomDomain        = "domain"
-	RoomNoSend        = "nosend"
-	RoomNoReplies     = "noreplies"
-	RoomNoCC          = "nocc"
-	RoomNoSender      = "nosender"
-	RoomNoRecipient   = "norecipient"
-	RoomNoSubject     = "nosubject"
-	RoomNoHTML        = "nohtml"
-	RoomNoThreads     = "nothreads"
-	RoomNoFiles       = "nofiles"
-	RoomNoInlines     = "noinlines"
-	RoomPassword      = "password"
-	RoomSpamcheckDKIM = "spamcheck:dkim"
-	RoomSpamcheckSMTP = "spamcheck:smtp"
-	RoomSpamcheckSPF  = "spamcheck:spf"
-	RoomSpamcheckMX   = "spamcheck:mx"
-	RoomSpamlist      = "spamlist"
+	RoomActive             = ".active"
+	RoomOwner              = "owner"
+	RoomMailbox            = "mailbox"
+	RoomAliases            = "aliases"
+	RoomTags               = "tags"
+	RoomSubscribers        = "subscribers"
+	RoomSubscribersPending = "subscribers:pending"
+	RoomDomain             = "domain"
+	RoomNoSend             = "nosend"
+	RoomNoReplies          = "noreplies"
+	RoomNoCC               = "nocc"
+	RoomNoSender           = "nosender"
+	RoomNoRecipient        = "norecipient"
+	RoomNoSubject          = "nosubject"
+	RoomNoHTML             = "nohtml"
+	RoomNoThreads          = "nothreads"
+	RoomNoFiles            = "nofiles"
+	RoomNoInlines          = "noinlines"
+	RoomEML                = "eml"
+	RoomMaxSize            = "maxsize"
+	RoomRetention          = "retention"
+	RoomPassword           = "password"
+	RoomSpamcheckDKIM      = "spamcheck:dkim"
+	RoomSpamcheckSMTP      = "spamcheck:smtp"
+	RoomSpamcheckSPF       = "spamcheck:spf"
+	RoomSpamcheckMX        = "spamcheck:mx"
+	RoomSpamlist           = "spamlist"
+	RoomAutoreply          = "autoreply"
+	RoomAutoreplySubject   = "autoreply:subject"
+	RoomAutoreplyBody      = "autoreply:body"
+	RoomAutoreplyStart     = "autoreply:start"
+	RoomAutoreplyEnd       = "autoreply:end"
+	RoomAutoreplyInterval  = "autoreply:interval"
+	RoomForward            = "forward"
+	RoomList               = "list"
+	RoomListMembers        = "list:members"
+	RoomDigest             = "digest"
+	RoomVIP                = "vip"
+	RoomVIPPriority        = "vip:priority"
+	RoomVIPMentions        = "vip:mentions"
+	RoomTemplate           = "template"
+	RoomTemplateHTML       = "template:html"
+)
+
+// digest periods
+const (
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+)
+
+// max size of the rendered matrix message (KiB), homeserver's event size limit is 64 KiB
+const (
+	defaultMaxSize = 48
+	maxMaxSize     = 60
+)
+
+const (
+	// AutoreplyDateFormat is the format of autoreply start and end dates
+	AutoreplyDateFormat      = "2006-01-02"
+	defaultAutoreplyInterval = 7
 )
 
 // Get option
@@ -50,6 +97,46 @@ func (s Room) Mailbox() string {
 	return s.Get(RoomMailbox)
 }
 
+// Aliases of the mailbox
+func (s Room) Aliases() []string {
+	return utils.StringSlice(s.Get(RoomAliases))
+}
+
+// Mailboxes returns the mailbox and its aliases
+func (s Room) Mailboxes() []string {
+	mailbox := s.Mailbox()
+	if mailbox == "" {
+		return nil
+	}
+
+	return append([]string{mailbox}, s.Aliases()...)
+}
+
+// Tags rules of subaddressed emails, map of tag = target (thread or mailbox)
+func (s Room) Tags() map[string]string {
+	rules := utils.StringSlice(s.Get(RoomTags))
+	tags := make(map[string]string, len(rules))
+	for _, rule := range rules {
+		tag, target, ok := strings.Cut(rule, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.TrimSpace(tag)] = strings.TrimSpace(target)
+	}
+
+	return tags
+}
+
+// Subscribers of the mailbox, list of room IDs that receive read-only copies of emails
+func (s Room) Subscribers() []string {
+	return utils.StringSlice(s.Get(RoomSubscribers))
+}
+
+// SubscribersPending list of room IDs waiting for owner's approval of subscription
+func (s Room) SubscribersPending() []string {
+	return utils.StringSlice(s.Get(RoomSubscribersPending))
+}
+
 func (s Room) Domain() string {
 	return s.Get(RoomDomain)
 }
@@ -106,6 +193,29 @@ func (s Room) NoInlines() bool {
 	return utils.Bool(s.Get(RoomNoInlines))
 }
 
+// EML option (attach the original email as .eml file)
+func (s Room) EML() bool {
+	return utils.Bool(s.Get(RoomEML))
+}
+
+// Retention option (days to retain the original emails for the `source` and `headers` commands, 0 = disabled)
+func (s Room) Retention() int {
+	return utils.Int(s.Get(RoomRetention))
+}
+
+// MaxSize of the rendered matrix message in bytes, bigger messages are truncated
+func (s Room) MaxSize() int {
+	size := utils.Int(s.Get(RoomMaxSize))
+	if size <= 0 {
+		size = defaultMaxSize
+	}
+	if size > maxMaxSize {
+		size = maxMaxSize
+	}
+
+	return size * 1024
+}
+
 func (s Room) SpamcheckDKIM() bool {
 	return utils.Bool(s.Get(RoomSpamcheckDKIM))
 }
@@ -126,6 +236,106 @@ func (s Room) Spamlist() []string {
 	return utils.StringSlice(s.Get(RoomSpamlist))
 }
 
+// Digest option (hourly, daily or count of emails), empty = emails are posted immediately
+func (s Room) Digest() string {
+	return s.Get(RoomDigest)
+}
+
+// DigestCount returns count of emails the digest is sent after, 0 = digest is sent by schedule
+func (s Room) DigestCount() int {
+	return utils.Int(s.Digest())
+}
+
+// Template option (text/template of the matrix message, markdown)
+func (s Room) Template() string {
+	return s.Get(RoomTemplate)
+}
+
+// TemplateHTML option (text/template of the matrix message's HTML)
+func (s Room) TemplateHTML() string {
+	return s.Get(RoomTemplateHTML)
+}
+
+// VIP option (sender patterns, wildcards or /regular expressions/)
+func (s Room) VIP() []string {
+	return SplitPatterns(s.Get(RoomVIP))
+}
+
+// VIPPriority option (urgent and high priority emails are treated as VIP)
+func (s Room) VIPPriority() bool {
+	return utils.Bool(s.Get(RoomVIPPriority))
+}
+
+// VIPMentions option (user IDs or @room to mention in VIP emails), empty = room owner
+func (s Room) VIPMentions() []string {
+	return utils.StringSlice(s.Get(RoomVIPMentions))
+}
+
+// List option (mailing list mode, emails are redistributed to the list members)
+func (s Room) List() bool {
+	return utils.Bool(s.Get(RoomList))
+}
+
+// ListMembers option (external addresses subscribed to the mailing list)
+func (s Room) ListMembers() []string {
+	return utils.StringSlice(s.Get(RoomListMembers))
+}
+
+// Forward option (external addresses that receive copies of incoming emails)
+func (s Room) Forward() []string {
+	return utils.StringSlice(s.Get(RoomForward))
+}
+
+// Autoreply option (out-of-office auto-responder is enabled)
+func (s Room) Autoreply() bool {
+	return utils.Bool(s.Get(RoomAutoreply))
+}
+
+// AutoreplySubject option
+func (s Room) AutoreplySubject() string {
+	return s.Get(RoomAutoreplySubject)
+}
+
+// AutoreplyBody option
+func (s Room) AutoreplyBody() string {
+	return s.Get(RoomAutoreplyBody)
+}
+
+// AutoreplyStart option (the first day of autoreply period), zero time if not set
+func (s Room) AutoreplyStart() time.Time {
+	start, _ := time.Parse(AutoreplyDateFormat, s.Get(RoomAutoreplyStart)) //nolint:errcheck // zero time = no start date
+	return start
+}
+
+// AutoreplyEnd option (the last day of autoreply period), zero time if not set
+func (s Room) AutoreplyEnd() time.Time {
+	end, _ := time.Parse(AutoreplyDateFormat, s.Get(RoomAutoreplyEnd)) //nolint:errcheck // zero time = no end date
+	return end
+}
+
+// AutoreplyInterval option (days between autoreplies to the same sender), 7 by default
+func (s Room) AutoreplyInterval() int {
+	interval := utils.Int(s.Get(RoomAutoreplyInterval))
+	if interval < 1 {
+		return defaultAutoreplyInterval
+	}
+	return interval
+}
+
+// AutoreplyActive checks if autoreply is enabled and the time is within autoreply period
+func (s Room) AutoreplyActive(now time.Time) bool {
+	if !s.Autoreply() || s.AutoreplyBody() == "" {
+		return false
+	}
+	if start := s.AutoreplyStart(); !start.IsZero() && now.Before(start) {
+		return false
+	}
+	if end := s.AutoreplyEnd(); !end.IsZero() && !now.Before(end.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
 func (s Room) MigrateSpamlistSettings() {
 	uniq := map[string]struct{}{}
 	emails := utils.StringSlice(s.Get("spamlist:emails"))
@@ -181,6 +391,9 @@ func (s Room) ContentOptions() *email.ContentOptions {
 		Subject:   !s.NoSubject(),
 		Threads:   !s.NoThreads(),
 
+		Template:     s.Template(),
+		HTMLTemplate: s.TemplateHTML(),
+
 		ToKey:         "cc.etke.postmoogle.to",
 		CcKey:         "cc.etke.postmoogle.cc",
 		FromKey:       "cc.etke.postmoogle.from",
@@ -189,5 +402,6 @@ func (s Room) ContentOptions() *email.ContentOptions {
 		InReplyToKey:  "cc.etke.postmoogle.inReplyTo",
 		MessageIDKey:  "cc.etke.postmoogle.messageID",
 		ReferencesKey: "cc.etke.postmoogle.references",
+		TagKey:        "cc.etke.postmoogle.tag",
 	}
 }
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// account data key
+const acRoutesKey = "cc.etke.postmoogle.routes"
+
+// Routes config, map of position = "pattern mailbox"
+type Routes map[string]string
+
+// Route is a pattern-based mailbox routing rule
+type Route struct {
+	// Pattern is either a wildcard (alerts-*) or a regular expression within slashes (/^invoice\..+$/)
+	Pattern string
+	// Mailbox the matched emails will be delivered to
+	Mailbox string
+
+	re *regexp.Regexp
+}
+
+// NewRoute parses and validates routing rule
+func NewRoute(pattern, mailbox string) (*Route, error) {
+	pattern = strings.TrimSpace(pattern)
+	mailbox = strings.TrimSpace(mailbox)
+	if pattern == "" || mailbox == "" {
+		return nil, fmt.Errorf("pattern and mailbox are required")
+	}
+
+	re, err := compilePattern(pattern, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Route{Pattern: pattern, Mailbox: mailbox, re: re}, nil
+}
+
+// compilePattern compiles either a wildcard (* and ?) or a regular expression within slashes,
+// wildcards match the whole value and may be case-insensitive, regular expressions are used as is
+func compilePattern(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	var expr string
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expr = pattern[1 : len(pattern)-1]
+	} else {
+		expr = "^" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(pattern)) + "$"
+		if caseInsensitive {
+			expr = "(?i)" + expr
+		}
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	return re, nil
+}
+
+// ValidatePattern checks if the wildcard or /regular expression/ pattern is valid
+func ValidatePattern(pattern string) error {
+	_, err := compilePattern(pattern, true)
+	return err
+}
+
+// CompilePatterns compiles comma-separated list of wildcards (case-insensitive) and /regular expressions/,
+// invalid patterns are skipped
+func CompilePatterns(value string) []*regexp.Regexp {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range SplitPatterns(value) {
+		re, err := compilePattern(pattern, true)
+		if err != nil {
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+
+	return patterns
+}
+
+// SplitPatterns splits comma-separated list of wildcards and /regular expressions/,
+// commas within regular expressions (e.g. /x{2,3}/) don't split them
+func SplitPatterns(value string) []string {
+	parts := utils.StringSlice(value)
+	patterns := make([]string, 0, len(parts))
+	var regexpPart string
+	for _, part := range parts {
+		if regexpPart != "" {
+			part = regexpPart + "," + part
+			regexpPart = ""
+		}
+		trimmed := strings.TrimSpace(part)
+		if strings.HasPrefix(trimmed, "/") && (len(trimmed) == 1 || !strings.HasSuffix(trimmed, "/")) {
+			regexpPart = part
+			continue
+		}
+		if trimmed != "" {
+			patterns = append(patterns, trimmed)
+		}
+	}
+	if trimmed := strings.TrimSpace(regexpPart); trimmed != "" {
+		patterns = append(patterns, trimmed)
+	}
+
+	return patterns
+}
+
+// Match checks if mailbox matches the rule's pattern
+func (r *Route) Match(mailbox string) bool {
+	return r.re.MatchString(mailbox)
+}
+
+// Example returns a mailbox that matches wildcard pattern, used for conflict detection.
+// Regular expressions don't have examples
+func (r *Route) Example() string {
+	if strings.HasPrefix(r.Pattern, "/") {
+		return ""
+	}
+
+	return strings.NewReplacer("*", "", "?", "x").Replace(r.Pattern)
+}
+
+// String representation of the rule
+func (r *Route) String() string {
+	return r.Pattern + " " + r.Mailbox
+}
+
+// Slice returns ordered list of valid routing rules
+func (r Routes) Slice() []*Route {
+	values := ordered(r)
+	routes := make([]*Route, 0, len(values))
+	for _, value := range values {
+		pattern, mailbox, _ := strings.Cut(value, " ")
+		route, err := NewRoute(pattern, mailbox)
+		if err != nil {
+			continue
+		}
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// ordered returns values of the position = value map, sorted by position
+func ordered(positions map[string]string) []string {
+	keys := make([]string, 0, len(positions))
+	for key := range positions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i]) //nolint:errcheck // invalid keys go first
+		b, _ := strconv.Atoi(keys[j]) //nolint:errcheck // invalid keys go first
+		return a < b
+	})
+
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, positions[key])
+	}
+
+	return values
+}
+
+// NewRoutes converts ordered list of routing rules into config
+func NewRoutes(routes []*Route) Routes {
+	cfg := make(Routes, len(routes))
+	for i, route := range routes {
+		cfg[strconv.Itoa(i)] = route.String()
+	}
+
+	return cfg
+}
+
+// MoveRoute returns the routes with the rule of the pattern moved to the index (the last position if it's out of range)
+func MoveRoute(routes []*Route, pattern string, index int) ([]*Route, bool) {
+	var moved *Route
+	updated := make([]*Route, 0, len(routes))
+	for _, route := range routes {
+		if route.Pattern == pattern {
+			moved = route
+			continue
+		}
+		updated = append(updated, route)
+	}
+	if moved == nil {
+		return nil, false
+	}
+	if index > len(updated) {
+		index = len(updated)
+	}
+
+	updated = append(updated, nil)
+	copy(updated[index+1:], updated[index:])
+	updated[index] = moved
+	return updated, true
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		mailbox string
+		valid   bool
+	}{
+		{name: "wildcard", pattern: "alerts-*", mailbox: "ops", valid: true},
+		{name: "regexp", pattern: `/^invoice\..+$/`, mailbox: "billing", valid: true},
+		{name: "trimmed", pattern: " alerts-* ", mailbox: " ops ", valid: true},
+		{name: "no pattern", pattern: "", mailbox: "ops", valid: false},
+		{name: "no mailbox", pattern: "alerts-*", mailbox: " ", valid: false},
+		{name: "invalid regexp", pattern: "/^(invoice$/", mailbox: "billing", valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route, err := NewRoute(test.pattern, test.mailbox)
+			if (err == nil) != test.valid {
+				t.Fatalf("NewRoute(%q, %q) error = %v, valid = %t", test.pattern, test.mailbox, err, test.valid)
+			}
+			if err == nil && (route.Pattern != strings.TrimSpace(test.pattern) || route.Mailbox != strings.TrimSpace(test.mailbox)) {
+				t.Errorf("NewRoute(%q, %q) = %+v, want trimmed values", test.pattern, test.mailbox, route)
+			}
+		})
+	}
+}
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		mailbox string
+		match   bool
+	}{
+		{name: "wildcard suffix", pattern: "alerts-*", mailbox: "alerts-prod", match: true},
+		{name: "wildcard empty suffix", pattern: "alerts-*", mailbox: "alerts-", match: true},
+		{name: "wildcard whole value", pattern: "alerts-*", mailbox: "my-alerts-prod", match: false},
+		{name: "wildcard is case-sensitive", pattern: "alerts-*", mailbox: "Alerts-prod", match: false},
+		{name: "single character", pattern: "?-ops", mailbox: "a-ops", match: true},
+		{name: "single character only", pattern: "?-ops", mailbox: "ab-ops", match: false},
+		{name: "meta characters are literal", pattern: "a.b", mailbox: "axb", match: false},
+		{name: "regexp", pattern: `/^invoice\..+$/`, mailbox: "invoice.2026", match: true},
+		{name: "regexp no match", pattern: `/^invoice\..+$/`, mailbox: "invoice", match: false},
+		{name: "regexp partial", pattern: "/bill/", mailbox: "billing", match: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route, err := NewRoute(test.pattern, "target")
+			if err != nil {
+				t.Fatalf("NewRoute(%q) = %v", test.pattern, err)
+			}
+			if match := route.Match(test.mailbox); match != test.match {
+				t.Errorf("Match(%q) = %t, want %t", test.mailbox, match, test.match)
+			}
+		})
+	}
+}
+
+func TestRouteExample(t *testing.T) {
+	tests := []struct {
+		pattern string
+		example string
+	}{
+		{pattern: "alerts-*", example: "alerts-"},
+		{pattern: "?-ops", example: "x-ops"},
+		{pattern: "/^alerts$/", example: ""},
+	}
+	for _, test := range tests {
+		route, err := NewRoute(test.pattern, "target")
+		if err != nil {
+			t.Fatalf("NewRoute(%q) = %v", test.pattern, err)
+		}
+		if example := route.Example(); example != test.example {
+			t.Errorf("Example() of %q = %q, want %q", test.pattern, example, test.example)
+		}
+	}
+}
+
+func TestRoutesSlice(t *testing.T) {
+	cfg := Routes{
+		"10": "c-* third",
+		"2":  "b-* second",
+		"0":  "a-* first",
+		"3":  "invalid",
+		"4":  "/(/ broken",
+	}
+	routes := cfg.Slice()
+	actual := make([]string, 0, len(routes))
+	for _, route := range routes {
+		actual = append(actual, route.String())
+	}
+	expected := []string{"a-* first", "b-* second", "c-* third"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Slice() = %v, want %v", actual, expected)
+	}
+
+	if roundtrip := NewRoutes(routes); !reflect.DeepEqual(roundtrip, Routes{"0": "a-* first", "1": "b-* second", "2": "c-* third"}) {
+		t.Errorf("NewRoutes() = %v", roundtrip)
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, pattern := range []string{"*@github.com", "/\\[URGENT\\]/", "a{2,3}", "/x{2,3}/"} {
+		if err := ValidatePattern(pattern); err != nil {
+			t.Errorf("ValidatePattern(%q) = %v, want nil", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"/[/", "/a{3,2}/"} {
+		if err := ValidatePattern(pattern); err == nil {
+			t.Errorf("ValidatePattern(%q) = nil, want error", pattern)
+		}
+	}
+}
+
+func TestSplitPatterns(t *testing.T) {
+	tests := map[string][]string{
+		"":                                   {},
+		"*@github.com":                       {"*@github.com"},
+		"*@bigcustomer.com, ceo@example.com": {"*@bigcustomer.com", "ceo@example.com"},
+		"/^x{2,3}@example\\.com$/,ceo@*":     {"/^x{2,3}@example\\.com$/", "ceo@*"},
+		"/a{1,2}b{3,4}/":                     {"/a{1,2}b{3,4}/"},
+		"/,/,a":                              {"/,/", "a"},
+		"/unfinished,a":                      {"/unfinished,a"},
+	}
+	for value, expected := range tests {
+		if patterns := SplitPatterns(value); !reflect.DeepEqual(patterns, expected) {
+			t.Errorf("SplitPatterns(%q) = %v, want %v", value, patterns, expected)
+		}
+	}
+}
+
+func TestMoveRoute(t *testing.T) {
+	routes := Routes{"0": "a-* first", "1": "b-* second", "2": "c-* third"}.Slice()
+	patterns := func(routes []*Route) []string {
+		result := make([]string, 0, len(routes))
+		for _, route := range routes {
+			result = append(result, route.Pattern)
+		}
+		return result
+	}
+	tests := []struct {
+		pattern  string
+		index    int
+		expected []string
+	}{
+		{pattern: "c-*", index: 0, expected: []string{"c-*", "a-*", "b-*"}},
+		{pattern: "a-*", index: 1, expected: []string{"b-*", "a-*", "c-*"}},
+		{pattern: "a-*", index: 2, expected: []string{"b-*", "c-*", "a-*"}},
+		{pattern: "b-*", index: 10, expected: []string{"a-*", "c-*", "b-*"}},
+		{pattern: "b-*", index: 1, expected: []string{"a-*", "b-*", "c-*"}},
+	}
+	for _, test := range tests {
+		moved, ok := MoveRoute(routes, test.pattern, test.index)
+		if !ok || !reflect.DeepEqual(patterns(moved), test.expected) {
+			t.Errorf("MoveRoute(%q, %d) = %v, %t, want %v", test.pattern, test.index, patterns(moved), ok, test.expected)
+		}
+	}
+	if !reflect.DeepEqual(patterns(routes), []string{"a-*", "b-*", "c-*"}) {
+		t.Errorf("MoveRoute() modified the routes: %v", patterns(routes))
+	}
+	if _, ok := MoveRoute(routes, "d-*", 0); ok {
+		t.Error("MoveRoute() of missing pattern = true, want false")
+	}
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// account data key
+const acRulesKey = "cc.etke.postmoogle.rules"
+
+// rule fields
+const (
+	RuleFieldFrom    = "from"
+	RuleFieldDomain  = "domain"
+	RuleFieldSubject = "subject"
+	RuleFieldHeader  = "header:"
+)
+
+// rule actions
+const (
+	RuleActionThread  = "thread"
+	RuleActionRoom    = "room"
+	RuleActionMention = "mention"
+)
+
+// Rules config of the room, map of position = "field pattern action target"
+type Rules map[string]string
+
+// Rule is a routing rule of incoming emails within the room
+type Rule struct {
+	// Field of the email: from, domain, subject or header:NAME
+	Field string
+	// Pattern is either a wildcard (*@github.com) or a regular expression within slashes (/\[URGENT\]/)
+	Pattern string
+	// Action is either thread, room or mention
+	Action string
+	// Target of the action: thread name, mailbox or user ID
+	Target string
+
+	re *regexp.Regexp
+}
+
+// NewRule parses and validates routing rule
+func NewRule(field, pattern, action, target string) (*Rule, error) {
+	field = strings.ToLower(strings.TrimSpace(field))
+	action = strings.ToLower(strings.TrimSpace(action))
+	pattern = strings.TrimSpace(pattern)
+	target = strings.TrimSpace(target)
+	if field == "" || pattern == "" || action == "" || target == "" {
+		return nil, fmt.Errorf("field, pattern, action and target are required")
+	}
+
+	switch field {
+	case RuleFieldFrom, RuleFieldDomain, RuleFieldSubject:
+	default:
+		if !strings.HasPrefix(field, RuleFieldHeader) || field == RuleFieldHeader {
+			return nil, fmt.Errorf("invalid field %q, should be one of: from, domain, subject, header:NAME", field)
+		}
+	}
+
+	switch action {
+	case RuleActionThread:
+	case RuleActionRoom:
+		target = utils.Mailbox(strings.ToLower(target))
+	case RuleActionMention:
+		if _, _, err := id.UserID(target).Parse(); err != nil {
+			return nil, fmt.Errorf("invalid user ID %q: %w", target, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid action %q, should be one of: thread, room, mention", action)
+	}
+
+	re, err := compilePattern(pattern, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rule{Field: field, Pattern: pattern, Action: action, Target: target, re: re}, nil
+}
+
+// Value returns value of the rule's field from the email
+func (r *Rule) Value(eml *email.Email) string {
+	switch r.Field {
+	case RuleFieldFrom:
+		return eml.From
+	case RuleFieldDomain:
+		return utils.Hostname(eml.From)
+	case RuleFieldSubject:
+		return eml.Subject
+	default:
+		if eml.Headers == nil {
+			return ""
+		}
+		return eml.Headers.Get(strings.TrimPrefix(r.Field, RuleFieldHeader))
+	}
+}
+
+// Match checks if the email matches the rule
+func (r *Rule) Match(eml *email.Email) bool {
+	return r.re.MatchString(r.Value(eml))
+}
+
+// String representation of the rule
+func (r *Rule) String() string {
+	return r.Field + " " + r.Pattern + " " + r.Action + " " + r.Target
+}
+
+// Slice returns ordered list of valid rules
+func (r Rules) Slice() []*Rule {
+	values := ordered(r)
+	rules := make([]*Rule, 0, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, " ", 4)
+		if len(parts) < 4 {
+			continue
+		}
+		rule, err := NewRule(parts[0], parts[1], parts[2], parts[3])
+		if err != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// NewRules converts ordered list of rules into config
+func NewRules(rules []*Rule) Rules {
+	cfg := make(Rules, len(rules))
+	for i, rule := range rules {
+		cfg[strconv.Itoa(i)] = rule.String()
+	}
+
+	return cfg
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"net/textproto"
+	"reflect"
+	"testing"
+
+	"gitlab.com/etke.cc/postmoogle/email"
+)
+
+func TestNewRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		pattern  string
+		action   string
+		target   string
+		expected string
+		valid    bool
+	}{
+		{name: "from thread", field: "from", pattern: "*@github.com", action: "thread", target: "github", expected: "from *@github.com thread github", valid: true},
+		{name: "case-insensitive field and action", field: "FROM", pattern: "*@github.com", action: "Thread", target: "github", expected: "from *@github.com thread github", valid: true},
+		{name: "room target is a mailbox", field: "domain", pattern: "example.com", action: "room", target: "Sales@example.org", expected: "domain example.com room sales", valid: true},
+		{name: "mention", field: "subject", pattern: `/\[URGENT\]/`, action: "mention", target: "@user:example.com", expected: `subject /\[URGENT\]/ mention @user:example.com`, valid: true},
+		{name: "header", field: "header:X-Priority", pattern: "1*", action: "thread", target: "urgent", expected: "header:x-priority 1* thread urgent", valid: true},
+		{name: "header without name", field: "header:", pattern: "1*", action: "thread", target: "urgent", valid: false},
+		{name: "invalid field", field: "to", pattern: "*", action: "thread", target: "all", valid: false},
+		{name: "invalid action", field: "from", pattern: "*", action: "drop", target: "all", valid: false},
+		{name: "invalid user ID", field: "from", pattern: "*", action: "mention", target: "user", valid: false},
+		{name: "invalid pattern", field: "subject", pattern: "/(/", action: "thread", target: "broken", valid: false},
+		{name: "no target", field: "from", pattern: "*", action: "thread", target: " ", valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := NewRule(test.field, test.pattern, test.action, test.target)
+			if (err == nil) != test.valid {
+				t.Fatalf("NewRule() error = %v, valid = %t", err, test.valid)
+			}
+			if err == nil && rule.String() != test.expected {
+				t.Errorf("NewRule() = %q, want %q", rule.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	eml := &email.Email{
+		From:    "Notifications@GitHub.com",
+		Subject: "[URGENT] server is down",
+		Headers: textproto.MIMEHeader{"X-Priority": {"1 (Highest)"}},
+	}
+	tests := []struct {
+		name    string
+		field   string
+		pattern string
+		match   bool
+	}{
+		{name: "from wildcard", field: "from", pattern: "*@github.com", match: true},
+		{name: "from wildcard mismatch", field: "from", pattern: "*@gitlab.com", match: false},
+		{name: "domain", field: "domain", pattern: "github.com", match: true},
+		{name: "domain whole value", field: "domain", pattern: "hub.com", match: false},
+		{name: "subject regexp", field: "subject", pattern: `/\[URGENT\]/`, match: true},
+		{name: "subject regexp is case-sensitive", field: "subject", pattern: `/\[urgent\]/`, match: false},
+		{name: "subject wildcard", field: "subject", pattern: "*down", match: true},
+		{name: "header", field: "header:x-priority", pattern: "1*", match: true},
+		{name: "missing header", field: "header:x-spam", pattern: "*", match: true},
+		{name: "missing header value", field: "header:x-spam", pattern: "?*", match: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := NewRule(test.field, test.pattern, RuleActionThread, "target")
+			if err != nil {
+				t.Fatalf("NewRule() = %v", err)
+			}
+			if match := rule.Match(eml); match != test.match {
+				t.Errorf("Match() of %q = %t, want %t", rule.String(), match, test.match)
+			}
+		})
+	}
+}
+
+func TestRuleMatchWithoutHeaders(t *testing.T) {
+	rule, err := NewRule("header:x-priority", "?*", RuleActionThread, "target")
+	if err != nil {
+		t.Fatalf("NewRule() = %v", err)
+	}
+	if rule.Match(&email.Email{From: "someone@example.com"}) {
+		t.Error("Match() = true, want false for email without headers")
+	}
+}
+
+func TestRulesSlice(t *testing.T) {
+	cfg := Rules{
+		"1":  "subject *down* mention @user:example.com",
+		"0":  "from *@github.com thread github",
+		"2":  "from *",
+		"3":  "to * thread all",
+		"11": "domain example.com room sales",
+	}
+	rules := cfg.Slice()
+	actual := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		actual = append(actual, rule.String())
+	}
+	expected := []string{
+		"from *@github.com thread github",
+		"subject *down* mention @user:example.com",
+		"domain example.com room sales",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Slice() = %v, want %v", actual, expected)
+	}
+
+	roundtrip := NewRules(rules)
+	if !reflect.DeepEqual(roundtrip.Slice(), rules) {
+		t.Errorf("NewRules().Slice() = %v, want %v", roundtrip.Slice(), rules)
+	}
+}
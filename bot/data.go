@@ -27,10 +27,10 @@ func (b *Bot) syncRooms() error {
 		if serr != nil {
 			continue
 		}
-		mailbox := cfg.Mailbox()
-		active := cfg.Active()
-		if mailbox != "" && active {
-			b.rooms.Store(mailbox, roomID)
+		if cfg.Active() {
+			for _, mailbox := range cfg.Mailboxes() {
+				b.rooms.Store(mailbox, roomID)
+			}
 		}
 
 		if cfg.Owner() != "" && b.allowAdmin(id.UserID(cfg.Owner()), "") {
@@ -0,0 +1,320 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+const (
+	// digestSnippetLength is max length of the email text in the digest
+	digestSnippetLength = 100
+	// digestMessageLength is max length of the digest message (markdown), longer digests are split into several messages
+	digestMessageLength = 16000
+)
+
+// digestEntry is an email waiting for the digest, stored under its own room account data key
+type digestEntry struct {
+	key       string
+	MessageID string
+	From      string
+	Subject   string
+	Snippet   string
+	// URL of the uploaded .eml file
+	URL string
+	// File is JSON of the encrypted file info of the .eml file uploaded in encrypted room, including its decryption keys
+	File string
+}
+
+func newDigestEntry(key string, data map[string]string) digestEntry {
+	return digestEntry{
+		key:       key,
+		MessageID: data["message_id"],
+		From:      data["from"],
+		Subject:   data["subject"],
+		Snippet:   data["snippet"],
+		URL:       data["url"],
+		File:      data["file"],
+	}
+}
+
+func (e digestEntry) data() map[string]string {
+	return map[string]string{
+		"message_id": e.MessageID,
+		"from":       e.From,
+		"subject":    e.Subject,
+		"snippet":    e.Snippet,
+		"url":        e.URL,
+		"file":       e.File,
+	}
+}
+
+// markdownEscaper escapes the markdown syntax in email fields
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+	"#", "\\#", "+", "\\+", "-", "\\-", "!", "\\!", "|", "\\|", "<", "\\<", ">", "\\>", "~", "\\~",
+)
+
+// sanitizeDigest converts digest option value into hourly, daily, count of emails or empty string (disabled)
+func sanitizeDigest(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case config.DigestHourly, config.DigestDaily:
+		return value
+	}
+	if count := utils.Int(value); count > 0 {
+		return strconv.Itoa(count)
+	}
+
+	return ""
+}
+
+// addToDigest stores summary of the email and uploads the email itself, to post them later within the digest.
+// In encrypted rooms the email is uploaded encrypted, and the decryption keys are kept in the room account data
+// (encrypted with the data secret, if set) until the digest is posted
+func (b *Bot) addToDigest(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email) error {
+	entry := digestEntry{
+		MessageID: eml.MessageID,
+		From:      eml.From,
+		Subject:   eml.Subject,
+		Snippet:   digestSnippet(eml),
+	}
+	if len(eml.Raw) > 0 {
+		if err := b.uploadDigestEmail(roomID, eml, &entry); err != nil {
+			b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot upload email for the digest")
+		}
+	}
+
+	b.mu.Lock(roomID.String())
+	defer b.mu.Unlock(roomID.String())
+	index, err := b.lp.GetRoomAccountData(roomID, acDigestIndexKey)
+	if err != nil {
+		return utils.UnwrapError(err)
+	}
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err = b.lp.SetRoomAccountData(roomID, acDigestPrefix+"."+suffix, entry.data()); err != nil {
+		return utils.UnwrapError(err)
+	}
+	updated := make(map[string]string, len(index)+1)
+	for k, v := range index {
+		updated[k] = v
+	}
+	updated[suffix] = ""
+	if err := b.lp.SetRoomAccountData(roomID, acDigestIndexKey, updated); err != nil {
+		return utils.UnwrapError(err)
+	}
+
+	if count := cfg.DigestCount(); count > 0 && len(updated) >= count {
+		b.sendDigest(ctx, roomID, cfg)
+	}
+	return nil
+}
+
+// uploadDigestEmail uploads the email of the digest entry, encrypted in encrypted rooms
+func (b *Bot) uploadDigestEmail(roomID id.RoomID, eml *email.Email, entry *digestEntry) error {
+	if !b.lp.GetMachine().StateStore.IsEncrypted(roomID) {
+		resp, err := b.lp.GetClient().UploadBytesWithName(eml.Raw, "message/rfc822", "email.eml")
+		if err != nil {
+			return err
+		}
+		entry.URL = string(resp.ContentURI.CUString())
+		return nil
+	}
+
+	file, err := b.uploadEncrypted(eml.Raw, "email.eml")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	entry.File = string(data)
+	return nil
+}
+
+// SendDigests sends scheduled digests of all rooms: hourly digests on every run, daily ones at midnight (UTC).
+// Leftovers of count-based digests are sent at midnight as well, and leftovers of disabled digests - on every run
+func (b *Bot) SendDigests() {
+	ctx := context.Background()
+	daily := time.Now().UTC().Hour() == 0
+	seen := map[id.RoomID]bool{}
+	b.rooms.Range(func(_, v any) bool {
+		roomID, ok := v.(id.RoomID)
+		if !ok || seen[roomID] {
+			return true
+		}
+		seen[roomID] = true
+
+		cfg, err := b.cfg.GetRoom(roomID)
+		if err != nil {
+			b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot retrieve room settings")
+			return true
+		}
+		if cfg.Digest() == "" || cfg.Digest() == config.DigestHourly || daily {
+			b.mu.Lock(roomID.String())
+			b.sendDigest(ctx, roomID, cfg)
+			b.mu.Unlock(roomID.String())
+		}
+		return true
+	})
+}
+
+// sendDigest posts summary of the pending emails and the emails themselves as .eml files in its thread.
+// Long digests are split into several messages, each with its own thread.
+// The room should be locked by the caller
+func (b *Bot) sendDigest(ctx context.Context, roomID id.RoomID, cfg config.Room) {
+	index, err := b.lp.GetRoomAccountData(roomID, acDigestIndexKey)
+	if err != nil {
+		b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot retrieve digest")
+		return
+	}
+	if len(index) == 0 {
+		return
+	}
+	suffixes := make([]string, 0, len(index))
+	for suffix := range index {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	entries := make([]digestEntry, 0, len(suffixes))
+	for _, suffix := range suffixes {
+		data, err := b.lp.GetRoomAccountData(roomID, acDigestPrefix+"."+suffix)
+		if err != nil {
+			b.log.Warn().Err(err).Str("roomID", roomID.String()).Msg("cannot retrieve digest entry")
+			continue
+		}
+		// already posted, but the index has not been updated
+		if len(data) == 0 {
+			delete(index, suffix)
+			continue
+		}
+		entries = append(entries, newDigestEntry(suffix, data))
+	}
+	if len(entries) == 0 {
+		if err := b.lp.SetRoomAccountData(roomID, acDigestIndexKey, index); err != nil {
+			b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot reset digest")
+		}
+		return
+	}
+
+	var offset int
+	for _, part := range splitDigest(entries) {
+		if !b.sendDigestPart(ctx, roomID, cfg, part, offset, len(entries)) {
+			return
+		}
+		offset += len(part)
+		for _, entry := range part {
+			delete(index, entry.key)
+			if err := b.lp.SetRoomAccountData(roomID, acDigestPrefix+"."+entry.key, map[string]string{}); err != nil {
+				b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot reset digest entry")
+			}
+		}
+		if err := b.lp.SetRoomAccountData(roomID, acDigestIndexKey, index); err != nil {
+			b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot reset digest")
+		}
+	}
+}
+
+// sendDigestPart posts the part of the digest, offset is count of the entries posted in the previous parts
+func (b *Bot) sendDigestPart(ctx context.Context, roomID id.RoomID, cfg config.Room, entries []digestEntry, offset, total int) bool {
+	var text strings.Builder
+	if len(entries) == total {
+		text.WriteString(fmt.Sprintf("**Digest: %d email(s)**\n\n", total))
+	} else {
+		text.WriteString(fmt.Sprintf("**Digest: emails %d-%d of %d**\n\n", offset+1, offset+len(entries), total))
+	}
+	for i, entry := range entries {
+		text.WriteString(digestLine(offset+i+1, entry))
+	}
+	content := format.RenderMarkdown(text.String(), true, false)
+	threadID, err := b.lp.Send(roomID, &event.Content{Parsed: &content})
+	if err != nil {
+		b.Error(ctx, roomID, "cannot send digest: %v", utils.UnwrapError(err))
+		return false
+	}
+
+	for i, entry := range entries {
+		if entry.MessageID != "" {
+			b.setThreadID(roomID, entry.MessageID, threadID)
+		}
+		content := &event.MessageEventContent{
+			MsgType:   event.MsgFile,
+			Body:      fmt.Sprintf("email-%d.eml", offset+i+1),
+			URL:       id.ContentURIString(entry.URL),
+			Info:      &event.FileInfo{MimeType: "message/rfc822"},
+			RelatesTo: utils.RelatesTo(!cfg.NoThreads(), threadID),
+		}
+		if entry.File != "" {
+			content.URL = ""
+			if err := json.Unmarshal([]byte(entry.File), &content.File); err != nil {
+				b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot parse encrypted email of the digest")
+				continue
+			}
+		}
+		if content.URL == "" && content.File == nil {
+			continue
+		}
+		if _, err := b.lp.Send(roomID, &event.Content{Parsed: content}); err != nil {
+			b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot send email of the digest")
+		}
+	}
+	return true
+}
+
+// splitDigest splits the digest entries into parts, markdown of each part fits into digestMessageLength
+func splitDigest(entries []digestEntry) [][]digestEntry {
+	var parts [][]digestEntry
+	var part []digestEntry
+	var length int
+	for i, entry := range entries {
+		lineLength := len(digestLine(i+1, entry))
+		if len(part) > 0 && length+lineLength > digestMessageLength {
+			parts = append(parts, part)
+			part, length = nil, 0
+		}
+		part = append(part, entry)
+		length += lineLength
+	}
+	if len(part) > 0 {
+		parts = append(parts, part)
+	}
+
+	return parts
+}
+
+// digestLine returns markdown list item of the digest entry, email fields are escaped
+func digestLine(number int, entry digestEntry) string {
+	line := fmt.Sprintf("%d. **%s** - %s", number, markdownEscaper.Replace(entry.From), markdownEscaper.Replace(entry.Subject))
+	if entry.Snippet != "" {
+		line += "\n   > " + markdownEscaper.Replace(entry.Snippet)
+	}
+
+	return line + "\n"
+}
+
+// digestSnippet returns beginning of the email text as a single line
+func digestSnippet(eml *email.Email) string {
+	text := eml.Text
+	if text == "" && eml.HTML != "" {
+		text = format.HTMLToMarkdown(eml.HTML)
+	}
+	snippet := []rune(strings.Join(strings.Fields(text), " "))
+	if len(snippet) > digestSnippetLength {
+		return string(snippet[:digestSnippetLength]) + "…"
+	}
+
+	return string(snippet)
+}
@@ -2,9 +2,15 @@ package bot
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
+	"maunium.net/go/mautrix/crypto/attachment"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
@@ -16,10 +22,21 @@ import (
 
 // account data keys
 const (
-	acMessagePrefix   = "cc.etke.postmoogle.message"
-	acLastEventPrefix = "cc.etke.postmoogle.last"
+	acMessagePrefix     = "cc.etke.postmoogle.message"
+	acLastEventPrefix   = "cc.etke.postmoogle.last"
+	acVERPPrefix        = "cc.etke.postmoogle.verp"
+	acVERPIndexKey      = "cc.etke.postmoogle.verp.index"
+	acAutoreplyPrefix   = "cc.etke.postmoogle.autoreply"
+	acAutoreplyIndexKey = "cc.etke.postmoogle.autoreply.index"
+	acDigestPrefix      = "cc.etke.postmoogle.digest"
+	acDigestIndexKey    = "cc.etke.postmoogle.digest.index"
+	acSourcePrefix      = "cc.etke.postmoogle.source"
+	acSourceIndexKey    = "cc.etke.postmoogle.source.index"
 )
 
+// verpMaxAge of VERP token in days, bounces to older tokens are dropped and the tokens are pruned
+const verpMaxAge = 14
+
 // event keys
 const (
 	eventMessageIDkey  = "cc.etke.postmoogle.messageID"
@@ -30,6 +47,8 @@ const (
 	eventFromKey       = "cc.etke.postmoogle.from"
 	eventToKey         = "cc.etke.postmoogle.to"
 	eventCcKey         = "cc.etke.postmoogle.cc"
+	// eventSubscriptionKey marks read-only copies of emails delivered to subscribers of the mailbox
+	eventSubscriptionKey = "cc.etke.postmoogle.subscription"
 )
 
 // SetSendmail sets mail sending func to the bot
@@ -40,12 +59,21 @@ func (b *Bot) SetSendmail(sendmail func(string, string, string) error) {
 
 // Sendmail tries to send email immediately, but if it gets 4xx error (greylisting),
 // the email will be added to the queue and retried several times after that
-func (b *Bot) Sendmail(eventID id.EventID, from, to, data string) (bool, error) {
+func (b *Bot) Sendmail(roomID id.RoomID, eventID id.EventID, from, to, data string) (bool, error) {
+	if b.cfg.GetBot().VERP() {
+		from = b.newVERP(roomID, eventID, from, to)
+	}
+
+	return b.trySendmail(eventID.String(), from, to, data)
+}
+
+// trySendmail sends email with the envelope sender as is, adding it to the queue (under the queueID) on 4xx error
+func (b *Bot) trySendmail(queueID, from, to, data string) (bool, error) {
 	err := b.sendmail(from, to, data)
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "4") {
-			b.log.Info().Err(err).Str("id", eventID.String()).Str("from", from).Str("to", to).Msg("email has been added to the queue")
-			return true, b.q.Add(eventID.String(), from, to, data)
+			b.log.Info().Err(err).Str("id", queueID).Str("from", from).Str("to", to).Msg("email has been added to the queue")
+			return true, b.q.Add(queueID, from, to, data)
 		}
 		return false, err
 	}
@@ -53,6 +81,13 @@ func (b *Bot) Sendmail(eventID id.EventID, from, to, data string) (bool, error)
 	return false, nil
 }
 
+// newQueueID returns unique queue ID of the email not related to any event (e.g. automatic replies),
+// parts should identify the email and its recipient
+func newQueueID(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(hash[:16])
+}
+
 // GetDKIMprivkey returns DKIM private key
 func (b *Bot) GetDKIMprivkey() string {
 	return b.cfg.GetBot().DKIMPrivateKey()
@@ -72,11 +107,19 @@ func (b *Bot) getMapping(mailbox string) (id.RoomID, bool) {
 	return roomID, ok
 }
 
-// GetMapping returns mapping of mailbox = room
-func (b *Bot) GetMapping(mailbox string) (id.RoomID, bool) {
+// GetMapping returns mapping of mailbox = room, including subaddressed mailboxes (mailbox+tag)
+// and catch-all mailbox of the domain
+func (b *Bot) GetMapping(mailbox, domain string) (id.RoomID, bool) {
 	roomID, ok := b.getMapping(mailbox)
 	if !ok {
-		catchAll := b.cfg.GetBot().CatchAll()
+		if base, tag := utils.Subaddress(mailbox, b.isMapped); tag != "" {
+			return b.getMapping(base)
+		}
+		if _, routeRoomID, routed := b.getRoute(mailbox); routed {
+			return routeRoomID, routed
+		}
+
+		catchAll := b.cfg.GetBot().CatchAllOf(domain)
 		if catchAll == "" {
 			return roomID, ok
 		}
@@ -88,6 +131,10 @@ func (b *Bot) GetMapping(mailbox string) (id.RoomID, bool) {
 
 // GetIFOptions returns incoming email filtering options (room settings)
 func (b *Bot) GetIFOptions(roomID id.RoomID) email.IncomingFilteringOptions {
+	// bounces to SRS addresses don't belong to any room
+	if roomID == "" {
+		return config.Room{}
+	}
 	cfg, err := b.cfg.GetRoom(roomID)
 	if err != nil {
 		b.log.Error().Err(err).Msg("cannot retrieve room settings")
@@ -96,9 +143,15 @@ func (b *Bot) GetIFOptions(roomID id.RoomID) email.IncomingFilteringOptions {
 	return cfg
 }
 
-// IncomingEmail sends incoming email to matrix room
+// IncomingEmail sends incoming email to matrix room.
+// The same email is passed for each recipient, so the recipient-specific fields are reset here
 func (b *Bot) IncomingEmail(ctx context.Context, email *email.Email) error {
-	roomID, ok := b.GetMapping(email.Mailbox(true))
+	email.Tag = ""
+	if utils.IsSRS(email.RcptTo) {
+		return b.incomingSRS(email)
+	}
+	mailbox := email.Mailbox(true)
+	roomID, ok := b.GetMapping(mailbox, email.Domain(true))
 	if !ok {
 		return errors.New("room not found")
 	}
@@ -106,7 +159,66 @@ func (b *Bot) IncomingEmail(ctx context.Context, email *email.Email) error {
 	if err != nil {
 		b.Error(ctx, roomID, "cannot get settings: %v", err)
 	}
+	opts := &deliveryOptions{}
+	if _, exact := b.getMapping(mailbox); !exact {
+		_, email.Tag = utils.Subaddress(mailbox, b.isMapped)
+	}
+	if cfg.List() && isListCommand(email.Tag) {
+		return b.incomingListCommand(ctx, roomID, email)
+	}
+	if email.Tag != "" {
+		var tagThread bool
+		roomID, cfg, tagThread = b.routeTag(ctx, roomID, cfg, email.Tag)
+		if tagThread {
+			opts.threadKey = tagThreadKey(email.Tag)
+		}
+	}
 
+	if _, token := utils.ParseVERP(email.RcptTo); token != "" {
+		return b.incomingVERP(ctx, roomID, email, cfg, token)
+	}
+	if email.DSN != nil {
+		return b.incomingDSN(ctx, roomID, email, cfg)
+	}
+
+	keep, err := b.applySieve(ctx, roomID, cfg, email)
+	if err != nil || !keep {
+		return err
+	}
+	roomID, cfg = b.applyRules(ctx, roomID, cfg, email, opts)
+	b.applyVIP(roomID, cfg, email, opts)
+	// emails routed by rules into dedicated threads or with mentions are posted immediately
+	if cfg.Digest() != "" && opts.threadKey == "" && len(opts.mentions) == 0 && !opts.mentionRoom {
+		err = b.addToDigest(ctx, roomID, cfg, email)
+	} else {
+		err = b.deliver(ctx, roomID, cfg, email, opts)
+	}
+	if err != nil {
+		return err
+	}
+	b.roomAutoreply(roomID, cfg, email)
+	b.forward(ctx, roomID, cfg, email)
+	b.redistribute(ctx, roomID, cfg, email)
+	// the room is unlocked at this point, so mutual subscriptions can't deadlock
+	b.deliverSubscribers(ctx, cfg, email)
+
+	return nil
+}
+
+// deliveryOptions of the email within the room
+type deliveryOptions struct {
+	// threadKey of the dedicated thread (tag or rule), if any
+	threadKey string
+	// subscription is the source mailbox if the room is a read-only subscriber
+	subscription string
+	// mentions of users added by rules and VIP settings
+	mentions []id.UserID
+	// mentionRoom mentions the whole room (@room)
+	mentionRoom bool
+}
+
+// deliver posts the email to the room
+func (b *Bot) deliver(ctx context.Context, roomID id.RoomID, cfg config.Room, email *email.Email, opts *deliveryOptions) error {
 	b.mu.Lock(roomID.String())
 	defer b.mu.Unlock(roomID.String())
 
@@ -117,29 +229,214 @@ func (b *Bot) IncomingEmail(ctx context.Context, email *email.Email) error {
 			b.setThreadID(roomID, email.MessageID, threadID)
 		}
 	}
-	content := email.Content(threadID, cfg.ContentOptions())
+	if threadID == "" && opts.threadKey != "" {
+		threadID = b.getThreadID(roomID, opts.threadKey, "")
+	}
+	inlines := email.InlineFiles
+	contentOptions := cfg.ContentOptions()
+	if !cfg.NoInlines() && !cfg.NoHTML() {
+		contentOptions.Images, inlines = b.uploadImages(roomID, email)
+	}
+	contentOptions.TemplateError = func(err error) {
+		b.Error(ctx, roomID, "cannot render message template, the default layout is used: %v", err)
+	}
+	content := email.Content(threadID, contentOptions)
+	if opts.subscription != "" {
+		content.Raw[eventSubscriptionKey] = opts.subscription
+	}
+	if len(opts.mentions) > 0 || opts.mentionRoom {
+		addMentions(content, opts.mentions, opts.mentionRoom)
+	}
+	// truncated after all changes of the content, so the final event fits the max size
+	truncated := truncateContent(content, cfg.MaxSize(), b.lp.GetMachine().StateStore.IsEncrypted(roomID))
 	eventID, serr := b.lp.Send(roomID, content)
 	if serr != nil {
 		return utils.UnwrapError(serr)
 	}
 	if threadID == "" {
 		threadID = eventID
+		if opts.threadKey != "" {
+			b.setThreadID(roomID, opts.threadKey, threadID)
+		}
 	}
 
 	b.setThreadID(roomID, email.MessageID, threadID)
 	b.setLastEventID(roomID, threadID, eventID)
+	if cfg.Retention() > 0 {
+		b.retainSource(roomID, email, eventID, cfg.Retention())
+	}
 
 	if !cfg.NoInlines() {
-		b.sendFiles(ctx, roomID, email.InlineFiles, cfg.NoThreads(), threadID)
+		b.sendFiles(ctx, roomID, inlines, cfg.NoThreads(), threadID)
 	}
 
 	if !cfg.NoFiles() {
 		b.sendFiles(ctx, roomID, email.Files, cfg.NoThreads(), threadID)
 	}
 
+	if cfg.EML() || truncated {
+		b.sendEML(ctx, roomID, cfg, email, threadID)
+	}
+
 	return nil
 }
 
+// routeTag applies room's tag rule to the subaddressed email, returns target room, its settings
+// and whether emails with that tag should be posted into a dedicated thread
+func (b *Bot) routeTag(ctx context.Context, roomID id.RoomID, cfg config.Room, tag string) (id.RoomID, config.Room, bool) {
+	target := cfg.Tags()[tag]
+	switch target {
+	case "":
+		return roomID, cfg, false
+	case config.RoomTagThread:
+		return roomID, cfg, true
+	}
+
+	targetID, ok := b.getMapping(target)
+	if !ok {
+		b.log.Warn().Str("tag", tag).Str("target", target).Msg("target mailbox of the tag rule not found")
+		return roomID, cfg, false
+	}
+	targetCfg, err := b.cfg.GetRoom(targetID)
+	if err != nil {
+		b.Error(ctx, targetID, "cannot get settings: %v", err)
+	}
+
+	return targetID, targetCfg, false
+}
+
+// tagThreadKey returns thread mapping key of the tag's dedicated thread
+func tagThreadKey(tag string) string {
+	return "tag:" + tag
+}
+
+// incomingDSN sends delivery status notification as a short notice to the thread of the original email
+func (b *Bot) incomingDSN(ctx context.Context, roomID id.RoomID, eml *email.Email, cfg config.Room) error {
+	b.mu.Lock(roomID.String())
+	defer b.mu.Unlock(roomID.String())
+
+	messageID := eml.DSN.MessageID
+	if messageID == "" {
+		messageID = eml.InReplyTo
+	}
+	threadID := b.getThreadID(roomID, messageID, eml.References)
+	b.log.Info().Str("messageID", messageID).Str("threadID", threadID.String()).Msg("delivery status notification received")
+
+	return b.sendBounce(ctx, roomID, threadID, eml, cfg, "")
+}
+
+// incomingVERP sends bounce to VERP address as a delivery failure to the thread of the original email.
+// Emails with unknown VERP tokens are dropped
+func (b *Bot) incomingVERP(ctx context.Context, roomID id.RoomID, eml *email.Email, cfg config.Room, token string) error {
+	b.mu.Lock(roomID.String())
+	defer b.mu.Unlock(roomID.String())
+
+	eventID, recipient, ok := b.getVERP(roomID, token)
+	if !ok {
+		b.log.Warn().Str("rcptTo", eml.RcptTo).Str("from", eml.From).Msg("unknown VERP token, email has been dropped")
+		return nil
+	}
+	domain := utils.SanitizeDomain(utils.Hostname(eml.RcptTo))
+	threadID := b.getThreadID(roomID, email.MessageID(eventID, domain), "")
+	if threadID == "" {
+		threadID = eventID
+	}
+	b.log.Info().Str("eventID", eventID.String()).Str("recipient", recipient).Str("threadID", threadID.String()).Msg("bounce received")
+
+	return b.sendBounce(ctx, roomID, threadID, eml, cfg, recipient)
+}
+
+// sendBounce sends short "delivery to X failed" notice with the report (or bounce text) as an attachment
+func (b *Bot) sendBounce(ctx context.Context, roomID id.RoomID, threadID id.EventID, eml *email.Email, cfg config.Room, recipient string) error {
+	var lines []string
+	var report *utils.File
+	if eml.DSN != nil {
+		report = utils.NewFile("delivery-status.txt", eml.DSN.Report)
+		for _, rcpt := range eml.DSN.Recipients {
+			switch rcpt.Action {
+			case "failed", "delayed":
+				lines = append(lines, "delivery to "+markdownEscaper.Replace(rcpt.Recipient)+" "+rcpt.Action+": "+markdownEscaper.Replace(rcpt.Reason()))
+			default:
+				lines = append(lines, "delivery to "+markdownEscaper.Replace(rcpt.Recipient)+": "+markdownEscaper.Replace(rcpt.Action))
+			}
+		}
+	} else if eml.Text != "" {
+		report = utils.NewFile("bounce.txt", []byte(eml.Text))
+	}
+	if len(lines) == 0 {
+		if recipient != "" {
+			lines = append(lines, "delivery to "+markdownEscaper.Replace(recipient)+" failed: "+markdownEscaper.Replace(eml.Subject))
+		} else {
+			lines = append(lines, "delivery status notification: "+markdownEscaper.Replace(eml.Subject))
+		}
+	}
+
+	// the report fields are controlled by the sender, so they are escaped and HTML is not allowed
+	parsed := format.RenderMarkdown(strings.Join(lines, "\n"), true, false)
+	parsed.MsgType = event.MsgNotice
+	parsed.RelatesTo = utils.RelatesTo(!cfg.NoThreads(), threadID)
+	eventID, err := b.lp.Send(roomID, &event.Content{Parsed: &parsed})
+	if err != nil {
+		return utils.UnwrapError(err)
+	}
+	if threadID == "" {
+		threadID = eventID
+	}
+
+	if report != nil {
+		b.sendFiles(ctx, roomID, []*utils.File{report}, cfg.NoThreads(), threadID)
+	}
+	return nil
+}
+
+// newVERP returns VERP envelope sender, token of which refers to the event and recipient
+func (b *Bot) newVERP(roomID id.RoomID, eventID id.EventID, from, to string) string {
+	hash := sha256.Sum256([]byte(eventID.String() + "|" + to))
+	token := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(hash[:10]))
+	now := time.Now().UTC()
+
+	data := map[string]string{"eventID": eventID.String(), "to": to, "time": strconv.FormatInt(now.Unix(), 10)}
+	if err := b.setExpiring(roomID, acVERPPrefix, token, data, now.AddDate(0, 0, verpMaxAge)); err != nil {
+		b.log.Error().Err(err).Str("token", token).Msg("cannot save VERP token, falling back to the plain envelope sender")
+		return from
+	}
+
+	return utils.VERP(from, token)
+}
+
+// getVERP returns event ID and recipient of the VERP token
+func (b *Bot) getVERP(roomID id.RoomID, token string) (id.EventID, string, bool) {
+	key := acVERPPrefix + "." + strings.ToLower(token)
+	data, err := b.lp.GetRoomAccountData(roomID, key)
+	if err != nil {
+		b.log.Error().Err(err).Str("key", key).Msg("cannot retrieve VERP token")
+		return "", "", false
+	}
+	if data["eventID"] == "" {
+		return "", "", false
+	}
+	if verpExpired(data["time"]) {
+		b.log.Debug().Str("key", key).Msg("VERP token has expired")
+		return "", "", false
+	}
+
+	return id.EventID(data["eventID"]), data["to"], true
+}
+
+// verpExpired checks if VERP token created at the unix timestamp is older than verpMaxAge.
+// Tokens without timestamp (created before expiration was introduced) are not expired
+func verpExpired(created string) bool {
+	if created == "" {
+		return false
+	}
+	timestamp, err := strconv.ParseInt(created, 10, 64)
+	if err != nil {
+		return true
+	}
+
+	return time.Unix(timestamp, 0).AddDate(0, 0, verpMaxAge).Before(time.Now())
+}
+
 // SendEmailReply sends replies from matrix thread to email thread
 func (b *Bot) SendEmailReply(ctx context.Context) {
 	evt := eventFromContext(ctx)
@@ -163,7 +460,12 @@ func (b *Bot) SendEmailReply(ctx context.Context) {
 	b.mu.Lock(evt.RoomID.String())
 	defer b.mu.Unlock(evt.RoomID.String())
 
-	meta := b.getParentEmail(evt, mailbox)
+	meta := b.getParentEmail(evt, cfg.Mailboxes())
+
+	if meta.Subscription != "" {
+		b.SendError(ctx, evt.RoomID, "this is a read-only copy of emails to the `"+meta.Subscription+"` mailbox, replies are not allowed, kupo")
+		return
+	}
 
 	if meta.To == "" {
 		b.Error(ctx, evt.RoomID, "cannot find parent email and continue the thread. Please, start a new email thread")
@@ -196,7 +498,7 @@ func (b *Bot) SendEmailReply(ctx context.Context) {
 	var queued bool
 	recipients := meta.Recipients
 	for _, to := range recipients {
-		queued, err = b.Sendmail(evt.ID, meta.From, to, data)
+		queued, err = b.Sendmail(evt.RoomID, evt.ID, meta.From, to, data)
 		if queued {
 			b.log.Error().Err(err).Msg("cannot send email")
 			b.saveSentMetadata(ctx, queued, meta.ThreadID, recipients, eml, cfg)
@@ -224,18 +526,23 @@ type parentEmail struct {
 	References string
 	Subject    string
 	Recipients []string
+	// Subscription is the source mailbox of read-only copies
+	Subscription string
 }
 
 // fixtofrom attempts to "fix" or rather reverse the To, From and CC headers
 // of parent email by using parent email as metadata source for a new email
 // that will be sent from postmoogle.
 // To do so, we need to reverse From and To headers, but Cc should be adjusted as well,
-// thus that hacky workaround below:
-func (e *parentEmail) fixtofrom(newSenderMailbox string, domains []string) string {
-	newSenders := make(map[string]string, len(domains))
-	for _, domain := range domains {
-		sender := newSenderMailbox + "@" + domain
-		newSenders[sender] = sender
+// thus that hacky workaround below.
+// The room mailbox may have aliases, so any of them could be the previous sender:
+func (e *parentEmail) fixtofrom(newSenderMailboxes []string, domains []string) string {
+	newSenders := make(map[string]string, len(domains)*len(newSenderMailboxes))
+	for _, mailbox := range newSenderMailboxes {
+		for _, domain := range domains {
+			sender := mailbox + "@" + domain
+			newSenders[sender] = sender
+		}
 	}
 
 	// try to determine previous email of the room mailbox
@@ -330,7 +637,7 @@ func (b *Bot) getParentEvent(evt *event.Event) (id.EventID, *event.Event) {
 	return threadID, decrypted
 }
 
-func (b *Bot) getParentEmail(evt *event.Event, newFromMailbox string) *parentEmail {
+func (b *Bot) getParentEmail(evt *event.Event, newFromMailboxes []string) *parentEmail {
 	parent := &parentEmail{}
 	threadID, parentEvt := b.getParentEvent(evt)
 	parent.ThreadID = threadID
@@ -347,7 +654,8 @@ func (b *Bot) getParentEmail(evt *event.Event, newFromMailbox string) *parentEma
 	parent.RcptTo = utils.EventField[string](&parentEvt.Content, eventRcptToKey)
 	parent.InReplyTo = utils.EventField[string](&parentEvt.Content, eventMessageIDkey)
 	parent.References = utils.EventField[string](&parentEvt.Content, eventReferencesKey)
-	senderEmail := parent.fixtofrom(newFromMailbox, b.domains)
+	parent.Subscription = utils.EventField[string](&parentEvt.Content, eventSubscriptionKey)
+	senderEmail := parent.fixtofrom(newFromMailboxes, b.domains)
 	parent.calculateRecipients(senderEmail)
 	parent.MessageID = email.MessageID(parentEvt.ID, parent.FromDomain)
 	if parent.InReplyTo == "" {
@@ -399,6 +707,47 @@ func (b *Bot) saveSentMetadata(ctx context.Context, queued bool, threadID id.Eve
 	b.setLastEventID(evt.RoomID, threadID, msgID)
 }
 
+// uploadImages uploads inline images referenced by the email HTML (cid: URLs), so they are rendered in place,
+// and returns the uploaded images (content ID -> mxc URI) and the rest of inline files to be sent as attachments.
+// Encrypted rooms are skipped, because the message can't reference encrypted media - inline images are sent as attachments there
+func (b *Bot) uploadImages(roomID id.RoomID, eml *email.Email) (map[string]string, []*utils.File) {
+	if eml.HTML == "" || len(eml.InlineFiles) == 0 || b.lp.GetMachine().StateStore.IsEncrypted(roomID) {
+		return nil, eml.InlineFiles
+	}
+
+	images := make(map[string]string, len(eml.InlineFiles))
+	files := make([]*utils.File, 0, len(eml.InlineFiles))
+	for _, file := range eml.InlineFiles {
+		if file.ContentID == "" || file.MsgType != event.MsgImage || !strings.Contains(eml.HTML, "cid:"+file.ContentID) {
+			files = append(files, file)
+			continue
+		}
+		resp, err := b.lp.GetClient().UploadMedia(*file.Convert())
+		if err != nil {
+			b.log.Error().Err(err).Str("file", file.Name).Msg("cannot upload inline image")
+			files = append(files, file)
+			continue
+		}
+		images[file.ContentID] = string(resp.ContentURI.CUString())
+	}
+
+	return images, files
+}
+
+// uploadEncrypted encrypts a copy of the data and uploads it, the returned file info contains the decryption keys
+func (b *Bot) uploadEncrypted(data []byte, name string) (*event.EncryptedFileInfo, error) {
+	ciphertext := make([]byte, len(data))
+	copy(ciphertext, data)
+	file := attachment.NewEncryptedFile()
+	file.EncryptInPlace(ciphertext)
+	resp, err := b.lp.GetClient().UploadBytesWithName(ciphertext, "application/octet-stream", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event.EncryptedFileInfo{EncryptedFile: *file, URL: resp.ContentURI.CUString()}, nil
+}
+
 func (b *Bot) sendFiles(ctx context.Context, roomID id.RoomID, files []*utils.File, noThreads bool, parentID id.EventID) {
 	for _, file := range files {
 		req := file.Convert()
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+const truncatedNote = "\n\n---\n\n**The message is too long and has been truncated, the full message is attached, kupo**"
+
+// encryptionOverhead of the megolm event envelope (sender key, session ID, device ID, etc.), in bytes
+const encryptionOverhead = 1024
+
+// truncateContent cuts the message body if the event content exceeds the max size,
+// returns true if the message has been truncated.
+// The truncated body is rendered without HTML, because cutting HTML may break its markup.
+// The body is cut from the end, so the mentions prepended to it are kept
+func truncateContent(content *event.Content, maxSize int, encrypted bool) bool {
+	parsed, ok := content.Parsed.(*event.MessageEventContent)
+	if !ok || contentSize(content, encrypted) <= maxSize {
+		return false
+	}
+
+	body := parsed.Body
+	limit := maxSize / 2
+	for limit > 0 {
+		body = truncateString(body, limit)
+		truncated := format.RenderMarkdown(body+"…"+truncatedNote, true, false)
+		parsed.Body = truncated.Body
+		parsed.Format = truncated.Format
+		parsed.FormattedBody = truncated.FormattedBody
+		if contentSize(content, encrypted) <= maxSize {
+			return true
+		}
+		limit /= 2
+	}
+
+	parsed.Body = strings.TrimSpace(truncatedNote)
+	parsed.Format = ""
+	parsed.FormattedBody = ""
+	return true
+}
+
+// contentSize returns size of the marshalled event content, in encrypted rooms - size of the encrypted event
+// (the ciphertext is base64-encoded, so it's ~33% larger)
+func contentSize(content *event.Content, encrypted bool) int {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return math.MaxInt
+	}
+	if !encrypted {
+		return len(data)
+	}
+
+	return base64.RawStdEncoding.EncodedLen(len(data)) + encryptionOverhead
+}
+
+// truncateString cuts the string to the max length (bytes) without breaking runes
+func truncateString(str string, length int) string {
+	if len(str) <= length {
+		return str
+	}
+	for length > 0 && !utf8.RuneStart(str[length]) {
+		length--
+	}
+
+	return str[:length]
+}
+
+// sendEML attaches the original email as .eml file to the thread
+func (b *Bot) sendEML(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email, threadID id.EventID) {
+	if len(eml.Raw) == 0 {
+		return
+	}
+	file := utils.NewFile("email.eml", eml.Raw)
+	file.Type = "message/rfc822"
+	file.MsgType = event.MsgFile
+
+	b.sendFiles(ctx, roomID, []*utils.File{file}, cfg.NoThreads(), threadID)
+}
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func newTextContent(body string) *event.Content {
+	return &event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: body}}
+}
+
+func TestContentSize(t *testing.T) {
+	content := newTextContent("hello")
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatalf("cannot marshal content: %v", err)
+	}
+	tests := []struct {
+		name      string
+		encrypted bool
+		expected  int
+	}{
+		{name: "plain", encrypted: false, expected: len(data)},
+		{name: "encrypted", encrypted: true, expected: base64.RawStdEncoding.EncodedLen(len(data)) + encryptionOverhead},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if size := contentSize(content, test.encrypted); size != test.expected {
+				t.Errorf("contentSize() = %d, want %d", size, test.expected)
+			}
+		})
+	}
+}
+
+func TestTruncateContent(t *testing.T) {
+	body := strings.Repeat("мяу ", 500)
+	size := contentSize(newTextContent(body), false)
+	tests := []struct {
+		name      string
+		maxSize   int
+		encrypted bool
+		truncated bool
+	}{
+		{name: "below the limit", maxSize: size + 1, truncated: false},
+		{name: "at the limit", maxSize: size, truncated: false},
+		{name: "above the limit", maxSize: size - 1, truncated: true},
+		{name: "encrypted at the plain limit", maxSize: size, encrypted: true, truncated: true},
+		{name: "tiny limit", maxSize: 10, truncated: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := newTextContent(body)
+			if truncated := truncateContent(content, test.maxSize, test.encrypted); truncated != test.truncated {
+				t.Fatalf("truncateContent() = %t, want %t", truncated, test.truncated)
+			}
+			parsed := content.AsMessage()
+			if !test.truncated {
+				if parsed.Body != body {
+					t.Errorf("Body has been changed: %q", parsed.Body)
+				}
+				return
+			}
+			if !utf8.ValidString(parsed.Body) || !utf8.ValidString(parsed.FormattedBody) {
+				t.Errorf("truncated body is not valid UTF-8: %q", parsed.Body)
+			}
+			if !strings.Contains(parsed.Body, "truncated") {
+				t.Errorf("Body = %q, want the truncation note", parsed.Body)
+			}
+			if test.maxSize > 1000 && contentSize(content, test.encrypted) > test.maxSize {
+				t.Errorf("contentSize() = %d, want <= %d", contentSize(content, test.encrypted), test.maxSize)
+			}
+		})
+	}
+}
+
+func TestTruncateContentMentions(t *testing.T) {
+	content := newTextContent(strings.Repeat("text ", 1000))
+	addMentions(content, []id.UserID{"@user:example.com"}, false)
+	if !truncateContent(content, 2000, false) {
+		t.Fatal("truncateContent() = false, want true")
+	}
+	parsed := content.AsMessage()
+	if !strings.HasPrefix(parsed.Body, "@user:example.com") {
+		t.Errorf("Body = %q, want the mention kept", parsed.Body)
+	}
+	if parsed.Mentions == nil || len(parsed.Mentions.UserIDs) != 1 {
+		t.Errorf("Mentions = %v, want the mentioned user kept", parsed.Mentions)
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		name     string
+		str      string
+		length   int
+		expected string
+	}{
+		{name: "shorter", str: "abc", length: 5, expected: "abc"},
+		{name: "exact", str: "abc", length: 3, expected: "abc"},
+		{name: "ascii", str: "abcdef", length: 3, expected: "abc"},
+		{name: "rune boundary", str: "яяя", length: 4, expected: "яя"},
+		{name: "within 2-byte rune", str: "яяя", length: 3, expected: "я"},
+		{name: "within 4-byte rune", str: "a😀b", length: 3, expected: "a"},
+		{name: "within first rune", str: "😀", length: 2, expected: ""},
+		{name: "zero", str: "abc", length: 0, expected: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := truncateString(test.str, test.length); result != test.expected {
+				t.Errorf("truncateString(%q, %d) = %q, want %q", test.str, test.length, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestRawHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{name: "crlf", raw: "From: a@example.com\r\nSubject: hi\r\n\r\nbody\r\n\r\nmore", expected: "From: a@example.com\r\nSubject: hi"},
+		{name: "lf", raw: "From: a@example.com\nSubject: hi\n\nbody", expected: "From: a@example.com\nSubject: hi"},
+		{name: "lf separator before crlf one", raw: "From: a@example.com\n\nbody\r\n\r\nmore", expected: "From: a@example.com"},
+		{name: "headers only", raw: "From: a@example.com\r\n", expected: "From: a@example.com"},
+		{name: "empty", raw: "", expected: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if headers := string(rawHeaders([]byte(test.raw))); headers != test.expected {
+				t.Errorf("rawHeaders() = %q, want %q", headers, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"strconv"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// expiringIndexes of the room account data keys that expire, map of key prefix = index key.
+// Account data cannot be listed, so each index keeps key suffixes with their expiration time (unix timestamp)
+var expiringIndexes = map[string]string{
+	acVERPPrefix:        acVERPIndexKey,
+	acAutoreplyPrefix:   acAutoreplyIndexKey,
+	acListConfirmPrefix: acListConfirmIndexKey,
+	acSourcePrefix:      acSourceIndexKey,
+}
+
+// setExpiring saves the room account data under the prefix.suffix key and adds the key to the prefix's index,
+// so it will be pruned after the until time
+func (b *Bot) setExpiring(roomID id.RoomID, prefix, suffix string, data map[string]string, until time.Time) error {
+	key := prefix + "." + suffix
+	if err := b.lp.SetRoomAccountData(roomID, key, data); err != nil {
+		return err
+	}
+
+	b.expiringMu.Lock()
+	defer b.expiringMu.Unlock()
+
+	indexKey := expiringIndexes[prefix]
+	index, err := b.lp.GetRoomAccountData(roomID, indexKey)
+	if err != nil {
+		b.log.Error().Err(err).Str("key", indexKey).Msg("cannot retrieve expiring keys index, the key will not be pruned")
+		return nil
+	}
+	updated := make(map[string]string, len(index)+1)
+	for k, v := range index {
+		updated[k] = v
+	}
+	updated[suffix] = strconv.FormatInt(until.Unix(), 10)
+	if err := b.lp.SetRoomAccountData(roomID, indexKey, updated); err != nil {
+		b.log.Error().Err(err).Str("key", indexKey).Msg("cannot save expiring keys index, the key will not be pruned")
+	}
+
+	return nil
+}
+
+// PruneExpiring removes expired room account data (VERP tokens, autoreply timestamps, list subscription requests, email sources) of all rooms
+func (b *Bot) PruneExpiring() {
+	seen := map[id.RoomID]bool{}
+	b.rooms.Range(func(_, v any) bool {
+		roomID, ok := v.(id.RoomID)
+		if !ok || seen[roomID] {
+			return true
+		}
+		seen[roomID] = true
+
+		for prefix, indexKey := range expiringIndexes {
+			b.pruneExpiring(roomID, prefix, indexKey)
+		}
+		return true
+	})
+}
+
+// pruneExpiring empties expired keys of the index (account data cannot be deleted) and removes them from the index
+func (b *Bot) pruneExpiring(roomID id.RoomID, prefix, indexKey string) {
+	b.expiringMu.Lock()
+	defer b.expiringMu.Unlock()
+
+	index, err := b.lp.GetRoomAccountData(roomID, indexKey)
+	if err != nil {
+		b.log.Error().Err(err).Str("key", indexKey).Str("roomID", roomID.String()).Msg("cannot retrieve expiring keys index")
+		return
+	}
+	if len(index) == 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+	updated := make(map[string]string, len(index))
+	for suffix, until := range index {
+		if utils.Int64(until) > now {
+			updated[suffix] = until
+			continue
+		}
+		key := prefix + "." + suffix
+		if err := b.lp.SetRoomAccountData(roomID, key, map[string]string{}); err != nil {
+			b.log.Error().Err(err).Str("key", key).Str("roomID", roomID.String()).Msg("cannot prune expired key")
+			updated[suffix] = until
+		}
+	}
+	if len(updated) == len(index) {
+		return
+	}
+	if err := b.lp.SetRoomAccountData(roomID, indexKey, updated); err != nil {
+		b.log.Error().Err(err).Str("key", indexKey).Str("roomID", roomID.String()).Msg("cannot save expiring keys index")
+		return
+	}
+	b.log.Info().Int("count", len(index)-len(updated)).Str("prefix", prefix).Str("roomID", roomID.String()).Msg("pruned expired keys")
+}
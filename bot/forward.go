@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/etke.cc/go/secgen"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+const (
+	// forwardHeader lists mailboxes that have already forwarded the email, to detect forwarding loops
+	forwardHeader = "X-Postmoogle-Forwarded"
+	// forwardMaxReceived is the max count of Received headers, emails with more hops are not forwarded
+	forwardMaxReceived = 30
+)
+
+// forward sends copies of the original email to the room's forward addresses
+func (b *Bot) forward(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email) {
+	b.forwardTo(ctx, roomID, cfg, eml, cfg.Forward())
+}
+
+// forwardTo sends copies of the original email to the addresses (forward option, sieve redirect),
+// rewriting envelope sender with SRS, so SPF of the sender's domain still passes
+func (b *Bot) forwardTo(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email, addresses []string) {
+	if len(addresses) == 0 || len(eml.Raw) == 0 {
+		return
+	}
+	// bounces cannot be forwarded, because SMTP client doesn't support null reverse-path
+	if eml.EnvelopeFrom == "" {
+		b.log.Debug().Str("roomID", roomID.String()).Msg("email without envelope sender is not forwarded")
+		return
+	}
+	domain := utils.SanitizeDomain(eml.Domain(true))
+	mailbox := cfg.Mailbox() + "@" + domain
+	if reason := forwardLoop(eml, mailbox); reason != "" {
+		b.log.Warn().Str("roomID", roomID.String()).Str("from", eml.From).Str("reason", reason).Msg("email is not forwarded")
+		return
+	}
+
+	from := eml.EnvelopeFrom
+	if !b.isOwnDomain(utils.Hostname(from)) {
+		secret, err := b.srsSecret()
+		if err != nil {
+			b.Error(ctx, roomID, "cannot forward email: %v", err)
+			return
+		}
+		from = utils.SRS(from, domain, secret)
+	}
+	data := forwardHeader + ": " + mailbox + "\r\n" + string(eml.Raw)
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	for _, to := range addresses {
+		if _, err := b.trySendmail(newQueueID("forward", eml.MessageID, mailbox, to, now), from, to, data); err != nil {
+			b.Error(ctx, roomID, "cannot forward email to %s: %v", to, err)
+		}
+	}
+}
+
+// forwardLoop returns reason why forwarding the email would cause a loop, empty string means it wouldn't
+func forwardLoop(eml *email.Email, mailbox string) string {
+	if eml.Headers == nil {
+		return ""
+	}
+	if len(eml.Headers.Values("Received")) > forwardMaxReceived {
+		return "too many hops"
+	}
+	for _, value := range eml.Headers.Values(forwardHeader) {
+		if strings.EqualFold(strings.TrimSpace(value), mailbox) {
+			return "forwarding loop"
+		}
+	}
+
+	return ""
+}
+
+// incomingSRS relays bounce sent to SRS address to the original sender of the forwarded email.
+// SMTP client doesn't support null reverse-path, so postmaster of the domain is used as envelope sender,
+// and to avoid bounce loops, bounces of automatic emails and already relayed bounces are dropped
+func (b *Bot) incomingSRS(eml *email.Email) error {
+	to, ok := b.ReverseSRS(eml.RcptTo)
+	if !ok {
+		return errors.New("invalid SRS address")
+	}
+	from := "postmaster@" + utils.Hostname(eml.RcptTo)
+	if eml.DSN != nil && eml.DSN.OriginalAutomatic() {
+		b.log.Info().Str("rcptTo", eml.RcptTo).Str("to", to).Msg("bounce of automatic email is not relayed")
+		return nil
+	}
+	if reason := forwardLoop(eml, from); reason != "" {
+		b.log.Warn().Str("rcptTo", eml.RcptTo).Str("to", to).Str("reason", reason).Msg("bounce is not relayed")
+		return nil
+	}
+	b.log.Info().Str("rcptTo", eml.RcptTo).Str("to", to).Msg("relaying bounce of the forwarded email")
+	data := forwardHeader + ": " + from + "\r\n" + string(eml.Raw)
+	_, err := b.trySendmail(newQueueID("srs", eml.MessageID, eml.RcptTo, strconv.FormatInt(time.Now().UnixNano(), 10)), from, to, data)
+
+	return err
+}
+
+// ReverseSRS returns original address of the SRS address, false if the address is invalid, forged or expired
+func (b *Bot) ReverseSRS(address string) (string, bool) {
+	secret, err := b.srsSecret()
+	if err != nil {
+		b.log.Error().Err(err).Msg("cannot get SRS secret")
+		return "", false
+	}
+
+	return utils.ReverseSRS(address, secret)
+}
+
+// srsSecret returns SRS secret, generating it on first use
+func (b *Bot) srsSecret() ([]byte, error) {
+	cfg := b.cfg.GetBot()
+	if secret := cfg.SRSSecret(); secret != "" {
+		return []byte(secret), nil
+	}
+
+	b.mu.Lock(config.BotSRSSecret)
+	defer b.mu.Unlock(config.BotSRSSecret)
+	cfg = b.cfg.GetBot()
+	if secret := cfg.SRSSecret(); secret != "" {
+		return []byte(secret), nil
+	}
+	secret := secgen.Password(64)
+	cfg.Set(config.BotSRSSecret, secret)
+	if err := b.cfg.SetBot(cfg); err != nil {
+		return nil, err
+	}
+
+	return []byte(secret), nil
+}
+
+// isOwnDomain checks if the domain is one of the postmoogle domains
+func (b *Bot) isOwnDomain(domain string) bool {
+	for _, own := range b.domains {
+		if strings.EqualFold(own, domain) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,170 @@
+package bot
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+const (
+	// defaultGreylistRetry is the retry window in hours, if sender doesn't retry within it, greylisting starts over
+	defaultGreylistRetry = 48
+	// defaultGreylistExpiry is the whitelist expiration in days
+	defaultGreylistExpiry = 35
+	// defaultGreylistDelay is the greylisting duration in minutes of suspicious senders, when greylisting is disabled
+	defaultGreylistDelay = 5
+)
+
+// defaultGreylistProviders is a list of well-known email providers,
+// senders from them are whitelisted if they pass SPF or DKIM checks
+var defaultGreylistProviders = []string{
+	"gmail.com",
+	"googlemail.com",
+	"outlook.com",
+	"hotmail.com",
+	"live.com",
+	"msn.com",
+	"yahoo.com",
+	"aol.com",
+	"icloud.com",
+	"me.com",
+	"mac.com",
+	"proton.me",
+	"protonmail.com",
+	"fastmail.com",
+	"zoho.com",
+	"gmx.com",
+	"gmx.net",
+	"gmx.de",
+	"web.de",
+	"yandex.ru",
+	"mail.ru",
+}
+
+func (b *Bot) getGreylistSettings() (delay, retry, expiry time.Duration) {
+	cfg := b.cfg.GetBot()
+	delay = time.Duration(cfg.Greylist()) * time.Minute
+
+	retryHours := cfg.GreylistRetry()
+	if retryHours == 0 {
+		retryHours = defaultGreylistRetry
+	}
+	retry = time.Duration(retryHours) * time.Hour
+
+	expiryDays := cfg.GreylistExpiry()
+	if expiryDays == 0 {
+		expiryDays = defaultGreylistExpiry
+	}
+	expiry = time.Duration(expiryDays) * 24 * time.Hour
+
+	return delay, retry, expiry
+}
+
+func (b *Bot) isGreylistProvider(from string) bool {
+	providers := b.cfg.GetBot().GreylistProviders()
+	if len(providers) == 0 {
+		providers = defaultGreylistProviders
+	}
+	hostname := strings.ToLower(utils.Hostname(from))
+	for _, provider := range providers {
+		if hostname == strings.TrimSpace(provider) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsGreylisted checks if (network, sender, recipient) triplet is greylisted.
+// Suspicious senders are greylisted even if greylisting is disabled.
+// The authenticated func is called only for senders of well-known providers,
+// and if it returns true, the (network, sender) pair will be whitelisted without greylisting.
+// Senders are never whitelisted from any network, because the From address can be forged
+func (b *Bot) IsGreylisted(addr net.Addr, from, to string, suspicious bool, authenticated func() bool) bool {
+	delay, retry, _ := b.getGreylistSettings()
+	if delay == 0 && suspicious {
+		delay = defaultGreylistDelay * time.Minute
+	}
+	if delay == 0 {
+		return false
+	}
+
+	b.greylistMu.Lock()
+	defer b.greylistMu.Unlock()
+
+	now := time.Now().UTC()
+	network := utils.AddrNetwork(addr)
+	whitelist := b.cfg.GetGreylistWhitelist()
+	if key, ok := whitelist.Whitelisted(network, from); ok {
+		// update last seen time, but not too often
+		if lastSeen, _ := whitelist.Get(key); lastSeen.Add(time.Hour).Before(now) {
+			whitelist.Set(key, now)
+			b.saveGreylistWhitelist(whitelist)
+		}
+		return false
+	}
+
+	if b.isGreylistProvider(from) && authenticated() {
+		b.log.Debug().Str("network", network).Str("from", from).Msg("sender of well-known provider has been authenticated, whitelisting")
+		whitelist.Set(config.GreylistPair(network, from), now)
+		b.saveGreylistWhitelist(whitelist)
+		return false
+	}
+
+	greylist := b.cfg.GetGreylist()
+	key := config.GreylistTriplet(network, from, to)
+	firstSeen, ok := greylist.Get(key)
+	// new triplet or sender didn't retry within the retry window
+	if !ok || firstSeen.Add(retry).Before(now) {
+		b.log.Debug().Str("network", network).Str("from", from).Str("to", to).Msg("greylisting")
+		greylist.Set(key, now)
+		b.saveGreylist(greylist)
+		return true
+	}
+	// retried too early
+	if firstSeen.Add(delay).After(now) {
+		return true
+	}
+
+	b.log.Debug().Str("network", network).Str("from", from).Msg("greylisting passed, whitelisting")
+	greylist.Remove(key)
+	whitelist.Set(config.GreylistPair(network, from), now)
+	b.saveGreylist(greylist)
+	b.saveGreylistWhitelist(whitelist)
+	return false
+}
+
+// PruneGreylist removes expired greylist and whitelist entries
+func (b *Bot) PruneGreylist() {
+	_, retry, expiry := b.getGreylistSettings()
+
+	b.greylistMu.Lock()
+	defer b.greylistMu.Unlock()
+
+	greylist := b.cfg.GetGreylist()
+	if count := greylist.Prune(retry); count > 0 {
+		b.log.Info().Int("count", count).Msg("pruned expired greylist entries")
+		b.saveGreylist(greylist)
+	}
+
+	whitelist := b.cfg.GetGreylistWhitelist()
+	if count := whitelist.Prune(expiry); count > 0 {
+		b.log.Info().Int("count", count).Msg("pruned expired greylist whitelist entries")
+		b.saveGreylistWhitelist(whitelist)
+	}
+}
+
+func (b *Bot) saveGreylist(greylist config.Greylist) {
+	if err := b.cfg.SetGreylist(greylist); err != nil {
+		b.log.Error().Err(err).Msg("cannot update greylist")
+	}
+}
+
+func (b *Bot) saveGreylistWhitelist(whitelist config.Greylist) {
+	if err := b.cfg.SetGreylistWhitelist(whitelist); err != nil {
+		b.log.Error().Err(err).Msg("cannot update greylist whitelist")
+	}
+}
@@ -0,0 +1,376 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// email commands of the mailing list, e.g. mailbox+subscribe@example.com
+const (
+	listSubscribe   = "subscribe"
+	listUnsubscribe = "unsubscribe"
+	// listConfirm is followed by the token of the subscription request, e.g. mailbox+confirm-TOKEN@example.com
+	listConfirm = "confirm-"
+)
+
+const (
+	acListConfirmPrefix   = "cc.etke.postmoogle.list.confirm"
+	acListConfirmIndexKey = "cc.etke.postmoogle.list.confirm.index"
+	// listConfirmMaxAge of the subscription request (in days)
+	listConfirmMaxAge = 3
+)
+
+// isListCommand checks if the subaddress tag is an email command of the mailing list
+func isListCommand(tag string) bool {
+	return tag == listSubscribe || tag == listUnsubscribe || strings.HasPrefix(tag, listConfirm)
+}
+
+// listHeaders returns mailing list headers of the list address
+func listHeaders(address string) *email.MailingList {
+	mailbox, domain := utils.Mailbox(address), utils.Hostname(address)
+	headers := &email.MailingList{
+		ID:   mailbox + "." + domain,
+		Post: address,
+	}
+	if separator := utils.SubaddressSeparator(); separator != "" {
+		headers.Unsubscribe = mailbox + separator + listUnsubscribe + "@" + domain
+	}
+
+	return headers
+}
+
+// incomingListCommand handles email commands of the mailing list: subscription and unsubscription requests
+// and their confirmations
+func (b *Bot) incomingListCommand(ctx context.Context, roomID id.RoomID, eml *email.Email) error {
+	sender := strings.ToLower(email.Address(eml.From))
+	if eml.EnvelopeFrom == "" || sender == "" || eml.Headers == nil || isAutomatic(eml) {
+		b.log.Debug().Str("from", eml.From).Str("tag", eml.Tag).Msg("mailing list command from automatic email ignored")
+		return nil
+	}
+
+	if eml.Tag == listSubscribe || eml.Tag == listUnsubscribe {
+		return b.requestListConfirmation(roomID, eml, sender, eml.Tag)
+	}
+	action := b.confirmListRequest(roomID, strings.TrimPrefix(eml.Tag, listConfirm), sender)
+	if action == "" {
+		b.log.Debug().Str("from", sender).Str("tag", eml.Tag).Msg("invalid or expired mailing list request confirmation ignored")
+		return nil
+	}
+	return b.updateListMember(ctx, roomID, eml, sender, action == listSubscribe)
+}
+
+// requestListConfirmation sends the confirmation request with tokenised confirmation address to the sender (double opt-in),
+// the sender is (un)subscribed only after the confirmation is received, because the sender address can be spoofed
+func (b *Bot) requestListConfirmation(roomID id.RoomID, eml *email.Email, sender, action string) error {
+	cfg, err := b.cfg.GetRoom(roomID)
+	if err != nil {
+		return err
+	}
+	if utils.SliceContains(cfg.ListMembers(), sender) == (action == listSubscribe) {
+		b.log.Debug().Str("from", sender).Str("action", action).Msg("mailing list request doesn't change the membership, ignored")
+		return nil
+	}
+
+	random := make([]byte, 10)
+	if _, err = rand.Read(random); err != nil {
+		return err
+	}
+	token := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random))
+	until := time.Now().UTC().AddDate(0, 0, listConfirmMaxAge)
+	data := map[string]string{"address": sender, "action": action, "until": strconv.FormatInt(until.Unix(), 10)}
+	if err = b.setExpiring(roomID, acListConfirmPrefix, token, data, until); err != nil {
+		return err
+	}
+
+	address := cfg.Mailbox() + "@" + utils.SanitizeDomain(eml.Domain(true))
+	confirm := cfg.Mailbox() + utils.SubaddressSeparator() + listConfirm + token + "@" + utils.Hostname(address)
+	subject, request := "Confirm subscription to the "+cfg.Mailbox()+" list", "Subscription of this address to"
+	if action == listUnsubscribe {
+		subject, request = "Confirm unsubscription from the "+cfg.Mailbox()+" list", "Unsubscription of this address from"
+	}
+	text := fmt.Sprintf("%s the %s list has been requested.\n\n"+
+		"To confirm it, reply to this email or send an email to %s within %d days.\n"+
+		"If you didn't request it, ignore this email.", request, address, confirm, listConfirmMaxAge)
+	b.sendListReply(eml, address, sender, confirm, subject, text)
+
+	return nil
+}
+
+// confirmListRequest checks the request token of the sender, invalidates it and returns the requested action,
+// empty string if the token is invalid or expired
+func (b *Bot) confirmListRequest(roomID id.RoomID, token, sender string) string {
+	key := acListConfirmPrefix + "." + strings.ToLower(token)
+	data, err := b.lp.GetRoomAccountData(roomID, key)
+	if err != nil {
+		b.log.Error().Err(err).Str("key", key).Msg("cannot retrieve mailing list request")
+		return ""
+	}
+	if data["address"] == "" || data["address"] != sender || utils.Int64(data["until"]) < time.Now().Unix() {
+		return ""
+	}
+	if err := b.lp.SetRoomAccountData(roomID, key, map[string]string{}); err != nil {
+		b.log.Error().Err(err).Str("key", key).Msg("cannot remove mailing list request")
+	}
+	if data["action"] == listUnsubscribe {
+		return listUnsubscribe
+	}
+
+	return listSubscribe
+}
+
+// updateListMember (un)subscribes the sender to the mailing list
+func (b *Bot) updateListMember(ctx context.Context, roomID id.RoomID, eml *email.Email, sender string, subscribe bool) error {
+	b.mu.Lock(roomID.String())
+	defer b.mu.Unlock(roomID.String())
+	cfg, err := b.cfg.GetRoom(roomID)
+	if err != nil {
+		return err
+	}
+	members := cfg.ListMembers()
+	subject, text := "Welcome to the "+cfg.Mailbox()+" list", "You have been subscribed to the list."
+	notice := sender + " has subscribed to the mailing list"
+	if subscribe {
+		members = utils.SliceAdd(members, sender)
+	} else {
+		members = utils.SliceRemove(members, sender)
+		subject, text = "Goodbye from the "+cfg.Mailbox()+" list", "You have been unsubscribed from the list."
+		notice = sender + " has unsubscribed from the mailing list"
+	}
+	cfg.Set(config.RoomListMembers, strings.Join(members, ","))
+	if err := b.cfg.SetRoom(roomID, cfg); err != nil {
+		return err
+	}
+	b.SendNotice(ctx, roomID, notice)
+
+	address := cfg.Mailbox() + "@" + utils.SanitizeDomain(eml.Domain(true))
+	b.sendListReply(eml, address, sender, "", subject, text)
+
+	return nil
+}
+
+// sendListReply sends automatic reply of the mailing list to the email command
+func (b *Bot) sendListReply(eml *email.Email, address, to, replyTo, subject, text string) {
+	messageID := fmt.Sprintf("<list.%s.%s@%s>", eml.Tag, strings.Trim(eml.MessageID, "<>"), utils.Hostname(address))
+	reply := email.New(messageID, eml.MessageID, eml.MessageID, subject, address, to, to, "", text, "", nil, nil)
+	reply.AutoSubmitted = "auto-replied"
+	reply.ReplyTo = replyTo
+	reply.List = listHeaders(address)
+	// sent without VERP, because the reply is not related to any event
+	if _, err := b.trySendmail(newQueueID(messageID, to), address, to, reply.Compose(b.cfg.GetBot().DKIMPrivateKey())); err != nil {
+		b.log.Error().Err(err).Str("to", to).Msg("cannot send mailing list reply")
+	}
+}
+
+// redistribute sends the email to all members of the mailing list, except the sender.
+// Only emails of the list members are redistributed, others are kept in the room only.
+// From is rewritten to the list address, so DMARC of the sender's domain still passes
+func (b *Bot) redistribute(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email) {
+	if !cfg.List() {
+		return
+	}
+	address := cfg.Mailbox() + "@" + utils.SanitizeDomain(eml.Domain(true))
+	headers := listHeaders(address)
+	if isAutomatic(eml) || (eml.Headers != nil && strings.Contains(eml.Headers.Get("List-Id"), "<"+headers.ID+">")) {
+		b.log.Debug().Str("from", eml.From).Msg("automatic or own mailing list email is not redistributed")
+		return
+	}
+
+	sender := strings.ToLower(email.Address(eml.From))
+	members := cfg.ListMembers()
+	if !utils.SliceContains(members, sender) && !utils.SliceContains(members, strings.ToLower(email.Address(eml.EnvelopeFrom))) {
+		b.SendNotice(ctx, roomID, fmt.Sprintf("The email from %s has not been sent to the mailing list, because the sender is not a list member. "+
+			"To post it to the list, `%s send` it to the mailbox address, kupo.", sender, b.prefix))
+		return
+	}
+
+	copied := email.New(eml.MessageID, eml.InReplyTo, eml.References, eml.Subject, address, address, "", "", eml.Text, eml.HTML, eml.Files, nil)
+	copied.FromName = listFromName(eml, cfg.Mailbox())
+	copied.ReplyTo = address
+	copied.List = headers
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	for _, member := range members {
+		if member == sender {
+			continue
+		}
+		copied.RcptTo = member
+		queueID := newQueueID("list", eml.MessageID, member, now)
+		if _, err := b.trySendmail(queueID, address, member, copied.Compose(b.cfg.GetBot().DKIMPrivateKey())); err != nil {
+			b.Error(ctx, roomID, "cannot send email to the list member %s: %v", member, err)
+		}
+	}
+}
+
+// listFromName returns display name of the redistributed email, e.g. "Jane Doe via mailbox"
+func listFromName(eml *email.Email, mailbox string) string {
+	name := eml.From
+	if eml.Headers != nil {
+		if from, err := mail.ParseAddress(eml.Headers.Get("From")); err == nil && from.Name != "" {
+			name = from.Name
+		}
+	}
+
+	return name + " via " + mailbox
+}
+
+// isAutomatic checks if the email is automatic response or bulk email (RFC 3834)
+func isAutomatic(eml *email.Email) bool {
+	if eml.Headers == nil {
+		return false
+	}
+	if auto := strings.ToLower(eml.Headers.Get("Auto-Submitted")); auto != "" && auto != "no" {
+		return true
+	}
+	switch strings.ToLower(eml.Headers.Get("Precedence")) {
+	case "bulk", "list", "junk":
+		return true
+	}
+
+	return false
+}
+
+// listRecipients replaces the list address in the recipients with the list members,
+// returns the recipients and members the email is posted to
+func listRecipients(cfg config.Room, domain string, tos []string) (recipients []string, members map[string]bool) {
+	members = map[string]bool{}
+	for _, to := range tos {
+		if !cfg.List() || !strings.EqualFold(to, cfg.Mailbox()+"@"+domain) {
+			recipients = append(recipients, to)
+			continue
+		}
+		for _, member := range cfg.ListMembers() {
+			if !members[member] {
+				members[member] = true
+				recipients = append(recipients, member)
+			}
+		}
+	}
+
+	return recipients, members
+}
+
+func (b *Bot) runList(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) > 1 && (commandSlice[1] == "on" || commandSlice[1] == "off") {
+		b.updateList(ctx, func(cfg config.Room) string {
+			cfg.Set(config.RoomList, utils.SanitizeBoolString(commandSlice[1]))
+			return "mailing list mode has been turned " + commandSlice[1] + ", kupo"
+		})
+		return
+	}
+
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+	var msg strings.Builder
+	msg.WriteString("Mailing list mode is **")
+	if cfg.List() {
+		msg.WriteString("on")
+	} else {
+		msg.WriteString("off")
+	}
+	msg.WriteString("**, kupo.\n\n")
+	members := cfg.ListMembers()
+	if len(members) == 0 {
+		msg.WriteString("The list has no members so far.\n\n")
+	} else {
+		msg.WriteString("Members of the list:\n")
+		for _, member := range members {
+			msg.WriteString("* ")
+			msg.WriteString(member)
+			msg.WriteString("\n")
+		}
+		msg.WriteString("\n")
+	}
+	msg.WriteString("Usage: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" list on|off`, `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" list:add ADDRESS`, `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" list:remove ADDRESS`. ")
+	if separator := utils.SubaddressSeparator(); separator != "" {
+		msg.WriteString("Anyone can subscribe by sending an email to `")
+		msg.WriteString(cfg.Mailbox() + separator + listSubscribe)
+		msg.WriteString("@` and unsubscribe with `")
+		msg.WriteString(cfg.Mailbox() + separator + listUnsubscribe)
+		msg.WriteString("@`, both have to be confirmed by replying to the confirmation email. ")
+	}
+	msg.WriteString("Only emails of the list members are sent to the list. ")
+	msg.WriteString("To post to the list from the room, `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" send` an email to the mailbox address.")
+
+	b.SendNotice(ctx, evt.RoomID, msg.String())
+}
+
+func (b *Bot) runListAdd(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runList(ctx, commandSlice[:1])
+		return
+	}
+	addresses := utils.StringSlice(strings.Join(commandSlice[1:], ","))
+	for _, address := range addresses {
+		if !email.AddressValid(address) {
+			b.SendError(ctx, evt.RoomID, fmt.Sprintf("`%s` is not a valid email address, kupo", address))
+			return
+		}
+	}
+	b.updateList(ctx, func(cfg config.Room) string {
+		members := cfg.ListMembers()
+		for _, address := range addresses {
+			members = utils.SliceAdd(members, strings.ToLower(email.Address(address)))
+		}
+		cfg.Set(config.RoomListMembers, strings.Join(members, ","))
+		return "the list members have been added, kupo"
+	})
+}
+
+func (b *Bot) runListRemove(ctx context.Context, commandSlice []string) {
+	if len(commandSlice) < 2 {
+		b.runList(ctx, commandSlice[:1])
+		return
+	}
+	addresses := utils.StringSlice(strings.Join(commandSlice[1:], ","))
+	b.updateList(ctx, func(cfg config.Room) string {
+		members := cfg.ListMembers()
+		for _, address := range addresses {
+			members = utils.SliceRemove(members, strings.ToLower(email.Address(address)))
+		}
+		cfg.Set(config.RoomListMembers, strings.Join(members, ","))
+		return "the list members have been removed, kupo"
+	})
+}
+
+// updateList updates room settings under the room lock
+func (b *Bot) updateList(ctx context.Context, update func(config.Room) string) {
+	evt := eventFromContext(ctx)
+	b.mu.Lock(evt.RoomID.String())
+	defer b.mu.Unlock(evt.RoomID.String())
+
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+	msg := update(cfg)
+	if err := b.cfg.SetRoom(evt.RoomID, cfg); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+		return
+	}
+	b.SendNotice(ctx, evt.RoomID, msg)
+}
@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+const (
+	// defaultAuthAttempts is the count of failed SMTP logins, after which the mailbox will be locked
+	defaultAuthAttempts = 10
+	// defaultAuthLockout is the duration of mailbox lockout in minutes
+	defaultAuthLockout = 15
+	// authNotifyEvery - send a notice to the mailbox's room every N failed logins
+	authNotifyEvery = 5
+	// authMaxDelay is the max delay of a failed login response
+	authMaxDelay = 10 * time.Second
+)
+
+type loginFailures struct {
+	count  int
+	last   time.Time
+	locked time.Time
+	hosts  map[string]struct{}
+}
+
+// logins tracks failed SMTP logins per mailbox
+type logins struct {
+	mu        sync.Mutex
+	mailboxes map[string]*loginFailures
+}
+
+func newLogins() *logins {
+	return &logins{mailboxes: map[string]*loginFailures{}}
+}
+
+// lockedUntil returns lockout expiration of the mailbox, zero time = not locked
+func (l *logins) lockedUntil(mailbox string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	failures, ok := l.mailboxes[mailbox]
+	if !ok || failures.locked.Before(time.Now().UTC()) {
+		return time.Time{}
+	}
+
+	return failures.locked
+}
+
+// fail registers a failed login and returns current failures count, hosts they came from
+// and whether the mailbox has been locked by this failure.
+// Failures older than lockout are forgotten, the mailbox is locked when count reaches attempts
+func (l *logins) fail(mailbox, host string, attempts int, lockout time.Duration) (int, []string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UTC()
+	l.prune(now, lockout)
+	failures, ok := l.mailboxes[mailbox]
+	if !ok {
+		failures = &loginFailures{hosts: map[string]struct{}{}}
+		l.mailboxes[mailbox] = failures
+	}
+	failures.count++
+	failures.last = now
+	failures.hosts[host] = struct{}{}
+	var locked bool
+	if failures.count%attempts == 0 {
+		failures.locked = now.Add(lockout)
+		locked = true
+	}
+
+	hosts := make([]string, 0, len(failures.hosts))
+	for host := range failures.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	return failures.count, hosts, locked
+}
+
+// reset failures of the mailbox after successful login
+func (l *logins) reset(mailbox string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.mailboxes, mailbox)
+}
+
+// prune forgets stale failures, must be called under lock
+func (l *logins) prune(now time.Time, lockout time.Duration) {
+	for mailbox, failures := range l.mailboxes {
+		if failures.locked.Before(now) && failures.last.Add(lockout).Before(now) {
+			delete(l.mailboxes, mailbox)
+		}
+	}
+}
+
+func (b *Bot) getAuthSettings() (int, time.Duration) {
+	cfg := b.cfg.GetBot()
+	attempts := cfg.AuthAttempts()
+	if attempts == 0 {
+		attempts = defaultAuthAttempts
+	}
+	lockout := cfg.AuthLockout()
+	if lockout == 0 {
+		lockout = defaultAuthLockout
+	}
+
+	return attempts, time.Duration(lockout) * time.Minute
+}
+
+// failAuth registers failed SMTP login, notifies the mailbox's room on repeated failures and lockout,
+// and slows down the response progressively (unless the mailbox is locked already)
+func (b *Bot) failAuth(mailbox string, roomID id.RoomID, addr net.Addr) {
+	attempts, lockout := b.getAuthSettings()
+	count, hosts, locked := b.logins.fail(mailbox, utils.AddrIP(addr), attempts, lockout)
+	b.log.Info().Str("mailbox", mailbox).Str("addr", utils.AddrIP(addr)).Int("failures", count).Bool("locked", locked).Msg("failed SMTP login")
+
+	if count%authNotifyEvery == 0 || locked {
+		ctx := sentry.SetHubOnContext(context.Background(), sentry.CurrentHub())
+		msg := strconv.Itoa(count) + " failed SMTP logins from `" + strings.Join(hosts, "`, `") + "`"
+		if locked {
+			msg += ", mailbox is locked for " + lockout.String()
+		}
+		b.SendNotice(ctx, roomID, msg)
+	}
+	if locked {
+		return
+	}
+
+	delay := time.Duration(count) * time.Second
+	if delay > authMaxDelay {
+		delay = authMaxDelay
+	}
+	time.Sleep(delay)
+}
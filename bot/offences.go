@@ -0,0 +1,188 @@
+package bot
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+const (
+	// defaultBanlistThreshold is the score of a host, after which it will be banned
+	defaultBanlistThreshold = 10
+	// defaultBanlistWindow is the sliding window of offences in minutes
+	defaultBanlistWindow = 60
+)
+
+// defaultOffenceWeights are points added to host's score per offence
+var defaultOffenceWeights = map[string]int{
+	utils.OffenceAuth:    5,
+	utils.OffenceAddress: 3,
+	utils.OffenceNoUser:  2,
+	utils.OffenceSpam:    5,
+	utils.OffenceHelo:    3,
+}
+
+type offence struct {
+	kind   string
+	points int
+	at     time.Time
+}
+
+// offences of hosts within the sliding window
+type offences struct {
+	mu    sync.Mutex
+	hosts map[string][]offence
+}
+
+func newOffences() *offences {
+	return &offences{hosts: map[string][]offence{}}
+}
+
+// add an offence and return the current score of the host
+func (o *offences) add(host, kind string, points int, window time.Duration) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	list := append(o.prune(host, window), offence{kind: kind, points: points, at: time.Now().UTC()})
+	o.hosts[host] = list
+
+	var score int
+	for _, item := range list {
+		score += item.points
+	}
+	return score
+}
+
+// summary of host's offences, eg: "auth x2, nouser x1"
+func (o *offences) summary(host string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, item := range o.hosts[host] {
+		counts[item.kind]++
+	}
+	kinds := make([]string, 0, len(counts))
+	for kind, count := range counts {
+		kinds = append(kinds, kind+" x"+strconv.Itoa(count))
+	}
+	sort.Strings(kinds)
+
+	return strings.Join(kinds, ", ")
+}
+
+// reset offences of the host
+func (o *offences) reset(host string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.hosts, host)
+}
+
+// pruneAll removes offences outside of the window
+func (o *offences) pruneAll(window time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for host := range o.hosts {
+		o.prune(host, window)
+	}
+}
+
+// prune removes host's offences outside of the window, must be called under lock
+func (o *offences) prune(host string, window time.Duration) []offence {
+	since := time.Now().UTC().Add(-window)
+	list := o.hosts[host]
+	actual := make([]offence, 0, len(list))
+	for _, item := range list {
+		if item.at.After(since) {
+			actual = append(actual, item)
+		}
+	}
+	if len(actual) == 0 {
+		delete(o.hosts, host)
+		return actual
+	}
+
+	o.hosts[host] = actual
+	return actual
+}
+
+// slice returns current scores of all hosts with offences, eg: "192.0.2.1: 7 (auth x1, nouser x1)"
+func (o *offences) slice() []string {
+	o.mu.Lock()
+	hosts := make(map[string]int, len(o.hosts))
+	for host, list := range o.hosts {
+		for _, item := range list {
+			hosts[host] += item.points
+		}
+	}
+	o.mu.Unlock()
+
+	slice := make([]string, 0, len(hosts))
+	for host, score := range hosts {
+		slice = append(slice, host+": "+strconv.Itoa(score)+" ("+o.summary(host)+")")
+	}
+	sort.Strings(slice)
+
+	return slice
+}
+
+// clear all offences
+func (o *offences) clear() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.hosts = map[string][]offence{}
+}
+
+func (b *Bot) getOffenceWeight(kind string) int {
+	for _, pair := range b.cfg.GetBot().BanlistWeights() {
+		name, value, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(name) == kind {
+			return utils.Int(strings.TrimSpace(value))
+		}
+	}
+
+	return defaultOffenceWeights[kind]
+}
+
+func (b *Bot) getBanlistThresholds() (int, time.Duration) {
+	cfg := b.cfg.GetBot()
+	threshold := cfg.BanlistThreshold()
+	if threshold == 0 {
+		threshold = defaultBanlistThreshold
+	}
+	window := cfg.BanlistWindow()
+	if window == 0 {
+		window = defaultBanlistWindow
+	}
+
+	return threshold, time.Duration(window) * time.Minute
+}
+
+// Offend adds offence points to the host's score and bans it if the score exceeds the threshold
+func (b *Bot) Offend(addr net.Addr, kind string) {
+	if !b.cfg.GetBot().BanlistEnabled() {
+		return
+	}
+	if b.IsTrusted(addr) {
+		return
+	}
+
+	host := utils.AddrIP(addr)
+	threshold, window := b.getBanlistThresholds()
+	score := b.offences.add(host, kind, b.getOffenceWeight(kind), window)
+	b.log.Debug().Str("addr", host).Str("offence", kind).Int("score", score).Int("threshold", threshold).Msg("offence")
+	if score < threshold {
+		return
+	}
+
+	b.ban(addr, "score "+strconv.Itoa(score)+": "+b.offences.summary(host))
+	b.offences.reset(host)
+}
@@ -0,0 +1,178 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+var errRouteNotFound = errors.New("routing rule not found, kupo")
+
+// getRoute returns the first routing rule matching the mailbox and the room of rule's target mailbox
+func (b *Bot) getRoute(mailbox string) (*config.Route, id.RoomID, bool) {
+	for _, route := range b.cfg.GetCompiledRoutes() {
+		if !route.Match(mailbox) {
+			continue
+		}
+		roomID, ok := b.getMapping(route.Mailbox)
+		if !ok {
+			b.log.Warn().Str("pattern", route.Pattern).Str("mailbox", route.Mailbox).Msg("target mailbox of the routing rule not found")
+			continue
+		}
+		b.log.Debug().Str("pattern", route.Pattern).Str("mailbox", mailbox).Str("target", route.Mailbox).Msg("mailbox has been routed")
+		return route, roomID, true
+	}
+
+	return nil, "", false
+}
+
+// routesOf returns patterns of routing rules targeting the mailbox
+func (b *Bot) routesOf(mailbox string) []string {
+	patterns := []string{}
+	for _, route := range b.cfg.GetCompiledRoutes() {
+		if route.Mailbox == mailbox {
+			patterns = append(patterns, route.Pattern)
+		}
+	}
+
+	return patterns
+}
+
+func (b *Bot) runRoutes(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	routes := b.cfg.GetRoutes().Slice()
+	var msg strings.Builder
+	if len(routes) == 0 {
+		msg.WriteString("No routing rules are set so far, kupo.\n\n")
+	} else {
+		msg.WriteString("Routing rules are checked in the following order after exact mailbox matches:\n")
+		for i, route := range routes {
+			msg.WriteString(strconv.Itoa(i + 1))
+			msg.WriteString(". `")
+			msg.WriteString(route.Pattern)
+			msg.WriteString("` ➡️ `")
+			msg.WriteString(route.Mailbox)
+			msg.WriteString("`\n")
+		}
+		msg.WriteString("\n")
+	}
+	msg.WriteString("To add a rule: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" routes:add PATTERN MAILBOX`, ")
+	msg.WriteString("where pattern is either a wildcard (e.g. `alerts-*`) or a regular expression within slashes (e.g. `/^invoice\\..+$/`)\n")
+	msg.WriteString("To change the order of rules: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" routes:move PATTERN POSITION`\n")
+
+	b.SendNotice(ctx, evt.RoomID, msg.String())
+}
+
+func (b *Bot) runRoutesAdd(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	// get original values, without forced lower case
+	commandSlice := b.parseCommand(evt.Content.AsMessage().Body, false)
+	if len(commandSlice) < 3 {
+		b.runRoutes(ctx)
+		return
+	}
+	mailbox := utils.Mailbox(strings.ToLower(commandSlice[2]))
+	if _, ok := b.getMapping(mailbox); !ok {
+		b.SendError(ctx, evt.RoomID, "mailbox does not exist, kupo.")
+		return
+	}
+	route, err := config.NewRoute(commandSlice[1], mailbox)
+	if err != nil {
+		b.SendError(ctx, evt.RoomID, err.Error())
+		return
+	}
+
+	warnings := []string{}
+	err = b.cfg.UpdateRoutes(func(routes []*config.Route) ([]*config.Route, error) {
+		for _, existing := range routes {
+			if existing.Pattern == route.Pattern {
+				return nil, fmt.Errorf("routing rule with pattern `%s` already exists (to `%s`), remove it first", route.Pattern, existing.Mailbox)
+			}
+			if example := route.Example(); example != "" && existing.Match(example) {
+				warnings = append(warnings, "earlier rule `"+existing.Pattern+"` matches the same mailboxes (e.g. `"+example+"`), so they will be routed to `"+existing.Mailbox+"`")
+			}
+		}
+		return append(routes, route), nil
+	})
+	if err != nil {
+		b.SendError(ctx, evt.RoomID, err.Error())
+		return
+	}
+	b.rooms.Range(func(key, _ any) bool {
+		if existing, ok := key.(string); ok && route.Match(existing) {
+			warnings = append(warnings, "existing mailbox `"+existing+"` matches the pattern, exact matches take precedence")
+		}
+		return true
+	})
+
+	msg := "routing rule has been added, kupo"
+	if len(warnings) > 0 {
+		msg += "\n\nPossible conflicts:\n* " + strings.Join(warnings, "\n* ")
+	}
+	b.SendNotice(ctx, evt.RoomID, msg)
+}
+
+func (b *Bot) runRoutesRemove(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	commandSlice := b.parseCommand(evt.Content.AsMessage().Body, false)
+	if len(commandSlice) < 2 {
+		b.runRoutes(ctx)
+		return
+	}
+
+	err := b.cfg.UpdateRoutes(func(routes []*config.Route) ([]*config.Route, error) {
+		updated := make([]*config.Route, 0, len(routes))
+		for _, route := range routes {
+			if route.Pattern != commandSlice[1] {
+				updated = append(updated, route)
+			}
+		}
+		if len(updated) == len(routes) {
+			return nil, errRouteNotFound
+		}
+		return updated, nil
+	})
+	if err != nil {
+		b.SendError(ctx, evt.RoomID, err.Error())
+		return
+	}
+	b.SendNotice(ctx, evt.RoomID, "routing rule has been removed, kupo")
+}
+
+func (b *Bot) runRoutesMove(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	commandSlice := b.parseCommand(evt.Content.AsMessage().Body, false)
+	if len(commandSlice) < 3 {
+		b.runRoutes(ctx)
+		return
+	}
+	position := utils.Int(commandSlice[2])
+	if position < 1 {
+		b.SendError(ctx, evt.RoomID, "position must be a positive number, kupo")
+		return
+	}
+
+	err := b.cfg.UpdateRoutes(func(routes []*config.Route) ([]*config.Route, error) {
+		updated, ok := config.MoveRoute(routes, commandSlice[1], position-1)
+		if !ok {
+			return nil, errRouteNotFound
+		}
+		return updated, nil
+	})
+	if err != nil {
+		b.SendError(ctx, evt.RoomID, err.Error())
+		return
+	}
+	b.SendNotice(ctx, evt.RoomID, "routing rule has been moved, kupo")
+}
@@ -0,0 +1,201 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+)
+
+// applyRules evaluates the room's rules against the incoming email.
+// Mentions of all matched rules are added, the first matched thread or room rule decides where the email will be posted
+func (b *Bot) applyRules(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email, opts *deliveryOptions) (id.RoomID, config.Room) {
+	placed := opts.threadKey != ""
+	for _, rule := range b.cfg.GetRules(roomID).Slice() {
+		if !rule.Match(eml) {
+			continue
+		}
+		b.log.Debug().Str("roomID", roomID.String()).Str("rule", rule.String()).Msg("email matches the rule")
+		switch rule.Action {
+		case config.RuleActionMention:
+			opts.mentions = append(opts.mentions, id.UserID(rule.Target))
+		case config.RuleActionThread:
+			if !placed {
+				opts.threadKey = ruleThreadKey(rule.Target)
+				placed = true
+			}
+		case config.RuleActionRoom:
+			if placed {
+				continue
+			}
+			targetID, ok := b.getMapping(rule.Target)
+			if !ok {
+				b.log.Warn().Str("rule", rule.String()).Msg("target mailbox of the rule not found")
+				continue
+			}
+			targetCfg, err := b.cfg.GetRoom(targetID)
+			if err != nil {
+				b.Error(ctx, targetID, "cannot get settings: %v", err)
+			}
+			roomID, cfg = targetID, targetCfg
+			placed = true
+		}
+	}
+
+	return roomID, cfg
+}
+
+// ruleThreadKey returns thread mapping key of the rule's dedicated thread
+func ruleThreadKey(name string) string {
+	return "rule:" + strings.ToLower(name)
+}
+
+// addMentions prepends mentions (pills) of the users and the room (@room) to the message
+func addMentions(content *event.Content, mentions []id.UserID, room bool) {
+	msg, ok := content.Parsed.(*event.MessageEventContent)
+	if !ok {
+		return
+	}
+	if msg.FormattedBody == "" {
+		msg.Format = event.FormatHTML
+		msg.FormattedBody = strings.ReplaceAll(html.EscapeString(msg.Body), "\n", "<br>")
+	}
+
+	plain := make([]string, 0, len(mentions)+1)
+	pills := make([]string, 0, len(mentions)+1)
+	if room {
+		plain = append(plain, vipMentionRoom)
+		pills = append(pills, vipMentionRoom)
+	}
+	for _, userID := range mentions {
+		plain = append(plain, userID.String())
+		pills = append(pills, `<a href="https://matrix.to/#/`+userID.String()+`">`+html.EscapeString(userID.String())+`</a>`)
+	}
+	msg.Body = strings.Join(plain, ", ") + "\n\n" + msg.Body
+	msg.FormattedBody = "<p>" + strings.Join(pills, ", ") + "</p>" + msg.FormattedBody
+	msg.Mentions = &event.Mentions{UserIDs: mentions, Room: room}
+}
+
+func (b *Bot) runRules(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	rules := b.cfg.GetRules(evt.RoomID).Slice()
+	var msg strings.Builder
+	if len(rules) == 0 {
+		msg.WriteString("No rules are set so far, kupo.\n\n")
+	} else {
+		msg.WriteString("Rules are checked in the following order:\n")
+		for i, rule := range rules {
+			msg.WriteString(strconv.Itoa(i + 1))
+			msg.WriteString(". ")
+			msg.WriteString(rule.Field)
+			msg.WriteString(" `")
+			msg.WriteString(rule.Pattern)
+			msg.WriteString("` ➡️ ")
+			msg.WriteString(rule.Action)
+			msg.WriteString(" `")
+			msg.WriteString(rule.Target)
+			msg.WriteString("`\n")
+		}
+		msg.WriteString("\n")
+	}
+	msg.WriteString("To add a rule: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" rules:add FIELD PATTERN ACTION TARGET`, where:\n")
+	msg.WriteString("* field is one of `from`, `domain`, `subject`, `header:NAME` (e.g. `header:X-Priority`)\n")
+	msg.WriteString("* pattern is either a wildcard (e.g. `*@github.com`, case-insensitive) or a regular expression within slashes (e.g. `/\\[URGENT\\]/`)\n")
+	msg.WriteString("* action is one of `thread` (post into a dedicated thread, target is the thread name), ")
+	msg.WriteString("`room` (deliver to another mailbox, target is the mailbox name), ")
+	msg.WriteString("`mention` (mention a user, target is the matrix ID)\n\n")
+	msg.WriteString("All matched `mention` rules are applied, the first matched `thread` or `room` rule decides where the email will be posted.\n")
+	msg.WriteString("To reorder rules: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" rules:move FROM TO`, to remove a rule: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" rules:remove NUMBER`")
+
+	b.SendNotice(ctx, evt.RoomID, msg.String())
+}
+
+func (b *Bot) runRulesAdd(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	// get original values, without forced lower case
+	commandSlice := b.parseCommand(evt.Content.AsMessage().Body, false)
+	if len(commandSlice) < 5 {
+		b.runRules(ctx)
+		return
+	}
+	rule, err := config.NewRule(commandSlice[1], commandSlice[2], commandSlice[3], strings.Join(commandSlice[4:], " "))
+	if err != nil {
+		b.SendError(ctx, evt.RoomID, err.Error())
+		return
+	}
+	if rule.Action == config.RuleActionRoom {
+		if _, ok := b.getMapping(rule.Target); !ok {
+			b.SendError(ctx, evt.RoomID, "mailbox does not exist, kupo.")
+			return
+		}
+	}
+
+	rules := b.cfg.GetRules(evt.RoomID).Slice()
+	rules = append(rules, rule)
+	if err := b.cfg.SetRules(evt.RoomID, config.NewRules(rules)); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot set rules: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("rule #%d has been added, kupo", len(rules)))
+}
+
+func (b *Bot) runRulesRemove(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.runRules(ctx)
+		return
+	}
+	rules := b.cfg.GetRules(evt.RoomID).Slice()
+	index, err := strconv.Atoi(commandSlice[1])
+	if err != nil || index < 1 || index > len(rules) {
+		b.SendError(ctx, evt.RoomID, "rule not found, kupo")
+		return
+	}
+
+	rules = append(rules[:index-1], rules[index:]...)
+	if err := b.cfg.SetRules(evt.RoomID, config.NewRules(rules)); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot set rules: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, evt.RoomID, "rule has been removed, kupo")
+}
+
+func (b *Bot) runRulesMove(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 3 {
+		b.runRules(ctx)
+		return
+	}
+	rules := b.cfg.GetRules(evt.RoomID).Slice()
+	from, ferr := strconv.Atoi(commandSlice[1])
+	to, terr := strconv.Atoi(commandSlice[2])
+	if ferr != nil || terr != nil || from < 1 || from > len(rules) || to < 1 || to > len(rules) {
+		b.SendError(ctx, evt.RoomID, "rule not found, kupo")
+		return
+	}
+
+	rule := rules[from-1]
+	rules = append(rules[:from-1], rules[from:]...)
+	rules = append(rules[:to-1], append([]*config.Rule{rule}, rules[to-1:]...)...)
+	if err := b.cfg.SetRules(evt.RoomID, config.NewRules(rules)); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot set rules: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("rule has been moved to #%d, kupo", to))
+}
@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/sieve"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// sieveFolderInbox is the fileinto folder name of the room itself
+const sieveFolderInbox = "inbox"
+
+// applySieve runs the room's Sieve script against the email and performs its actions.
+// Returns false if the email should not be posted to the room (discarded, filed into other rooms or threads, redirected)
+func (b *Bot) applySieve(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email) (bool, error) {
+	eml.Labels = nil
+	src := b.cfg.GetSieve(roomID)
+	if src == "" {
+		return true, nil
+	}
+	script, err := sieve.Parse(src)
+	if err != nil {
+		b.SendError(ctx, roomID, "sieve script is invalid, the email has been kept: "+err.Error())
+		return true, nil
+	}
+
+	result, err := script.Run(&sieve.Message{
+		Header:       eml.Headers,
+		EnvelopeFrom: eml.EnvelopeFrom,
+		EnvelopeTo:   eml.RcptTo,
+		Text:         eml.Text,
+		HTML:         eml.HTML,
+		Raw:          eml.Raw,
+	})
+	if err != nil {
+		b.SendError(ctx, roomID, "sieve script failed, the email has been kept: "+err.Error())
+	}
+	if result.Reject != "" {
+		b.log.Info().Str("roomID", roomID.String()).Str("from", eml.From).Str("reason", result.Reject).Msg("email rejected by sieve script")
+		return false, &email.RejectedError{Reason: result.Reject}
+	}
+
+	for _, folder := range result.FileInto {
+		b.sieveFileInto(ctx, roomID, cfg, eml, folder)
+	}
+	b.forwardTo(ctx, roomID, cfg, eml, result.Redirect)
+	if vacation := result.Vacation; vacation != nil {
+		from := vacation.From
+		if from != "" && !b.isRoomAddress(cfg, from) {
+			b.log.Warn().Str("roomID", roomID.String()).Str("from", from).Msg("sieve vacation :from is not an address of the room, the mailbox is used instead")
+			from = ""
+		}
+		b.sendAutoreply(roomID, cfg, eml, &autoreply{
+			From:      email.Address(from),
+			Subject:   vacation.Subject,
+			Body:      vacation.Reason,
+			Days:      vacation.Days,
+			Handle:    "sieve|" + vacation.Handle,
+			Addresses: vacation.Addresses,
+		})
+	}
+
+	eml.Labels = result.Flags
+	return result.Keep, nil
+}
+
+// SendRejection notifies the sender that the email has been rejected by the recipient's sieve script.
+// It's used when the email has been accepted for other recipients of the same SMTP transaction,
+// so the rejection can't be returned as SMTP error
+func (b *Bot) SendRejection(eml *email.Email, reason string) {
+	if eml.EnvelopeFrom == "" || isAutomatic(eml) {
+		b.log.Debug().Str("from", eml.From).Str("rcptTo", eml.RcptTo).Msg("rejection of automatic email is not sent")
+		return
+	}
+
+	domain := utils.Hostname(eml.RcptTo)
+	messageID := fmt.Sprintf("<reject.%s@%s>", newQueueID(eml.MessageID, eml.RcptTo), domain)
+	text := fmt.Sprintf("Your email to %s has been rejected: %s\n\nThe email has been delivered to the other recipients.", eml.RcptTo, reason)
+	reply := email.New(messageID, eml.MessageID, eml.MessageID, "Rejected: "+eml.Subject, eml.RcptTo, eml.EnvelopeFrom, eml.EnvelopeFrom, "", text, "", nil, nil)
+	reply.AutoSubmitted = "auto-replied"
+	if _, err := b.trySendmail(newQueueID(messageID, eml.EnvelopeFrom), eml.RcptTo, eml.EnvelopeFrom, reply.Compose(b.cfg.GetBot().DKIMPrivateKey())); err != nil {
+		b.log.Error().Err(err).Str("to", eml.EnvelopeFrom).Msg("cannot send rejection")
+	}
+}
+
+// sieveFileInto delivers a copy of the email into the folder:
+// mailbox name = room of that mailbox, INBOX = the room itself, anything else = dedicated thread within the room
+func (b *Bot) sieveFileInto(ctx context.Context, roomID id.RoomID, cfg config.Room, eml *email.Email, folder *sieve.Folder) {
+	copied := *eml
+	copied.Labels = folder.Flags
+	name := strings.ToLower(strings.TrimSpace(folder.Name))
+
+	targetID, ok := b.getMapping(utils.Mailbox(name))
+	switch {
+	case name == sieveFolderInbox || (ok && targetID == roomID):
+		if err := b.deliver(ctx, roomID, cfg, &copied, &deliveryOptions{}); err != nil {
+			b.Error(ctx, roomID, "cannot deliver email: %v", err)
+		}
+	case ok:
+		targetCfg, err := b.cfg.GetRoom(targetID)
+		if err != nil {
+			b.Error(ctx, targetID, "cannot get settings: %v", err)
+		}
+		if err := b.deliver(ctx, targetID, targetCfg, &copied, &deliveryOptions{}); err != nil {
+			b.Error(ctx, roomID, "cannot deliver email into %s: %v", name, err)
+		}
+	default:
+		if err := b.deliver(ctx, roomID, cfg, &copied, &deliveryOptions{threadKey: folderThreadKey(name)}); err != nil {
+			b.Error(ctx, roomID, "cannot deliver email into %s: %v", name, err)
+		}
+	}
+}
+
+// folderThreadKey returns thread mapping key of the sieve folder's dedicated thread
+func folderThreadKey(name string) string {
+	return "folder:" + name
+}
+
+// isRoomAddress checks if the address is the room's mailbox or alias on one of the postmoogle domains
+func (b *Bot) isRoomAddress(cfg config.Room, address string) bool {
+	address = strings.ToLower(email.Address(address))
+	if !b.isOwnDomain(utils.Hostname(address)) {
+		return false
+	}
+	mailbox := utils.Mailbox(address)
+	for _, item := range cfg.Mailboxes() {
+		if item == mailbox {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Bot) runSieve(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.sendSieve(ctx)
+		return
+	}
+	if len(commandSlice) == 2 && commandSlice[1] == "reset" {
+		if err := b.cfg.SetSieve(evt.RoomID, ""); err != nil {
+			b.Error(ctx, evt.RoomID, "cannot remove sieve script: %v", err)
+			return
+		}
+		b.SendNotice(ctx, evt.RoomID, "sieve script has been removed, kupo")
+		return
+	}
+
+	// get the script as is, without forced lower case and with new lines
+	body := evt.Content.AsMessage().Body
+	body = body[strings.Index(body, b.prefix)+len(b.prefix):]
+	body = strings.TrimSpace(body)
+	if !strings.HasPrefix(strings.ToLower(body), commandSieve) {
+		b.sendSieve(ctx)
+		return
+	}
+	b.setSieve(ctx, stripCodeBlock(body[len(commandSieve):]))
+}
+
+// stripCodeBlock returns contents of the markdown code block (optionally with language), or the text itself
+func stripCodeBlock(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		_, text, _ = strings.Cut(text, "\n")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+
+	return text
+}
+
+// onSieveFile sets sieve script from the uploaded file
+func (b *Bot) onSieveFile(ctx context.Context, content *event.MessageEventContent) {
+	evt := eventFromContext(ctx)
+	if !b.allowOwner(evt.Sender, evt.RoomID) {
+		b.SendNotice(ctx, evt.RoomID, "not allowed to do that, kupo")
+		return
+	}
+	data, err := b.downloadFile(content)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot download sieve script: %v", err)
+		return
+	}
+	b.setSieve(ctx, string(data))
+}
+
+func (b *Bot) setSieve(ctx context.Context, src string) {
+	evt := eventFromContext(ctx)
+	if _, err := sieve.Parse(src); err != nil {
+		b.SendError(ctx, evt.RoomID, "sieve script is invalid: "+err.Error())
+		return
+	}
+	if err := b.cfg.SetSieve(evt.RoomID, src); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot save sieve script: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, evt.RoomID, "sieve script has been saved, kupo")
+}
+
+func (b *Bot) sendSieve(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	var msg strings.Builder
+	src := b.cfg.GetSieve(evt.RoomID)
+	if src == "" {
+		msg.WriteString("Sieve script is not set so far, kupo.\n\n")
+	} else {
+		msg.WriteString("Current sieve script:\n```\n")
+		msg.WriteString(src)
+		msg.WriteString("\n```\n\n")
+	}
+	msg.WriteString("To set the script, upload a `.sieve` file or send `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" sieve SCRIPT`, to remove it: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" sieve reset`.\n")
+	msg.WriteString("Supported extensions: fileinto, reject, ereject, envelope, body, variables, imap4flags, vacation, copy. ")
+	msg.WriteString("`fileinto` accepts either a mailbox name (the email will be delivered to that mailbox's room), ")
+	msg.WriteString("`INBOX` (this room), or any other name (the email will be posted into a dedicated thread). ")
+	msg.WriteString("`redirect` forwards the original email the same way as the `forward` option does, ")
+	msg.WriteString("`vacation :from` accepts only the room's mailbox or its aliases. ")
+	msg.WriteString("Flags are shown as labels of the email.")
+
+	b.SendNotice(ctx, evt.RoomID, msg.String())
+}
+
+// downloadFile downloads (and decrypts, if needed) file from the message
+func (b *Bot) downloadFile(content *event.MessageEventContent) ([]byte, error) {
+	uri := content.URL
+	if content.File != nil {
+		uri = content.File.URL
+	}
+	mxc, err := uri.Parse()
+	if err != nil {
+		return nil, err
+	}
+	data, err := b.lp.GetClient().DownloadBytes(mxc)
+	if err != nil {
+		return nil, err
+	}
+	if content.File != nil {
+		if err := content.File.DecryptInPlace(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
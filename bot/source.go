@@ -0,0 +1,179 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// maxHeadersSize is max size of the headers sent as a message, bigger headers are sent as a file
+const maxHeadersSize = 32 * 1024
+
+// retainSource uploads the original email (as received, before milters) encrypted and saves the reference
+// with its decryption keys under its own key for the retention period of the room (as set at the delivery time).
+// The room account data is encrypted with the data secret, if set. When the retention period ends, the reference
+// and the keys are removed, so the uploaded file (matrix client API can't delete media) can't be decrypted anymore
+func (b *Bot) retainSource(roomID id.RoomID, eml *email.Email, eventID id.EventID, retention int) {
+	raw := eml.Original
+	if len(raw) == 0 {
+		raw = eml.Raw
+	}
+	if len(raw) == 0 {
+		return
+	}
+	file, err := b.uploadEncrypted(raw, "email.eml")
+	if err != nil {
+		b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot upload email source")
+		return
+	}
+	fileJSON, err := json.Marshal(file)
+	if err != nil {
+		b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot marshal email source")
+		return
+	}
+	now := time.Now().UTC()
+	data := map[string]string{"file": string(fileJSON), "time": strconv.FormatInt(now.Unix(), 10)}
+	if err := b.setExpiring(roomID, acSourcePrefix, eventID.String(), data, now.AddDate(0, 0, retention)); err != nil {
+		b.log.Error().Err(err).Str("roomID", roomID.String()).Msg("cannot save email source")
+	}
+}
+
+// getSource downloads and decrypts the retained source of the email event the command replies to (or of the thread's email)
+func (b *Bot) getSource(ctx context.Context) ([]byte, bool) {
+	evt := eventFromContext(ctx)
+	content := evt.Content.AsMessage()
+	eventID := content.RelatesTo.GetNonFallbackReplyTo()
+	if eventID == "" {
+		eventID = utils.EventParent("", content)
+	}
+	if eventID == "" {
+		b.SendNotice(ctx, evt.RoomID, "reply to an email to get its source or headers, kupo")
+		return nil, false
+	}
+
+	data, err := b.lp.GetRoomAccountData(evt.RoomID, acSourcePrefix+"."+eventID.String())
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot retrieve email source: %v", err)
+		return nil, false
+	}
+	if data["file"] == "" {
+		b.SendNotice(ctx, evt.RoomID, "source of that email is not retained (it's not an email, retention is disabled or the email is too old), kupo")
+		return nil, false
+	}
+
+	var file event.EncryptedFileInfo
+	if err := json.Unmarshal([]byte(data["file"]), &file); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot parse email source: %v", err)
+		return nil, false
+	}
+	uri, err := file.URL.Parse()
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot parse email source URL: %v", err)
+		return nil, false
+	}
+	raw, err := b.lp.GetClient().DownloadBytes(uri)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot download email source: %v", utils.UnwrapError(err))
+		return nil, false
+	}
+	if err := file.DecryptInPlace(raw); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot decrypt email source: %v", err)
+		return nil, false
+	}
+
+	return raw, true
+}
+
+func (b *Bot) runSource(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	raw, ok := b.getSource(ctx)
+	if !ok {
+		return
+	}
+
+	content, err := b.uploadFile(evt.RoomID, raw, "email.eml", "message/rfc822")
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot upload email source: %v", utils.UnwrapError(err))
+		return
+	}
+	b.sendSourceReply(ctx, content, evt)
+}
+
+func (b *Bot) runHeaders(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	raw, ok := b.getSource(ctx)
+	if !ok {
+		return
+	}
+	headers := rawHeaders(raw)
+
+	if len(headers) > maxHeadersSize {
+		content, err := b.uploadFile(evt.RoomID, headers, "headers.txt", "text/plain")
+		if err != nil {
+			b.Error(ctx, evt.RoomID, "cannot upload email headers: %v", utils.UnwrapError(err))
+			return
+		}
+		b.sendSourceReply(ctx, content, evt)
+		return
+	}
+
+	content := format.RenderMarkdown("```\n"+string(headers)+"\n```", true, false)
+	content.MsgType = event.MsgNotice
+	b.sendSourceReply(ctx, &content, evt)
+}
+
+// uploadFile uploads the data as a file, encrypted with new keys in encrypted rooms
+func (b *Bot) uploadFile(roomID id.RoomID, data []byte, name, mimetype string) (*event.MessageEventContent, error) {
+	content := &event.MessageEventContent{
+		MsgType: event.MsgFile,
+		Body:    name,
+		Info:    &event.FileInfo{MimeType: mimetype, Size: len(data)},
+	}
+	if !b.lp.GetMachine().StateStore.IsEncrypted(roomID) {
+		resp, err := b.lp.GetClient().UploadBytesWithName(data, mimetype, name)
+		if err != nil {
+			return nil, err
+		}
+		content.URL = resp.ContentURI.CUString()
+		return content, nil
+	}
+
+	file, err := b.uploadEncrypted(data, name)
+	if err != nil {
+		return nil, err
+	}
+	content.File = file
+	return content, nil
+}
+
+// sendSourceReply sends the content as a reply to the command (within its thread, if any)
+func (b *Bot) sendSourceReply(ctx context.Context, content *event.MessageEventContent, evt *event.Event) {
+	threadID := utils.EventParent("", evt.Content.AsMessage())
+	if threadID != "" {
+		content.RelatesTo = utils.RelatesTo(true, threadID)
+	}
+	if _, err := b.lp.Send(evt.RoomID, &event.Content{Parsed: content}); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot send email source: %v", utils.UnwrapError(err))
+	}
+}
+
+// rawHeaders returns the header section of the raw email
+func rawHeaders(raw []byte) []byte {
+	end := len(raw)
+	for _, separator := range [][]byte{[]byte("\r\n\r\n"), []byte("\n\n")} {
+		if idx := bytes.Index(raw, separator); idx != -1 && idx < end {
+			end = idx
+		}
+	}
+
+	return bytes.TrimSpace(raw[:end])
+}
@@ -0,0 +1,240 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// deliverSubscribers sends read-only copies of the email to the subscribers of the mailbox,
+// each subscriber room has its own threads
+func (b *Bot) deliverSubscribers(ctx context.Context, cfg config.Room, eml *email.Email) {
+	for _, subscriber := range cfg.Subscribers() {
+		roomID := id.RoomID(subscriber)
+		subCfg, err := b.cfg.GetRoom(roomID)
+		if err != nil {
+			b.log.Error().Err(err).Str("roomID", subscriber).Msg("cannot get subscriber settings")
+			continue
+		}
+		if err := b.deliver(ctx, roomID, subCfg, eml, &deliveryOptions{subscription: cfg.Mailbox()}); err != nil {
+			b.log.Error().Err(err).Str("roomID", subscriber).Str("mailbox", cfg.Mailbox()).Msg("cannot deliver email to the subscriber")
+		}
+	}
+}
+
+// runSubscribe requests read-only subscription of the current room to the mailbox
+func (b *Bot) runSubscribe(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Usage: `%s subscribe MAILBOX`", b.prefix))
+		return
+	}
+	mailbox := utils.Mailbox(commandSlice[1])
+	targetID, ok := b.getMapping(mailbox)
+	if !ok {
+		b.SendError(ctx, evt.RoomID, "mailbox does not exist, kupo.")
+		return
+	}
+	if targetID == evt.RoomID {
+		b.SendError(ctx, evt.RoomID, "the room cannot subscribe to its own mailbox, kupo.")
+		return
+	}
+	cfg, err := b.cfg.GetRoom(targetID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+	subscriber := evt.RoomID.String()
+	if utils.SliceContains(cfg.Subscribers(), subscriber) {
+		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("The room is already subscribed to the `%s` mailbox, kupo.", mailbox))
+		return
+	}
+
+	// owner of the mailbox doesn't need to approve own requests
+	if cfg.Owner() == evt.Sender.String() || b.allowAdmin(evt.Sender, targetID) {
+		cfg.Set(config.RoomSubscribersPending, strings.Join(utils.SliceRemove(cfg.SubscribersPending(), subscriber), ","))
+		cfg.Set(config.RoomSubscribers, strings.Join(utils.SliceAdd(cfg.Subscribers(), subscriber), ","))
+		if err := b.cfg.SetRoom(targetID, cfg); err != nil {
+			b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+			return
+		}
+		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("The room has been subscribed to the `%s` mailbox, it will receive read-only copies of all emails.", mailbox))
+		return
+	}
+
+	cfg.Set(config.RoomSubscribersPending, strings.Join(utils.SliceAdd(cfg.SubscribersPending(), subscriber), ","))
+	if err := b.cfg.SetRoom(targetID, cfg); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, targetID, fmt.Sprintf(
+		"%s requests read-only subscription of the %s room to the `%s` mailbox.\n\n"+
+			"To approve it, send `%s subscribers:approve %s`, to reject - `%s subscribers:remove %s`",
+		evt.Sender, subscriber, mailbox, b.prefix, subscriber, b.prefix, subscriber))
+	b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Subscription request has been sent, the `%s` mailbox owner should approve it, kupo.", mailbox))
+}
+
+// runUnsubscribe removes subscription (or pending request) of the current room to the mailbox
+func (b *Bot) runUnsubscribe(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("Usage: `%s unsubscribe MAILBOX`", b.prefix))
+		return
+	}
+	mailbox := utils.Mailbox(commandSlice[1])
+	targetID, ok := b.getMapping(mailbox)
+	if !ok {
+		b.SendError(ctx, evt.RoomID, "mailbox does not exist, kupo.")
+		return
+	}
+	cfg, err := b.cfg.GetRoom(targetID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+
+	subscriber := evt.RoomID.String()
+	cfg.Set(config.RoomSubscribers, strings.Join(utils.SliceRemove(cfg.Subscribers(), subscriber), ","))
+	cfg.Set(config.RoomSubscribersPending, strings.Join(utils.SliceRemove(cfg.SubscribersPending(), subscriber), ","))
+	if err := b.cfg.SetRoom(targetID, cfg); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("The room has been unsubscribed from the `%s` mailbox.", mailbox))
+}
+
+// runSubscribers shows subscribers of the room's mailbox
+func (b *Bot) runSubscribers(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+
+	var msg strings.Builder
+	subscribers := cfg.Subscribers()
+	if len(subscribers) == 0 {
+		msg.WriteString("The mailbox has no subscribers so far, kupo.\n\n")
+	} else {
+		msg.WriteString("The following rooms receive read-only copies of emails:\n")
+		for _, subscriber := range subscribers {
+			msg.WriteString("* ")
+			msg.WriteString(subscriber)
+			msg.WriteString("\n")
+		}
+		msg.WriteString("\n")
+	}
+	if pending := cfg.SubscribersPending(); len(pending) > 0 {
+		msg.WriteString("The following rooms wait for approval:\n")
+		for _, subscriber := range pending {
+			msg.WriteString("* ")
+			msg.WriteString(subscriber)
+			msg.WriteString("\n")
+		}
+		msg.WriteString("\n")
+	}
+	msg.WriteString("To subscribe another room, send `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" subscribe MAILBOX` in that room")
+
+	b.SendNotice(ctx, evt.RoomID, msg.String())
+}
+
+// runSubscribersApprove approves pending subscription request
+func (b *Bot) runSubscribersApprove(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	// get original values, without forced lower case
+	commandSlice := b.parseCommand(evt.Content.AsMessage().Body, false)
+	if len(commandSlice) < 2 {
+		b.runSubscribers(ctx)
+		return
+	}
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+	subscriber := commandSlice[1]
+	if !utils.SliceContains(cfg.SubscribersPending(), subscriber) {
+		b.SendError(ctx, evt.RoomID, "there is no subscription request from that room, kupo.")
+		return
+	}
+	joined, err := b.isJoined(id.RoomID(subscriber))
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "cannot check the subscriber room: %v", err)
+		return
+	}
+
+	cfg.Set(config.RoomSubscribersPending, strings.Join(utils.SliceRemove(cfg.SubscribersPending(), subscriber), ","))
+	if !joined {
+		if err := b.cfg.SetRoom(evt.RoomID, cfg); err != nil {
+			b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+			return
+		}
+		b.SendError(ctx, evt.RoomID, "the bot is not in that room anymore, the subscription request has been removed, kupo.")
+		return
+	}
+	cfg.Set(config.RoomSubscribers, strings.Join(utils.SliceAdd(cfg.Subscribers(), subscriber), ","))
+	if err := b.cfg.SetRoom(evt.RoomID, cfg); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, id.RoomID(subscriber), fmt.Sprintf("Subscription to the `%s` mailbox has been approved, the room will receive read-only copies of all emails.", cfg.Mailbox()))
+	b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("The %s room has been subscribed to the mailbox.", subscriber))
+}
+
+// isJoined checks if the bot is a member of the room
+func (b *Bot) isJoined(roomID id.RoomID) (bool, error) {
+	resp, err := b.lp.GetClient().JoinedRooms()
+	if err != nil {
+		return false, utils.UnwrapError(err)
+	}
+	for _, joinedID := range resp.JoinedRooms {
+		if joinedID == roomID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// runSubscribersRemove removes subscriber or rejects pending subscription request
+func (b *Bot) runSubscribersRemove(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	// get original values, without forced lower case
+	commandSlice := b.parseCommand(evt.Content.AsMessage().Body, false)
+	if len(commandSlice) < 2 {
+		b.runSubscribers(ctx)
+		return
+	}
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+	subscriber := commandSlice[1]
+	if !utils.SliceContains(cfg.Subscribers(), subscriber) && !utils.SliceContains(cfg.SubscribersPending(), subscriber) {
+		b.SendError(ctx, evt.RoomID, "that room is not subscribed to the mailbox, kupo.")
+		return
+	}
+
+	cfg.Set(config.RoomSubscribers, strings.Join(utils.SliceRemove(cfg.Subscribers(), subscriber), ","))
+	cfg.Set(config.RoomSubscribersPending, strings.Join(utils.SliceRemove(cfg.SubscribersPending(), subscriber), ","))
+	if err := b.cfg.SetRoom(evt.RoomID, cfg); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, id.RoomID(subscriber), fmt.Sprintf("Subscription to the `%s` mailbox has been removed by the mailbox owner.", cfg.Mailbox()))
+	b.SendNotice(ctx, evt.RoomID, fmt.Sprintf("The %s room has been unsubscribed from the mailbox.", subscriber))
+}
@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+)
+
+func (b *Bot) runTemplate(ctx context.Context, commandSlice []string) {
+	evt := eventFromContext(ctx)
+	if len(commandSlice) < 2 {
+		b.sendTemplate(ctx)
+		return
+	}
+
+	var key, name string
+	switch commandSlice[1] {
+	case "text":
+		key, name = config.RoomTemplate, "message template"
+	case "html":
+		key, name = config.RoomTemplateHTML, "HTML template"
+	case "reset":
+		b.setTemplates(ctx, map[string]string{config.RoomTemplate: "", config.RoomTemplateHTML: ""}, "message templates have been removed, kupo")
+		return
+	default:
+		b.sendTemplate(ctx)
+		return
+	}
+
+	src := stripCodeBlock(b.rawArgument(ctx, commandTemplate))
+	if src == "" || src == "reset" {
+		b.setTemplates(ctx, map[string]string{key: ""}, name+" has been removed, kupo")
+		return
+	}
+	if err := email.ParseTemplate(src, key == config.RoomTemplateHTML); err != nil {
+		b.SendError(ctx, evt.RoomID, name+" is invalid: "+err.Error())
+		return
+	}
+	b.setTemplates(ctx, map[string]string{key: src}, name+" has been saved, kupo")
+}
+
+func (b *Bot) setTemplates(ctx context.Context, templates map[string]string, msg string) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+	for key, value := range templates {
+		cfg.Set(key, value)
+	}
+	if err := b.cfg.SetRoom(evt.RoomID, cfg); err != nil {
+		b.Error(ctx, evt.RoomID, "cannot update settings: %v", err)
+		return
+	}
+
+	b.SendNotice(ctx, evt.RoomID, msg)
+}
+
+func (b *Bot) sendTemplate(ctx context.Context) {
+	evt := eventFromContext(ctx)
+	cfg, err := b.cfg.GetRoom(evt.RoomID)
+	if err != nil {
+		b.Error(ctx, evt.RoomID, "failed to retrieve settings: %v", err)
+		return
+	}
+
+	var msg strings.Builder
+	if tpl := cfg.Template(); tpl != "" {
+		msg.WriteString("Current message template:\n```\n")
+		msg.WriteString(tpl)
+		msg.WriteString("\n```\n\n")
+	} else {
+		msg.WriteString("Message template is not set, the default layout is used, kupo. It looks like this:\n```\n")
+		msg.WriteString(email.ExampleTemplate)
+		msg.WriteString("\n```\n\n")
+	}
+	if tpl := cfg.TemplateHTML(); tpl != "" {
+		msg.WriteString("Current HTML template:\n```\n")
+		msg.WriteString(tpl)
+		msg.WriteString("\n```\n\n")
+	}
+
+	msg.WriteString("To set the message template (markdown), send `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" template text TEMPLATE`, for HTML: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" template html TEMPLATE`, to remove them: `")
+	msg.WriteString(b.prefix)
+	msg.WriteString(" template reset`.\n")
+	msg.WriteString("Templates use Go `text/template` syntax (`html/template` for HTML, the output is sanitized) with the following fields: ")
+	msg.WriteString("`.From`, `.To`, `.CC`, `.RcptTo`, `.Subject`, `.Date`, `.MessageID`, `.InReplyTo`, `.References`, ")
+	msg.WriteString("`.Text`, `.HTML`, `.Body` (markdown), `.Tag`, `.Labels`, `.Quarantine`, `.Threaded`, `.Attachments`, ")
+	msg.WriteString("`.AuthenticationResults`, `.Header \"Name\"`, and functions: `join`, `lower`, `upper`, `trim`, `html` (text only), `unescaped` (HTML only, e.g. `{{unescaped .HTML}}`).")
+
+	b.SendNotice(ctx, evt.RoomID, msg.String())
+}
@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// autoreply is an automatic response to the sender of incoming email
+type autoreply struct {
+	// From address, mailbox of the room by default
+	From string
+	// Subject, "Auto: " + original subject by default
+	Subject string
+	// Body of the response
+	Body string
+	// Days within which the same sender doesn't receive the response again
+	Days int
+	// Handle distinguishes different responses to the same sender
+	Handle string
+	// Addresses of the recipient in addition to the room mailboxes
+	Addresses []string
+}
+
+// sendAutoreply sends automatic response (RFC 3834 / RFC 5230) to the sender of the email,
+// unless the email is automatic itself, comes from a mailing list or was not addressed to the mailbox directly.
+// Returns true if the response has been sent
+func (b *Bot) sendAutoreply(roomID id.RoomID, cfg config.Room, eml *email.Email, reply *autoreply) bool {
+	sender := strings.ToLower(email.Address(eml.EnvelopeFrom))
+	if reason := b.skipAutoreply(cfg, eml, sender, reply.Addresses); reason != "" {
+		b.log.Debug().Str("sender", sender).Str("reason", reason).Msg("autoreply skipped")
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(reply.Handle + "|" + sender))
+	suffix := hex.EncodeToString(hash[:16])
+	key := acAutoreplyPrefix + "." + suffix
+	data, err := b.lp.GetRoomAccountData(roomID, key)
+	if err != nil {
+		b.log.Error().Err(err).Str("key", key).Msg("cannot retrieve autoreply timestamp")
+	}
+	if until := utils.Int64(data["until"]); until > time.Now().Unix() {
+		b.log.Debug().Str("sender", sender).Msg("autoreply has already been sent recently")
+		return false
+	}
+
+	from := reply.From
+	if from == "" {
+		from = cfg.Mailbox() + "@" + utils.SanitizeDomain(eml.Domain(true))
+	}
+	subject := reply.Subject
+	if subject == "" {
+		subject = "Auto: " + eml.Subject
+	}
+	messageID := fmt.Sprintf("<autoreply.%s.%d@%s>", hex.EncodeToString(hash[:8]), time.Now().UnixNano(), utils.Hostname(from))
+	references := strings.TrimSpace(eml.References + " " + eml.MessageID)
+	response := email.New(messageID, eml.MessageID, references, subject, from, sender, sender, "", reply.Body, "", nil, nil)
+	response.AutoSubmitted = "auto-replied"
+	// sent without VERP, because the autoreply is not related to any event
+	if _, err := b.trySendmail(newQueueID(messageID, sender), from, sender, response.Compose(b.cfg.GetBot().DKIMPrivateKey())); err != nil {
+		b.log.Error().Err(err).Str("sender", sender).Msg("cannot send autoreply")
+		return false
+	}
+
+	days := reply.Days
+	if days < 1 {
+		days = 1
+	}
+	until := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	if err := b.setExpiring(roomID, acAutoreplyPrefix, suffix, map[string]string{"until": strconv.FormatInt(until.Unix(), 10)}, until); err != nil {
+		b.log.Error().Err(err).Str("key", key).Msg("cannot save autoreply timestamp")
+	}
+	b.log.Info().Str("sender", sender).Str("roomID", roomID.String()).Msg("autoreply has been sent")
+	return true
+}
+
+// skipAutoreply returns reason why the autoreply should not be sent, empty string means it should be
+func (b *Bot) skipAutoreply(cfg config.Room, eml *email.Email, sender string, addresses []string) string {
+	if sender == "" || !strings.Contains(sender, "@") {
+		return "no sender"
+	}
+	localpart := utils.Mailbox(sender)
+	if localpart == "mailer-daemon" || localpart == "postmaster" || strings.HasPrefix(localpart, "owner-") || strings.HasSuffix(localpart, "-request") {
+		return "system sender"
+	}
+	for _, mailbox := range cfg.Mailboxes() {
+		for _, domain := range b.domains {
+			if sender == mailbox+"@"+domain {
+				return "own mailbox"
+			}
+			addresses = append(addresses, mailbox+"@"+domain)
+		}
+	}
+	if eml.Headers != nil {
+		if isAutomatic(eml) {
+			return "automatic or bulk email"
+		}
+		if eml.Headers.Get("List-Id") != "" || eml.Headers.Get("List-Unsubscribe") != "" {
+			return "mailing list"
+		}
+	}
+
+	recipients := recipientAddresses(eml)
+	for _, address := range addresses {
+		if recipients[strings.ToLower(strings.TrimSpace(address))] {
+			return ""
+		}
+	}
+	return "not addressed directly"
+}
+
+// recipientAddresses returns lowercased addresses of the email's To and Cc recipients
+func recipientAddresses(eml *email.Email) map[string]bool {
+	values := append([]string{eml.To}, eml.CC...)
+	if eml.Headers != nil {
+		values = append(values, eml.Headers.Values("To")...)
+		values = append(values, eml.Headers.Values("Cc")...)
+	}
+	addresses := map[string]bool{}
+	for _, value := range values {
+		for _, address := range email.AddressList(value) {
+			addresses[strings.ToLower(address)] = true
+		}
+	}
+
+	return addresses
+}
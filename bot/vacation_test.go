@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"net/textproto"
+	"testing"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+)
+
+func TestSkipAutoreply(t *testing.T) {
+	b := &Bot{domains: []string{"example.com"}}
+	cfg := config.Room{config.RoomMailbox: "bob", config.RoomAliases: "robert"}
+	tests := []struct {
+		name      string
+		sender    string
+		to        string
+		cc        []string
+		headers   textproto.MIMEHeader
+		addresses []string
+		reason    string
+	}{
+		{name: "addressed directly", sender: "alice@example.org", to: "Bob <bob@example.com>", reason: ""},
+		{name: "addressed to alias", sender: "alice@example.org", to: "carol@example.org, ROBERT@example.com", reason: ""},
+		{name: "addressed in cc", sender: "alice@example.org", to: "carol@example.org", cc: []string{"bob@example.com"}, reason: ""},
+		{name: "addressed in cc header", sender: "alice@example.org", to: "carol@example.org", headers: textproto.MIMEHeader{"Cc": {"Bob <bob@example.com>"}}, reason: ""},
+		{name: "additional address", sender: "alice@example.org", to: "bob@example.net", addresses: []string{"bob@example.net"}, reason: ""},
+		{name: "address suffix", sender: "alice@example.org", to: "jimbob@example.com", reason: "not addressed directly"},
+		{name: "address prefix", sender: "alice@example.org", to: "bob@example.com.evil", reason: "not addressed directly"},
+		{name: "bcc", sender: "alice@example.org", to: "carol@example.org", reason: "not addressed directly"},
+		{name: "no sender", sender: "", to: "bob@example.com", reason: "no sender"},
+		{name: "system sender", sender: "mailer-daemon@example.org", to: "bob@example.com", reason: "system sender"},
+		{name: "list owner", sender: "owner-list@example.org", to: "bob@example.com", reason: "system sender"},
+		{name: "own mailbox", sender: "bob@example.com", to: "bob@example.com", reason: "own mailbox"},
+		{name: "automatic", sender: "alice@example.org", to: "bob@example.com", headers: textproto.MIMEHeader{"Auto-Submitted": {"auto-replied"}}, reason: "automatic or bulk email"},
+		{name: "mailing list", sender: "alice@example.org", to: "bob@example.com", headers: textproto.MIMEHeader{"List-Id": {"<list.example.org>"}}, reason: "mailing list"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			eml := &email.Email{To: test.to, CC: test.cc, Headers: test.headers}
+			if reason := b.skipAutoreply(cfg, eml, test.sender, test.addresses); reason != test.reason {
+				t.Errorf("skipAutoreply() = %q, want %q", reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestIsAutomatic(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   textproto.MIMEHeader
+		automatic bool
+	}{
+		{name: "no headers", headers: nil, automatic: false},
+		{name: "regular email", headers: textproto.MIMEHeader{"Subject": {"hi"}}, automatic: false},
+		{name: "auto-submitted no", headers: textproto.MIMEHeader{"Auto-Submitted": {"No"}}, automatic: false},
+		{name: "auto-replied", headers: textproto.MIMEHeader{"Auto-Submitted": {"auto-replied"}}, automatic: true},
+		{name: "auto-generated", headers: textproto.MIMEHeader{"Auto-Submitted": {"auto-generated"}}, automatic: true},
+		{name: "bulk", headers: textproto.MIMEHeader{"Precedence": {"Bulk"}}, automatic: true},
+		{name: "list", headers: textproto.MIMEHeader{"Precedence": {"list"}}, automatic: true},
+		{name: "junk", headers: textproto.MIMEHeader{"Precedence": {"junk"}}, automatic: true},
+		{name: "first-class", headers: textproto.MIMEHeader{"Precedence": {"first-class"}}, automatic: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if automatic := isAutomatic(&email.Email{Headers: test.headers}); automatic != test.automatic {
+				t.Errorf("isAutomatic() = %t, want %t", automatic, test.automatic)
+			}
+		})
+	}
+}
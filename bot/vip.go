@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+)
+
+const (
+	// vipMentionRoom mentions the whole room
+	vipMentionRoom = "@room"
+	// vipCacheSize is max count of rooms with cached compiled VIP sender patterns
+	vipCacheSize = 1000
+)
+
+// vipPatterns are compiled VIP sender patterns of the room's vip option value
+type vipPatterns struct {
+	value    string
+	patterns []*regexp.Regexp
+}
+
+func newVIPCache() *lru.Cache[id.RoomID, vipPatterns] {
+	cache, _ := lru.New[id.RoomID, vipPatterns](vipCacheSize) //nolint:errcheck // the size is positive
+	return cache
+}
+
+// applyVIP adds mentions of the room's VIP mentions (room owner by default) to the emails from VIP senders and urgent emails
+func (b *Bot) applyVIP(roomID id.RoomID, cfg config.Room, eml *email.Email, opts *deliveryOptions) {
+	if !b.isVIP(roomID, cfg, eml) {
+		return
+	}
+	b.log.Debug().Str("from", eml.From).Msg("email is VIP")
+
+	mentions := cfg.VIPMentions()
+	if len(mentions) == 0 && cfg.Owner() != "" {
+		mentions = []string{cfg.Owner()}
+	}
+	for _, mention := range mentions {
+		mention = strings.TrimSpace(mention)
+		if mention == vipMentionRoom {
+			opts.mentionRoom = true
+			continue
+		}
+		userID := id.UserID(mention)
+		if _, _, err := userID.Parse(); err != nil {
+			b.log.Warn().Err(err).Str("mention", mention).Msg("invalid VIP mention")
+			continue
+		}
+		if !hasUserID(opts.mentions, userID) {
+			opts.mentions = append(opts.mentions, userID)
+		}
+	}
+}
+
+// isVIP checks if the email is sent by VIP sender of the room or is urgent (if priority detection is enabled)
+func (b *Bot) isVIP(roomID id.RoomID, cfg config.Room, eml *email.Email) bool {
+	if cfg.VIPPriority() && eml.Urgent() {
+		return true
+	}
+	sender := email.Address(eml.From)
+	for _, re := range b.getVIPPatterns(roomID, cfg.Get(config.RoomVIP)) {
+		if re.MatchString(sender) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getVIPPatterns returns cached compiled VIP sender patterns of the room,
+// the cached patterns are replaced when the vip option value has been changed
+func (b *Bot) getVIPPatterns(roomID id.RoomID, value string) []*regexp.Regexp {
+	if cached, ok := b.vip.Get(roomID); ok && cached.value == value {
+		return cached.patterns
+	}
+
+	patterns := config.CompilePatterns(value)
+	b.vip.Add(roomID, vipPatterns{value: value, patterns: patterns})
+	return patterns
+}
+
+func hasUserID(list []id.UserID, userID id.UserID) bool {
+	for _, item := range list {
+		if item == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"net/textproto"
+	"testing"
+
+	"maunium.net/go/mautrix/id"
+
+	"gitlab.com/etke.cc/postmoogle/bot/config"
+	"gitlab.com/etke.cc/postmoogle/email"
+)
+
+func TestIsVIP(t *testing.T) {
+	b := &Bot{vip: newVIPCache()}
+	roomID := id.RoomID("!room:example.com")
+	cfg := config.Room{config.RoomVIP: "*@BigCustomer.com,/^x{2,3}@example\\.com$/,/(/"}
+	tests := []struct {
+		from string
+		vip  bool
+	}{
+		{from: "ceo@bigcustomer.com", vip: true},
+		{from: "CEO <ceo@bigcustomer.com>", vip: true},
+		{from: "xx@example.com", vip: true},
+		{from: "xxxx@example.com", vip: false},
+		{from: "someone@example.com", vip: false},
+	}
+	for _, test := range tests {
+		if vip := b.isVIP(roomID, cfg, &email.Email{From: test.from}); vip != test.vip {
+			t.Errorf("isVIP() of %q = %t, want %t", test.from, vip, test.vip)
+		}
+	}
+	if cached, ok := b.vip.Get(roomID); !ok || b.vip.Len() != 1 || len(cached.patterns) != 2 {
+		t.Errorf("VIP cache = %v, want 2 valid patterns", cached)
+	}
+
+	urgent := &email.Email{From: "someone@example.com", Headers: textproto.MIMEHeader{"X-Priority": {"1"}}}
+	if b.isVIP(roomID, cfg, urgent) {
+		t.Error("isVIP() of urgent email = true, want false without vip:priority")
+	}
+	cfg.Set(config.RoomVIPPriority, "true")
+	if !b.isVIP(roomID, cfg, urgent) {
+		t.Error("isVIP() of urgent email = false, want true with vip:priority")
+	}
+}
+
+func TestIsVIPChanged(t *testing.T) {
+	b := &Bot{vip: newVIPCache()}
+	roomID := id.RoomID("!room:example.com")
+	eml := &email.Email{From: "ceo@bigcustomer.com"}
+	if !b.isVIP(roomID, config.Room{config.RoomVIP: "*@bigcustomer.com"}, eml) {
+		t.Error("isVIP() = false, want true")
+	}
+	if b.isVIP(roomID, config.Room{config.RoomVIP: "*@example.com"}, eml) {
+		t.Error("isVIP() after the change = true, want false")
+	}
+	if cached, ok := b.vip.Get(roomID); !ok || b.vip.Len() != 1 || cached.value != "*@example.com" {
+		t.Errorf("VIP cache = %v, want the changed value only", cached)
+	}
+}
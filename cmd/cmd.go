@@ -43,6 +43,10 @@ func main() {
 	initLog(cfg)
 	utils.SetLogger(&log)
 	utils.SetDomains(cfg.Domains)
+	if err := utils.SetSubaddressSeparator(cfg.Mailboxes.Separator); err != nil {
+		// nolint // Fatal = panic, not os.Exit()
+		log.Fatal().Err(err).Msg("invalid mailboxes configuration")
+	}
 
 	log.Info().Msg("#############################")
 	log.Info().Msg("Postmoogle")
@@ -125,6 +129,16 @@ func initMatrix(cfg *config.Config) {
 }
 
 func initSMTP(cfg *config.Config) {
+	helo := &smtp.HeloConfig{
+		FQDN:    cfg.Helo.FQDN,
+		Domains: cfg.Helo.Domains,
+		FCrDNS:  cfg.Helo.FCrDNS,
+	}
+	if err := helo.Validate(); err != nil {
+		// nolint // Fatal = panic, not os.Exit()
+		log.Fatal().Err(err).Msg("invalid HELO checks configuration")
+	}
+
 	smtpm = smtp.NewManager(&smtp.Config{
 		Domains:     cfg.Domains,
 		Port:        cfg.Port,
@@ -142,6 +156,11 @@ func initSMTP(cfg *config.Config) {
 			Usename:  cfg.Relay.Username,
 			Password: cfg.Relay.Password,
 		},
+		Milters: &smtp.MilterConfig{
+			Addresses:     cfg.Milters.Addresses,
+			DefaultAction: cfg.Milters.DefaultAction,
+		},
+		Helo: helo,
 	})
 }
 
@@ -157,6 +176,26 @@ func initCron() {
 	if err != nil {
 		log.Error().Err(err).Msg("cannot start sync rooms cronjob")
 	}
+
+	err = cron.AddJob("0 * * * *", mxb.PruneGreylist)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot start greylist pruning cronjob")
+	}
+
+	err = cron.AddJob("30 * * * *", mxb.PruneBanlist)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot start banlist pruning cronjob")
+	}
+
+	err = cron.AddJob("0 * * * *", mxb.SendDigests)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot start digests cronjob")
+	}
+
+	err = cron.AddJob("45 * * * *", mxb.PruneExpiring)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot start expired keys pruning cronjob")
+	}
 }
 
 func initShutdown(quit chan struct{}) {
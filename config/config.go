@@ -28,6 +28,7 @@ func New() *Config {
 		Mailboxes: Mailboxes{
 			Reserved:   env.Slice("mailboxes.reserved"),
 			Activation: env.String("mailboxes.activation", defaultConfig.Mailboxes.Activation),
+			Separator:  env.String("mailboxes.separator", defaultConfig.Mailboxes.Separator),
 		},
 		TLS: TLS{
 			Certs:    env.Slice("tls.cert"),
@@ -52,6 +53,15 @@ func New() *Config {
 			Username: env.String("relay.username", defaultConfig.Relay.Username),
 			Password: env.String("relay.password", defaultConfig.Relay.Password),
 		},
+		Milters: Milters{
+			Addresses:     env.Slice("milters.addresses"),
+			DefaultAction: env.String("milters.defaultaction", defaultConfig.Milters.DefaultAction),
+		},
+		Helo: Helo{
+			FQDN:    env.String("helo.fqdn", defaultConfig.Helo.FQDN),
+			Domains: env.String("helo.domains", defaultConfig.Helo.Domains),
+			FCrDNS:  env.String("helo.fcrdns", defaultConfig.Helo.FCrDNS),
+		},
 	}
 
 	return cfg
@@ -9,6 +9,7 @@ var defaultConfig = &Config{
 	StatusMsg: "Delivering emails",
 	Mailboxes: Mailboxes{
 		Activation: "none",
+		Separator:  "+",
 	},
 	DB: DB{
 		DSN:     "local.db",
@@ -21,4 +22,7 @@ var defaultConfig = &Config{
 	TLS: TLS{
 		Port: "587",
 	},
+	Milters: Milters{
+		DefaultAction: "tempfail",
+	},
 }
@@ -43,6 +43,12 @@ type Config struct {
 	Monitoring Monitoring
 
 	Relay Relay
+
+	// Milters config
+	Milters Milters
+
+	// Helo checks config
+	Helo Helo
 }
 
 // DB config
@@ -73,6 +79,26 @@ type Monitoring struct {
 type Mailboxes struct {
 	Reserved   []string
 	Activation string
+	// Separator of subaddress (tag), e.g. + for mailbox+tag@example.com
+	Separator string
+}
+
+// Milters config
+type Milters struct {
+	// Addresses of milters, e.g.: inet:127.0.0.1:11332, unix:/run/opendmarc.sock
+	Addresses []string
+	// DefaultAction when milter is not available: accept, reject, tempfail
+	DefaultAction string
+}
+
+// Helo checks config, each option is an action on failed check: reject, greylist, score or empty (disabled)
+type Helo struct {
+	// FQDN - HELO must be a valid FQDN
+	FQDN string
+	// Domains - HELO must not claim one of our domains
+	Domains string
+	// FCrDNS - client IP must have forward-confirmed reverse DNS
+	FCrDNS string
 }
 
 // Relay config
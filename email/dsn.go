@@ -0,0 +1,142 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+)
+
+// DSN is a delivery status notification (RFC 3464)
+type DSN struct {
+	// MessageID of the original email
+	MessageID string
+	// Original headers of the email, if included into the report
+	Original textproto.MIMEHeader
+	// Recipients with delivery status
+	Recipients []*DSNRecipient
+	// Report is the raw delivery status report
+	Report []byte
+}
+
+// DSNRecipient is a per-recipient part of delivery status notification
+type DSNRecipient struct {
+	// Recipient address
+	Recipient string
+	// Action: failed, delayed, delivered, relayed, expanded
+	Action string
+	// Status code, e.g. 5.1.1
+	Status string
+	// Diagnostic code, e.g. 550 5.1.1 user unknown
+	Diagnostic string
+}
+
+// Failed returns true if the delivery failed
+func (r *DSNRecipient) Failed() bool {
+	return r.Action == "failed"
+}
+
+// Reason returns the most detailed failure reason available
+func (r *DSNRecipient) Reason() string {
+	if r.Diagnostic != "" {
+		return r.Diagnostic
+	}
+
+	return r.Status
+}
+
+// ParseDSN parses delivery status notification from the envelope, returns nil if the email is not a DSN
+func ParseDSN(envelope *enmime.Envelope) *DSN {
+	if envelope.Root == nil || envelope.Root.ContentType != "multipart/report" {
+		return nil
+	}
+	status := envelope.Root.BreadthMatchFirst(func(p *enmime.Part) bool {
+		return p.ContentType == "message/delivery-status" || p.ContentType == "message/global-delivery-status"
+	})
+	if status == nil {
+		return nil
+	}
+
+	dsn := &DSN{
+		Report:     status.Content,
+		Recipients: parseDSNRecipients(status.Content),
+	}
+	original := envelope.Root.BreadthMatchFirst(func(p *enmime.Part) bool {
+		return p.ContentType == "message/rfc822" || p.ContentType == "text/rfc822-headers" || p.ContentType == "message/global"
+	})
+	if original != nil {
+		dsn.Original = parseHeaders(original.Content)
+		dsn.MessageID = strings.TrimSpace(dsn.Original.Get("Message-Id"))
+	}
+
+	return dsn
+}
+
+// parseDSNRecipients parses per-recipient fields of the report.
+// The report consists of header-like blocks separated by empty lines, the first block is per-message fields
+func parseDSNRecipients(report []byte) []*DSNRecipient {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(report)))
+	recipients := []*DSNRecipient{}
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if len(fields) > 0 && (fields.Get("Final-Recipient") != "" || fields.Get("Original-Recipient") != "") {
+			recipients = append(recipients, &DSNRecipient{
+				Recipient:  dsnRecipient(fields),
+				Action:     strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+				Status:     strings.TrimSpace(fields.Get("Status")),
+				Diagnostic: dsnValue(fields.Get("Diagnostic-Code")),
+			})
+		}
+		if err != nil {
+			return recipients
+		}
+	}
+}
+
+// dsnRecipient returns original recipient address if available, otherwise final one
+func dsnRecipient(fields textproto.MIMEHeader) string {
+	if original := dsnValue(fields.Get("Original-Recipient")); original != "" {
+		return original
+	}
+
+	return dsnValue(fields.Get("Final-Recipient"))
+}
+
+// dsnValue strips type prefix of the field value, e.g. "rfc822; user@example.com" -> "user@example.com"
+func dsnValue(value string) string {
+	_, value, ok := strings.Cut(value, ";")
+	if !ok {
+		return ""
+	}
+
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// OriginalAutomatic returns true if the original email was automatic itself (auto-submitted, DSN or bounce),
+// such reports should not be relayed further to avoid bounce loops
+func (d *DSN) OriginalAutomatic() bool {
+	if d.Original == nil {
+		return false
+	}
+	if auto := strings.ToLower(strings.TrimSpace(d.Original.Get("Auto-Submitted"))); auto != "" && auto != "no" {
+		return true
+	}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(d.Original.Get("Content-Type"))), "multipart/report") {
+		return true
+	}
+
+	return strings.TrimSpace(d.Original.Get("Return-Path")) == "<>"
+}
+
+// parseHeaders returns headers of the (original) email
+func parseHeaders(data []byte) textproto.MIMEHeader {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	headers, _ := reader.ReadMIMEHeader() //nolint:errcheck // partial headers are fine
+	if headers == nil {
+		headers = textproto.MIMEHeader{}
+	}
+
+	return headers
+}
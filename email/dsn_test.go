@@ -0,0 +1,200 @@
+package email
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime"
+)
+
+const testDSN = `From: MAILER-DAEMON@mx.example.org
+To: sender@example.com
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="BOUNDARY"
+
+--BOUNDARY
+Content-Type: text/plain
+
+This is the mail system at host mx.example.org.
+
+--BOUNDARY
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.org
+Arrival-Date: Mon, 19 Oct 2026 10:00:00 +0000
+
+Final-Recipient: rfc822; alias@example.org
+Original-Recipient: rfc822;user@example.org
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 <user@example.org>:
+ Recipient address rejected: User unknown
+
+Final-Recipient: rfc822; other@example.org
+Action: Delayed
+Status: 4.4.1
+
+--BOUNDARY
+Content-Type: text/rfc822-headers
+
+From: sender@example.com
+To: user@example.org
+Message-Id: <original@example.com>
+Subject: hello
+
+--BOUNDARY--
+`
+
+func TestParseDSN(t *testing.T) {
+	envelope, err := enmime.ReadEnvelope(strings.NewReader(strings.ReplaceAll(testDSN, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("cannot read envelope: %v", err)
+	}
+
+	dsn := ParseDSN(envelope)
+	if dsn == nil {
+		t.Fatal("ParseDSN() = nil, want DSN")
+	}
+	if dsn.MessageID != "<original@example.com>" {
+		t.Errorf("MessageID = %q, want %q", dsn.MessageID, "<original@example.com>")
+	}
+	if !strings.Contains(string(dsn.Report), "Reporting-MTA: dns; mx.example.org") {
+		t.Errorf("Report = %q, want the delivery status part", dsn.Report)
+	}
+
+	expected := []*DSNRecipient{
+		{
+			Recipient:  "user@example.org",
+			Action:     "failed",
+			Status:     "5.1.1",
+			Diagnostic: "550 5.1.1 <user@example.org>: Recipient address rejected: User unknown",
+		},
+		{
+			Recipient: "other@example.org",
+			Action:    "delayed",
+			Status:    "4.4.1",
+		},
+	}
+	if !reflect.DeepEqual(dsn.Recipients, expected) {
+		t.Errorf("Recipients = %+v, want %+v", dsn.Recipients, expected)
+	}
+}
+
+func TestParseDSNNotReport(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "plain email",
+			data: "From: sender@example.com\r\nSubject: hello\r\n\r\nbody\r\n",
+		},
+		{
+			name: "report without delivery status",
+			data: "Content-Type: multipart/report; report-type=disposition-notification; boundary=\"B\"\r\n\r\n" +
+				"--B\r\nContent-Type: text/plain\r\n\r\nread\r\n" +
+				"--B\r\nContent-Type: message/disposition-notification\r\n\r\nDisposition: manual-action/MDN-sent-manually; displayed\r\n" +
+				"--B--\r\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			envelope, err := enmime.ReadEnvelope(strings.NewReader(test.data))
+			if err != nil {
+				t.Fatalf("cannot read envelope: %v", err)
+			}
+			if dsn := ParseDSN(envelope); dsn != nil {
+				t.Errorf("ParseDSN() = %+v, want nil", dsn)
+			}
+		})
+	}
+}
+
+func TestParseDSNRecipients(t *testing.T) {
+	tests := []struct {
+		name     string
+		report   string
+		expected []*DSNRecipient
+	}{
+		{
+			name:     "per-message fields only",
+			report:   "Reporting-MTA: dns; mx.example.org\r\n",
+			expected: []*DSNRecipient{},
+		},
+		{
+			name:   "final recipient without diagnostic",
+			report: "Reporting-MTA: dns; mx.example.org\r\n\r\nFinal-Recipient: rfc822; user@example.org\r\nAction: delivered\r\nStatus: 2.0.0\r\n",
+			expected: []*DSNRecipient{
+				{Recipient: "user@example.org", Action: "delivered", Status: "2.0.0"},
+			},
+		},
+		{
+			name:   "original recipient only",
+			report: "Reporting-MTA: dns; mx.example.org\r\n\r\nOriginal-Recipient: rfc822; user@example.org\r\nAction: FAILED\r\nStatus: 5.0.0\r\n",
+			expected: []*DSNRecipient{
+				{Recipient: "user@example.org", Action: "failed", Status: "5.0.0"},
+			},
+		},
+		{
+			name:   "recipient without type",
+			report: "Reporting-MTA: dns; mx.example.org\r\n\r\nFinal-Recipient: user@example.org\r\nAction: failed\r\nStatus: 5.0.0\r\n",
+			expected: []*DSNRecipient{
+				{Recipient: "", Action: "failed", Status: "5.0.0"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if recipients := parseDSNRecipients([]byte(test.report)); !reflect.DeepEqual(recipients, test.expected) {
+				t.Errorf("parseDSNRecipients() = %+v, want %+v", recipients, test.expected)
+			}
+		})
+	}
+}
+
+func TestDSNRecipient(t *testing.T) {
+	failed := &DSNRecipient{Action: "failed", Status: "5.1.1", Diagnostic: "550 user unknown"}
+	if !failed.Failed() {
+		t.Error("Failed() = false, want true")
+	}
+	if reason := failed.Reason(); reason != "550 user unknown" {
+		t.Errorf("Reason() = %q, want the diagnostic", reason)
+	}
+
+	delayed := &DSNRecipient{Action: "delayed", Status: "4.4.1"}
+	if delayed.Failed() {
+		t.Error("Failed() = true, want false")
+	}
+	if reason := delayed.Reason(); reason != "4.4.1" {
+		t.Errorf("Reason() = %q, want the status", reason)
+	}
+}
+
+func TestDSNOriginalAutomatic(t *testing.T) {
+	tests := []struct {
+		name      string
+		original  string
+		automatic bool
+	}{
+		{name: "regular email", original: "From: a@example.com\r\nSubject: hi\r\n", automatic: false},
+		{name: "auto-submitted no", original: "Auto-Submitted: no\r\n", automatic: false},
+		{name: "auto-replied", original: "Auto-Submitted: auto-replied\r\n", automatic: true},
+		{name: "auto-generated", original: "Auto-Submitted: Auto-Generated\r\n", automatic: true},
+		{name: "report", original: "Content-Type: multipart/report; report-type=delivery-status\r\n", automatic: true},
+		{name: "null sender", original: "Return-Path: <>\r\n", automatic: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dsn := &DSN{Original: parseHeaders([]byte(test.original + "\r\n"))}
+			if automatic := dsn.OriginalAutomatic(); automatic != test.automatic {
+				t.Errorf("OriginalAutomatic() = %t, want %t", automatic, test.automatic)
+			}
+		})
+	}
+
+	if (&DSN{}).OriginalAutomatic() {
+		t.Error("OriginalAutomatic() = true, want false without the original headers")
+	}
+}
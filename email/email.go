@@ -4,10 +4,13 @@ import (
 	"crypto"
 	"crypto/x509"
 	"encoding/pem"
+	"html"
+	"net/textproto"
 	"strings"
 
 	"github.com/emersion/go-msgauth/dkim"
 	"github.com/jhillyerd/enmime"
+	"github.com/yuin/goldmark"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
@@ -15,6 +18,9 @@ import (
 	"gitlab.com/etke.cc/postmoogle/utils"
 )
 
+// headerRenderer renders the message header (markdown) the same way as format.RenderMarkdown does
+var headerRenderer = goldmark.New(format.Extensions, format.HTMLOptions)
+
 // Email object
 type Email struct {
 	Date        string
@@ -30,6 +36,49 @@ type Email struct {
 	HTML        string
 	Files       []*utils.File
 	InlineFiles []*utils.File
+	// Quarantine reason, if the email was quarantined by milter
+	Quarantine string
+	// DSN is a delivery status notification, if the email is a bounce
+	DSN *DSN
+	// Tag of the subaddressed recipient, e.g. tag of mailbox+tag@example.com
+	Tag string
+	// Headers of the incoming email
+	Headers textproto.MIMEHeader
+	// EnvelopeFrom is the SMTP envelope sender (MAIL FROM) of the incoming email
+	EnvelopeFrom string
+	// Raw incoming email
+	Raw []byte
+	// Original incoming email as received, before milters modified it
+	Original []byte
+	// Labels (flags) set by the room's Sieve script
+	Labels []string
+	// AutoSubmitted header of automatic responses (RFC 3834)
+	AutoSubmitted string
+	// FromName is the display name of the sender of outgoing email
+	FromName string
+	// ReplyTo address of outgoing email
+	ReplyTo string
+	// List headers of outgoing email redistributed by the mailing list
+	List *MailingList
+}
+
+// MailingList headers (RFC 2369, RFC 2919)
+type MailingList struct {
+	// ID of the list, e.g. mailbox.example.com
+	ID string
+	// Post address of the list
+	Post string
+	// Unsubscribe address of the list
+	Unsubscribe string
+}
+
+// RejectedError is returned when the email is rejected by the recipient's filters
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Reason
 }
 
 // New constructs Email object
@@ -77,6 +126,16 @@ func FromEnvelope(rcptto string, envelope *enmime.Envelope) *Email {
 	inlines := make([]*utils.File, 0, len(envelope.Inlines))
 	for _, inline := range envelope.Inlines {
 		file := utils.NewFile(inline.FileName, inline.Content)
+		file.ContentID = inline.ContentID
+		inlines = append(inlines, file)
+	}
+	// images of multipart/related without Content-Disposition are referenced by the HTML as well
+	for _, part := range envelope.OtherParts {
+		if part.ContentID == "" || !strings.HasPrefix(part.ContentType, "image/") {
+			continue
+		}
+		file := utils.NewFile(part.FileName, part.Content)
+		file.ContentID = part.ContentID
 		inlines = append(inlines, file)
 	}
 
@@ -94,6 +153,10 @@ func FromEnvelope(rcptto string, envelope *enmime.Envelope) *Email {
 		HTML:        html,
 		Files:       files,
 		InlineFiles: inlines,
+		DSN:         ParseDSN(envelope),
+	}
+	if envelope.Root != nil {
+		email.Headers = envelope.Root.Header
 	}
 
 	return email
@@ -102,40 +165,53 @@ func FromEnvelope(rcptto string, envelope *enmime.Envelope) *Email {
 // Mailbox returns postmoogle's mailbox, parsing it from FROM (if incoming=false) or TO (incoming=true)
 func (e *Email) Mailbox(incoming bool) string {
 	if incoming {
-		return utils.Mailbox(e.RcptTo)
+		rcptTo, _ := utils.ParseVERP(e.RcptTo)
+		return utils.Mailbox(rcptTo)
 	}
 	return utils.Mailbox(e.From)
 }
 
-// Content converts the email object to a Matrix event content
-func (e *Email) Content(threadID id.EventID, options *ContentOptions) *event.Content {
-	var text strings.Builder
-	if options.Sender {
-		text.WriteString(e.From)
+// Domain returns postmoogle's domain, parsing it from FROM (if incoming=false) or TO (incoming=true)
+func (e *Email) Domain(incoming bool) string {
+	if incoming {
+		return utils.Hostname(e.RcptTo)
 	}
-	if options.Recipient {
-		text.WriteString(" ➡️ ")
-		text.WriteString(e.To)
+	return utils.Hostname(e.From)
+}
+
+// Urgent checks if the email is marked as urgent or high priority by the sender
+// (X-Priority 1 or 2, Priority: urgent, Importance: high, X-MSMail-Priority: high)
+func (e *Email) Urgent() bool {
+	if e.Headers == nil {
+		return false
 	}
-	if options.CC && len(e.CC) > 0 {
-		text.WriteString("\ncc: ")
-		text.WriteString(strings.Join(e.CC, ", "))
+	if priority := strings.TrimSpace(e.Headers.Get("X-Priority")); strings.HasPrefix(priority, "1") || strings.HasPrefix(priority, "2") {
+		return true
 	}
-	if options.Sender || options.Recipient || options.CC {
-		text.WriteString("\n\n")
+	if strings.EqualFold(strings.TrimSpace(e.Headers.Get("Priority")), "urgent") {
+		return true
 	}
-	if options.Subject && threadID == "" {
-		text.WriteString("# ")
-		text.WriteString(e.Subject)
-		text.WriteString("\n\n")
+	if strings.EqualFold(strings.TrimSpace(e.Headers.Get("Importance")), "high") {
+		return true
 	}
-	if e.HTML != "" && options.HTML {
-		text.WriteString(format.HTMLToMarkdown(e.HTML))
+
+	return strings.EqualFold(strings.TrimSpace(e.Headers.Get("X-MSMail-Priority")), "high")
+}
+
+// Content converts the email object to a Matrix event content, using the room's message templates, if any
+func (e *Email) Content(threadID id.EventID, options *ContentOptions) *event.Content {
+	var parsed event.MessageEventContent
+	if text, ok := e.renderTemplate(options.Template, false, threadID.String(), options); ok {
+		parsed = renderMarkdown(text, options.Images)
+	} else if e.HTML != "" && options.HTML {
+		parsed = e.htmlContent(threadID, options)
 	} else {
-		text.WriteString(e.Text)
+		parsed = renderMarkdown(e.header(threadID, options)+e.Text, nil)
+	}
+	if html, ok := e.renderTemplate(options.HTMLTemplate, true, threadID.String(), options); ok {
+		parsed.Format = event.FormatHTML
+		parsed.FormattedBody = sanitizeHTML(html, options.Images)
 	}
-
-	parsed := format.RenderMarkdown(text.String(), true, true)
 	parsed.RelatesTo = utils.RelatesTo(options.Threads, threadID)
 
 	var cc string
@@ -153,12 +229,94 @@ func (e *Email) Content(threadID id.EventID, options *ContentOptions) *event.Con
 			options.FromKey:       e.From,
 			options.ToKey:         e.To,
 			options.CcKey:         cc,
+			options.TagKey:        e.Tag,
 		},
 		Parsed: &parsed,
 	}
 	return &content
 }
 
+// renderMarkdown renders the markdown with HTML, the resulting HTML is sanitized,
+// because the markdown contains the email fields as is
+func renderMarkdown(text string, images map[string]string) event.MessageEventContent {
+	parsed := format.RenderMarkdown(text, true, true)
+	if parsed.Format == event.FormatHTML {
+		parsed.FormattedBody = sanitizeHTML(parsed.FormattedBody, images)
+	}
+
+	return parsed
+}
+
+// htmlContent renders the email HTML sanitized to the subset allowed in matrix messages,
+// the plain text part is used as the message body
+func (e *Email) htmlContent(threadID id.EventID, options *ContentOptions) event.MessageEventContent {
+	header := e.header(threadID, options)
+	text := e.Text
+	if strings.TrimSpace(text) == "" {
+		text = format.HTMLToMarkdown(e.HTML)
+	}
+
+	var formatted strings.Builder
+	if header != "" {
+		// rendered without format.RenderMarkdown, because it unwraps single paragraph
+		// and the header would be glued to the body.
+		// The header contains the email fields as is, so it's sanitized as well
+		var rendered strings.Builder
+		if err := headerRenderer.Convert([]byte(header), &rendered); err != nil {
+			formatted.WriteString("<p>" + html.EscapeString(header) + "</p>")
+		} else {
+			formatted.WriteString(sanitizeHTML(rendered.String(), nil))
+		}
+	}
+	formatted.WriteString(sanitizeHTML(e.HTML, options.Images))
+
+	return event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          header + text,
+		Format:        event.FormatHTML,
+		FormattedBody: formatted.String(),
+	}
+}
+
+// header returns the default header of the matrix message (markdown): sender, recipients, tags, labels and subject
+func (e *Email) header(threadID id.EventID, options *ContentOptions) string {
+	var text strings.Builder
+	if e.Quarantine != "" {
+		text.WriteString("**quarantined**: ")
+		text.WriteString(e.Quarantine)
+		text.WriteString("\n\n")
+	}
+	if options.Sender {
+		text.WriteString(e.From)
+	}
+	if options.Recipient {
+		text.WriteString(" ➡️ ")
+		text.WriteString(e.To)
+	}
+	if options.CC && len(e.CC) > 0 {
+		text.WriteString("\ncc: ")
+		text.WriteString(strings.Join(e.CC, ", "))
+	}
+	if e.Tag != "" {
+		text.WriteString("\ntag: ")
+		text.WriteString(e.Tag)
+	}
+	if len(e.Labels) > 0 {
+		text.WriteString("\nlabels: ")
+		text.WriteString(strings.Join(e.Labels, ", "))
+	}
+	if options.Sender || options.Recipient || options.CC || e.Tag != "" || len(e.Labels) > 0 {
+		text.WriteString("\n\n")
+	}
+	if options.Subject && threadID == "" {
+		text.WriteString("# ")
+		text.WriteString(e.Subject)
+		text.WriteString("\n\n")
+	}
+
+	return text.String()
+}
+
 // Compose converts the email object to a string (to be used for delivery via SMTP) and possibly DKIM-signs it
 func (e *Email) Compose(privkey string) string {
 	textSize := len(e.Text)
@@ -168,7 +326,7 @@ func (e *Email) Compose(privkey string) string {
 	}
 
 	mail := enmime.Builder().
-		From("", e.From).
+		From(e.FromName, e.From).
 		To("", e.To).
 		Header("Message-Id", e.MessageID).
 		Subject(e.Subject)
@@ -184,11 +342,28 @@ func (e *Email) Compose(privkey string) string {
 	if e.References != "" {
 		mail = mail.Header("References", e.References)
 	}
+	if e.AutoSubmitted != "" {
+		mail = mail.Header("Auto-Submitted", e.AutoSubmitted)
+	}
+	if e.ReplyTo != "" {
+		mail = mail.ReplyTo("", e.ReplyTo)
+	}
+	if e.List != nil {
+		mail = mail.Header("List-Id", "<"+e.List.ID+">").
+			Header("List-Post", "<mailto:"+e.List.Post+">").
+			Header("Precedence", "list")
+		if e.List.Unsubscribe != "" {
+			mail = mail.Header("List-Unsubscribe", "<mailto:"+e.List.Unsubscribe+">")
+		}
+	}
 	if len(e.CC) > 0 {
 		for _, addr := range e.CC {
 			mail = mail.CC("", addr)
 		}
 	}
+	for _, file := range e.Files {
+		mail = mail.AddAttachment(file.Content, file.Type, file.Name)
+	}
 
 	root, err := mail.Build()
 	if err != nil {
@@ -19,6 +19,15 @@ type ContentOptions struct {
 	HTML      bool
 	Threads   bool
 
+	// Templates of the message (markdown) and its HTML, empty = default layout
+	Template     string
+	HTMLTemplate string
+	// Images are the inline files uploaded to the matrix for this delivery (content ID -> mxc URI),
+	// rendered in place of cid: URLs
+	Images map[string]string
+	// TemplateError is called when the template cannot be rendered, the default layout is used then
+	TemplateError func(error)
+
 	// Keys
 	MessageIDKey  string
 	InReplyToKey  string
@@ -28,4 +37,12 @@ type ContentOptions struct {
 	ToKey         string
 	CcKey         string
 	RcptToKey     string
+	TagKey        string
+}
+
+// templateError reports the template rendering error, if the handler is set
+func (o *ContentOptions) templateError(err error) {
+	if o.TemplateError != nil {
+		o.TemplateError(err)
+	}
 }
@@ -0,0 +1,232 @@
+package email
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	whitespaceRegex = regexp.MustCompile(`\s+`)
+	colorRegex      = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// allowedTags is the HTML subset allowed in the matrix messages
+var allowedTags = map[string]bool{
+	"font": true, "del": true, "s": true, "strike": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "p": true, "a": true, "ul": true, "ol": true, "li": true,
+	"sup": true, "sub": true, "b": true, "i": true, "u": true, "strong": true, "em": true,
+	"code": true, "pre": true, "hr": true, "br": true, "div": true, "span": true,
+	"table": true, "thead": true, "tbody": true, "tr": true, "th": true, "td": true, "caption": true,
+	"details": true, "summary": true,
+}
+
+// renamedTags are the tags that aren't allowed in the matrix messages, but have an allowed equivalent
+var renamedTags = map[string]string{
+	"tfoot": "tbody", "kbd": "code", "samp": "code", "tt": "code", "ins": "u",
+	"section": "div", "article": "div", "header": "div", "footer": "div", "main": "div", "nav": "div",
+	"aside": "div", "center": "div", "address": "div", "figure": "div", "figcaption": "div",
+	"dl": "div", "dt": "div", "dd": "div",
+}
+
+// droppedTags are removed along with their content
+var droppedTags = map[string]bool{
+	"head": true, "title": true, "meta": true, "link": true, "base": true, "style": true, "script": true, "noscript": true,
+	"template": true, "iframe": true, "frame": true, "frameset": true, "object": true, "embed": true, "applet": true,
+	"form": true, "input": true, "button": true, "select": true, "textarea": true,
+	"svg": true, "math": true, "canvas": true, "audio": true, "video": true, "map": true,
+}
+
+// voidTags don't have content and closing tag
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+// structuralTags can't contain text, so whitespace between their children is dropped
+var structuralTags = map[string]bool{
+	"table": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "ul": true, "ol": true,
+}
+
+// allowedSchemes of the links
+var allowedSchemes = map[string]bool{"http": true, "https": true, "ftp": true, "mailto": true, "magnet": true}
+
+// sanitizeHTML converts email HTML into the HTML subset allowed in the matrix messages,
+// keeping the formatting, tables and links, and removing remote images (including tracking pixels).
+// Inline images (cid: URLs) are replaced with their mxc URIs from the images map (content ID -> mxc URI)
+func sanitizeHTML(src string, images map[string]string) string {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return ""
+	}
+	var out strings.Builder
+	sanitizeChildren(&out, doc, images, false)
+
+	return strings.TrimSpace(out.String())
+}
+
+func sanitizeChildren(out *strings.Builder, node *html.Node, images map[string]string, pre bool) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		sanitizeNode(out, child, images, pre)
+	}
+}
+
+func sanitizeNode(out *strings.Builder, node *html.Node, images map[string]string, pre bool) {
+	switch node.Type {
+	case html.DocumentNode:
+		sanitizeChildren(out, node, images, pre)
+		return
+	case html.TextNode:
+		sanitizeText(out, node, pre)
+		return
+	case html.ElementNode:
+	default: // comments, doctype
+		return
+	}
+
+	tag := node.Data
+	if renamed, ok := renamedTags[tag]; ok {
+		tag = renamed
+	}
+	if droppedTags[tag] {
+		return
+	}
+	if tag == "img" {
+		sanitizeImage(out, node, images)
+		return
+	}
+	if !allowedTags[tag] {
+		sanitizeChildren(out, node, images, pre)
+		return
+	}
+
+	out.WriteString("<")
+	out.WriteString(tag)
+	for _, attr := range sanitizeAttributes(tag, node.Attr) {
+		out.WriteString(" ")
+		out.WriteString(attr.Key)
+		out.WriteString(`="`)
+		out.WriteString(html.EscapeString(attr.Val))
+		out.WriteString(`"`)
+	}
+	out.WriteString(">")
+	if voidTags[tag] {
+		return
+	}
+	sanitizeChildren(out, node, images, pre || tag == "pre")
+	out.WriteString("</")
+	out.WriteString(tag)
+	out.WriteString(">")
+}
+
+func sanitizeText(out *strings.Builder, node *html.Node, pre bool) {
+	text := node.Data
+	if !pre {
+		text = whitespaceRegex.ReplaceAllString(text, " ")
+		if text == " " && node.Parent != nil && structuralTags[node.Parent.Data] {
+			return
+		}
+	}
+	out.WriteString(html.EscapeString(text))
+}
+
+// sanitizeImage keeps images uploaded to the matrix only (including inline images),
+// other images are replaced with their alt text and tracking pixels are removed
+func sanitizeImage(out *strings.Builder, node *html.Node, images map[string]string) {
+	src := attribute(node, "src")
+	if contentID, ok := cutPrefixFold(src, "cid:"); ok {
+		src = images[contentID]
+	}
+	if strings.HasPrefix(src, "mxc://") {
+		out.WriteString(`<img src="`)
+		out.WriteString(html.EscapeString(src))
+		out.WriteString(`"`)
+		for _, key := range []string{"alt", "title", "width", "height"} {
+			value := attribute(node, key)
+			if value == "" || ((key == "width" || key == "height") && !isNumber(value)) {
+				continue
+			}
+			out.WriteString(" ")
+			out.WriteString(key)
+			out.WriteString(`="`)
+			out.WriteString(html.EscapeString(value))
+			out.WriteString(`"`)
+		}
+		out.WriteString(">")
+		return
+	}
+	if isTrackingPixel(node) {
+		return
+	}
+	if alt := strings.TrimSpace(attribute(node, "alt")); alt != "" {
+		out.WriteString(html.EscapeString(alt))
+	}
+}
+
+// isTrackingPixel checks if the image is hidden or 1x1 (or smaller)
+func isTrackingPixel(node *html.Node) bool {
+	for _, key := range []string{"width", "height"} {
+		if size, err := strconv.Atoi(strings.TrimSuffix(attribute(node, key), "px")); err == nil && size <= 1 {
+			return true
+		}
+	}
+	style := strings.ReplaceAll(strings.ToLower(attribute(node, "style")), " ", "")
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+// sanitizeAttributes returns attributes allowed in the matrix messages
+func sanitizeAttributes(tag string, attrs []html.Attribute) []html.Attribute {
+	allowed := make([]html.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		var ok bool
+		switch tag + " " + attr.Key {
+		case "a href":
+			ok = isAllowedURL(attr.Val)
+		case "a title":
+			ok = true
+		case "ol start":
+			ok = isNumber(attr.Val)
+		case "font color", "font data-mx-color", "font data-mx-bg-color", "span data-mx-color", "span data-mx-bg-color":
+			ok = colorRegex.MatchString(attr.Val)
+		case "span data-mx-spoiler":
+			ok = true
+		case "code class":
+			ok = strings.HasPrefix(attr.Val, "language-")
+		}
+		if ok {
+			allowed = append(allowed, html.Attribute{Key: attr.Key, Val: attr.Val})
+		}
+	}
+	return allowed
+}
+
+// cutPrefixFold returns value without the case-insensitive prefix and whether the prefix was found
+func cutPrefixFold(value, prefix string) (string, bool) {
+	if len(value) < len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return value, false
+	}
+	return value[len(prefix):], true
+}
+
+func isAllowedURL(value string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	return allowedSchemes[strings.ToLower(parsed.Scheme)]
+}
+
+func isNumber(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
+
+func attribute(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	images := map[string]string{"logo@example.com": "mxc://example.com/logo"}
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{name: "formatting", src: "<p>Hello, <b>world</b> <em>!</em></p>", expected: "<p>Hello, <b>world</b> <em>!</em></p>"},
+		{name: "document", src: "<html><head><title>T</title><style>p{}</style></head><body><p>text</p></body></html>", expected: "<p>text</p>"},
+		{name: "script", src: "<p>a<script>alert(1)</script>b</p>", expected: "<p>ab</p>"},
+		{name: "comment", src: "<p>a<!-- hidden -->b</p>", expected: "<p>ab</p>"},
+		{name: "event handler", src: `<p onclick="alert(1)" style="color:red">text</p>`, expected: "<p>text</p>"},
+		{name: "unknown tag is unwrapped", src: "<p><marquee>text</marquee></p>", expected: "<p>text</p>"},
+		{name: "renamed tags", src: "<section><kbd>ls</kbd></section>", expected: "<div><code>ls</code></div>"},
+		{name: "form is dropped", src: "<form><input name=x>text</form>after", expected: "after"},
+		{name: "allowed link", src: `<a href="https://example.com/?a=1&amp;b=2" title="t">link</a>`, expected: `<a href="https://example.com/?a=1&amp;b=2" title="t">link</a>`},
+		{name: "javascript link", src: `<a href="javascript:alert(1)">link</a>`, expected: "<a>link</a>"},
+		{name: "mailto link", src: `<a href="mailto:user@example.com">mail</a>`, expected: `<a href="mailto:user@example.com">mail</a>`},
+		{name: "font color", src: `<font color="#ff0000">red</font><font color="red">named</font>`, expected: `<font color="#ff0000">red</font><font>named</font>`},
+		{name: "code language", src: `<pre><code class="language-go">a  b</code></pre>`, expected: `<pre><code class="language-go">a  b</code></pre>`},
+		{name: "code class", src: `<code class="evil">x</code>`, expected: "<code>x</code>"},
+		{name: "ordered list start", src: `<ol start="3"><li>x</li></ol><ol start="x"><li>y</li></ol>`, expected: `<ol start="3"><li>x</li></ol><ol><li>y</li></ol>`},
+		{name: "whitespace", src: "<p>a \n\t b</p>", expected: "<p>a b</p>"},
+		{name: "table whitespace", src: "<table>\n<tr>\n<td>1</td>\n</tr>\n</table>", expected: "<table><tbody><tr><td>1</td></tr></tbody></table>"},
+		{name: "escaped text", src: "<p>&lt;b&gt; &amp;</p>", expected: "<p>&lt;b&gt; &amp;</p>"},
+		{name: "void tags", src: "a<br>b<hr>", expected: "a<br>b<hr>"},
+		{name: "inline image", src: `<img src="cid:logo@example.com" alt="logo" width="10" height="auto">`, expected: `<img src="mxc://example.com/logo" alt="logo" width="10">`},
+		{name: "inline image case-insensitive", src: `<img src="CID:logo@example.com">`, expected: `<img src="mxc://example.com/logo">`},
+		{name: "unknown inline image", src: `<img src="cid:other@example.com" alt="other">`, expected: "other"},
+		{name: "remote image", src: `<img src="https://example.com/image.png" alt="image">`, expected: "image"},
+		{name: "tracking pixel", src: `<img src="https://example.com/t.gif" width="1" height="1" alt="pixel">`, expected: ""},
+		{name: "hidden image", src: `<img src="https://example.com/t.gif" style="display: none" alt="pixel">`, expected: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if sanitized := sanitizeHTML(test.src, images); sanitized != test.expected {
+				t.Errorf("sanitizeHTML(%q) = %q, want %q", test.src, sanitized, test.expected)
+			}
+		})
+	}
+}
+
+func TestContentHostileSubject(t *testing.T) {
+	subject := `<a href="javascript:alert(1)">click</a><img src="https://tracker.example/p.gif">`
+	eml := New("<id@example.com>", "", "", subject, "sender@example.com", "mailbox@example.com", "mailbox@example.com", "", "hello", "<p>hi</p>", nil, nil)
+	for _, withHTML := range []bool{true, false} {
+		options := &ContentOptions{Sender: true, Subject: true, HTML: withHTML}
+		content := eml.Content("", options).AsMessage()
+		if !strings.Contains(content.FormattedBody, "click") {
+			t.Errorf("FormattedBody (html: %t) = %q, want the subject text", withHTML, content.FormattedBody)
+		}
+		if strings.Contains(content.FormattedBody, "javascript:") || strings.Contains(content.FormattedBody, "tracker.example") {
+			t.Errorf("FormattedBody (html: %t) = %q, want sanitized subject", withHTML, content.FormattedBody)
+		}
+	}
+}
+
+func TestIsAllowedURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com":       true,
+		" HTTP://example.com ":      true,
+		"ftp://example.com/file":    true,
+		"magnet:?xt=urn:btih:x":     true,
+		"javascript:alert(1)":       false,
+		"data:text/html;base64,PHA": false,
+		"/relative/path":            false,
+		"%zz":                       false,
+	}
+	for value, allowed := range tests {
+		if result := isAllowedURL(value); result != allowed {
+			t.Errorf("isAllowedURL(%q) = %t, want %t", value, result, allowed)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package email
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"net/textproto"
+	"strings"
+	"sync"
+	"text/template"
+
+	"maunium.net/go/mautrix/format"
+)
+
+// ExampleTemplate of the matrix message, similar to the default layout
+const ExampleTemplate = `{{if .Quarantine}}**quarantined**: {{.Quarantine}}
+
+{{end}}{{.From}} ➡️ {{.To}}{{if .CC}}
+cc: {{join .CC ", "}}{{end}}{{if .Tag}}
+tag: {{.Tag}}{{end}}{{if .Labels}}
+labels: {{join .Labels ", "}}{{end}}
+
+{{if not .Threaded}}# {{.Subject}}
+
+{{end}}{{.Body}}`
+
+// templateFuncs available in the message templates
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// htmlTemplateFuncs available in the HTML templates, the output is sanitized anyway
+var htmlTemplateFuncs = htmltemplate.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	// unescaped inserts the email HTML as is, e.g. {{unescaped .HTML}}
+	"unescaped": func(value string) htmltemplate.HTML {
+		return htmltemplate.HTML(value) //nolint:gosec // the output is sanitized
+	},
+}
+
+// executor is parsed text/template or html/template
+type executor interface {
+	Execute(io.Writer, any) error
+}
+
+// templateKey of the parsed templates cache
+type templateKey struct {
+	src  string
+	html bool
+}
+
+var (
+	// templates cache of the parsed and validated templates
+	templates   = map[templateKey]executor{}
+	templatesMu sync.Mutex
+)
+
+// TemplateData is the data available in the message templates
+type TemplateData struct {
+	*Email
+	// Body of the email as markdown (HTML converted into markdown, if HTML is allowed)
+	Body string
+	// Threaded is true if the message is posted into existing thread
+	Threaded bool
+	// Attachments names (including inline ones)
+	Attachments []string
+	// AuthenticationResults headers of the email
+	AuthenticationResults []string
+}
+
+// Header returns value of the email header
+func (d *TemplateData) Header(name string) string {
+	if d.Headers == nil {
+		return ""
+	}
+	return d.Headers.Get(name)
+}
+
+// ParseTemplate parses the message template (text/template of markdown or html/template of HTML),
+// validates it against an example email and caches it
+func ParseTemplate(src string, html bool) error {
+	_, err := getTemplate(src, html)
+	return err
+}
+
+// getTemplate returns cached template, parsing and validating it on first use
+func getTemplate(src string, html bool) (executor, error) {
+	key := templateKey{src: src, html: html}
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	if tpl, ok := templates[key]; ok {
+		return tpl, nil
+	}
+
+	var tpl executor
+	var err error
+	if html {
+		tpl, err = htmltemplate.New("message").Funcs(htmlTemplateFuncs).Parse(src)
+	} else {
+		tpl, err = template.New("message").Funcs(templateFuncs).Parse(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	example := New("<example@example.com>", "", "", "Example", "sender@example.com", "mailbox@example.com", "mailbox@example.com", "cc@example.com", "Hello, world", "", nil, nil)
+	example.Headers = textproto.MIMEHeader{"Authentication-Results": {"example.com; spf=pass"}}
+	if err := tpl.Execute(io.Discard, example.templateData("", &ContentOptions{HTML: true})); err != nil {
+		return nil, err
+	}
+	templates[key] = tpl
+
+	return tpl, nil
+}
+
+// templateData converts the email into the message template data
+func (e *Email) templateData(threadID string, options *ContentOptions) *TemplateData {
+	body := e.Text
+	if e.HTML != "" && options.HTML {
+		body = format.HTMLToMarkdown(e.HTML)
+	}
+	attachments := make([]string, 0, len(e.Files)+len(e.InlineFiles))
+	for _, file := range e.Files {
+		attachments = append(attachments, file.Name)
+	}
+	for _, file := range e.InlineFiles {
+		attachments = append(attachments, file.Name)
+	}
+	var authResults []string
+	if e.Headers != nil {
+		authResults = e.Headers.Values("Authentication-Results")
+	}
+
+	return &TemplateData{
+		Email:                 e,
+		Body:                  body,
+		Threaded:              threadID != "",
+		Attachments:           attachments,
+		AuthenticationResults: authResults,
+	}
+}
+
+// renderTemplate renders the message template, returns false if the template is not set or fails.
+// Failures are reported with options.TemplateError
+func (e *Email) renderTemplate(src string, html bool, threadID string, options *ContentOptions) (string, bool) {
+	if src == "" {
+		return "", false
+	}
+	tpl, err := getTemplate(src, html)
+	if err != nil {
+		options.templateError(err)
+		return "", false
+	}
+	var out strings.Builder
+	if err := tpl.Execute(&out, e.templateData(threadID, options)); err != nil {
+		options.templateError(err)
+		return "", false
+	}
+
+	return out.String(), true
+}
@@ -0,0 +1,64 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		html  bool
+		valid bool
+	}{
+		{name: "example", src: ExampleTemplate, valid: true},
+		{name: "header", src: `{{.Header "Authentication-Results"}}`, valid: true},
+		{name: "html", src: `<b>{{.Subject}}</b>{{unescaped .HTML}}`, html: true, valid: true},
+		{name: "syntax error", src: "{{.Subject", valid: false},
+		{name: "unknown field", src: "{{.Unknown}}", valid: false},
+		{name: "unescaped is html only", src: "{{unescaped .HTML}}", valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ParseTemplate(test.src, test.html); (err == nil) != test.valid {
+				t.Errorf("ParseTemplate() error = %v, valid = %t", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestContentTemplates(t *testing.T) {
+	eml := New("<id@example.com>", "", "", "<script>alert(1)</script>", "sender@example.com", "mailbox@example.com", "mailbox@example.com", "", "hello", `<p onclick="x()">hi</p>`, nil, nil)
+	var errs []error
+	options := &ContentOptions{
+		HTML:          true,
+		Template:      "**{{.Subject}}**",
+		HTMLTemplate:  `<h1>{{.Subject}}</h1><img src="https://example.com/x.png">{{unescaped .HTML}}`,
+		TemplateError: func(err error) { errs = append(errs, err) },
+	}
+	content := eml.Content("", options).AsMessage()
+	if len(errs) > 0 {
+		t.Fatalf("unexpected template errors: %v", errs)
+	}
+	if !strings.Contains(content.FormattedBody, "<h1>&lt;script&gt;alert(1)&lt;/script&gt;</h1>") {
+		t.Errorf("FormattedBody = %q, want escaped subject", content.FormattedBody)
+	}
+	if strings.Contains(content.FormattedBody, "onclick") || strings.Contains(content.FormattedBody, "https://example.com/x.png") {
+		t.Errorf("FormattedBody = %q, want sanitized HTML", content.FormattedBody)
+	}
+
+	options.HTMLTemplate = ""
+	options.Template = "**{{.Subject}}**\n\n{{.Text}}"
+	eml.Text = `<a href="javascript:alert(1)">click</a>`
+	content = eml.Content("", options).AsMessage()
+	if strings.Contains(content.FormattedBody, "<script>") || strings.Contains(content.FormattedBody, "javascript:") {
+		t.Errorf("FormattedBody = %q, want sanitized markdown template", content.FormattedBody)
+	}
+
+	options.Template = "{{.Header}}"
+	eml.Content("", options)
+	if len(errs) != 1 {
+		t.Errorf("template errors = %v, want 1 error", errs)
+	}
+}
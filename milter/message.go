@@ -0,0 +1,171 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+// Header of a message
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Modifications requested by milter at the end of message
+type Modifications struct {
+	// Quarantine reason, empty = not quarantined
+	Quarantine string
+	// Body replacement, nil = unchanged
+	Body []byte
+
+	headers []headerChange
+}
+
+type headerChange struct {
+	cmd   byte
+	index int
+	name  string
+	value string
+}
+
+// parse modification packet, returns false if it's not a modification
+func (m *Modifications) parse(cmd byte, data []byte, actions uint32) bool {
+	switch cmd {
+	case respAddHeader:
+		if actions&actAddHeader == 0 {
+			return true
+		}
+		name, value := splitPair(data)
+		m.headers = append(m.headers, headerChange{cmd: cmd, name: name, value: value})
+	case respInsHeader, respChgHeader:
+		if len(data) < 4 {
+			return true
+		}
+		if cmd == respInsHeader && actions&actAddHeader == 0 || cmd == respChgHeader && actions&actChgHeader == 0 {
+			return true
+		}
+		index := int(binary.BigEndian.Uint32(data))
+		name, value := splitPair(data[4:])
+		m.headers = append(m.headers, headerChange{cmd: cmd, index: index, name: name, value: value})
+	case respReplBody:
+		if actions&actChgBody == 0 {
+			return true
+		}
+		m.Body = append(m.Body, data...)
+	case respQuarantine:
+		if actions&actQuarantine == 0 {
+			return true
+		}
+		m.Quarantine = string(bytes.TrimRight(data, "\x00"))
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Changed checks if milter requested changes of the message headers or body
+func (m *Modifications) Changed() bool {
+	return m != nil && (len(m.headers) > 0 || m.Body != nil)
+}
+
+// Apply modifications to the message headers and body
+func (m *Modifications) Apply(headers []Header, body []byte) ([]Header, []byte) {
+	for _, change := range m.headers {
+		switch change.cmd {
+		case respAddHeader:
+			headers = append(headers, Header{Name: change.name, Value: change.value})
+		case respInsHeader:
+			index := change.index
+			if index > len(headers) {
+				index = len(headers)
+			}
+			headers = append(headers[:index], append([]Header{{Name: change.name, Value: change.value}}, headers[index:]...)...)
+		case respChgHeader:
+			headers = changeHeader(headers, change)
+		}
+	}
+	if m.Body != nil {
+		body = m.Body
+	}
+
+	return headers, body
+}
+
+// changeHeader replaces value of the N-th occurrence of the header, empty value removes the header
+func changeHeader(headers []Header, change headerChange) []Header {
+	var occurrence int
+	for i, header := range headers {
+		if !strings.EqualFold(header.Name, change.name) {
+			continue
+		}
+		occurrence++
+		if occurrence != change.index {
+			continue
+		}
+		if change.value == "" {
+			return append(headers[:i], headers[i+1:]...)
+		}
+		headers[i].Value = change.value
+		return headers
+	}
+	if change.value != "" {
+		headers = append(headers, Header{Name: change.name, Value: change.value})
+	}
+
+	return headers
+}
+
+// Split raw message into headers and body
+func Split(data []byte) ([]Header, []byte) {
+	var headers []Header
+	rest := data
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n')
+		var line []byte
+		if end == -1 {
+			line, rest = rest, nil
+		} else {
+			line, rest = rest[:end], rest[end+1:]
+		}
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 { // end of headers
+			return headers, rest
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 { // folded header
+			headers[len(headers)-1].Value += "\r\n" + string(line)
+			continue
+		}
+		name, value, ok := strings.Cut(string(line), ":")
+		if !ok {
+			continue
+		}
+		headers = append(headers, Header{Name: name, Value: strings.TrimLeft(value, " \t")})
+	}
+
+	return headers, nil
+}
+
+// Join headers and body into raw message
+func Join(headers []Header, body []byte) []byte {
+	var msg bytes.Buffer
+	for _, header := range headers {
+		msg.WriteString(header.Name)
+		msg.WriteString(": ")
+		msg.WriteString(header.Value)
+		msg.WriteString("\r\n")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body)
+
+	return msg.Bytes()
+}
+
+func splitPair(data []byte) (string, string) {
+	parts := bytes.SplitN(bytes.TrimRight(data, "\x00"), []byte{0}, 2)
+	if len(parts) < 2 {
+		return string(parts[0]), ""
+	}
+	return string(parts[0]), string(parts[1])
+}
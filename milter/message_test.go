@@ -0,0 +1,172 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		headers []Header
+		body    string
+	}{
+		{
+			name:    "simple",
+			data:    "From: a@example.com\r\nSubject: hello\r\n\r\nbody\r\n",
+			headers: []Header{{Name: "From", Value: "a@example.com"}, {Name: "Subject", Value: "hello"}},
+			body:    "body\r\n",
+		},
+		{
+			name:    "folded header",
+			data:    "Subject: hello\r\n world\r\nTo: b@example.com\r\n\r\nbody",
+			headers: []Header{{Name: "Subject", Value: "hello\r\n world"}, {Name: "To", Value: "b@example.com"}},
+			body:    "body",
+		},
+		{
+			name:    "lf only",
+			data:    "Subject:hello\n\nbody\n",
+			headers: []Header{{Name: "Subject", Value: "hello"}},
+			body:    "body\n",
+		},
+		{
+			name:    "no body",
+			data:    "Subject: hello\r\n",
+			headers: []Header{{Name: "Subject", Value: "hello"}},
+			body:    "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers, body := Split([]byte(test.data))
+			if !reflect.DeepEqual(headers, test.headers) {
+				t.Errorf("headers = %#v, want %#v", headers, test.headers)
+			}
+			if string(body) != test.body {
+				t.Errorf("body = %q, want %q", body, test.body)
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	data := Join([]Header{{Name: "From", Value: "a@example.com"}, {Name: "Subject", Value: "hello\r\n world"}}, []byte("body"))
+	expected := "From: a@example.com\r\nSubject: hello\r\n world\r\n\r\nbody"
+	if string(data) != expected {
+		t.Errorf("Join() = %q, want %q", data, expected)
+	}
+
+	headers, body := Split(data)
+	if !bytes.Equal(Join(headers, body), data) {
+		t.Error("Split() and Join() are not symmetric for canonical message")
+	}
+}
+
+func TestModifications(t *testing.T) {
+	index := func(i uint32, pair string) []byte {
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, i)
+		return append(data, pair...)
+	}
+	headers := []Header{{Name: "From", Value: "a@example.com"}, {Name: "X-Spam", Value: "no"}, {Name: "X-Spam", Value: "maybe"}}
+
+	tests := []struct {
+		name     string
+		packets  map[byte][]byte
+		actions  uint32
+		changed  bool
+		expected []Header
+		body     string
+	}{
+		{
+			name:     "add header",
+			packets:  map[byte][]byte{respAddHeader: []byte("X-Score\x001.0\x00")},
+			actions:  actSupported,
+			changed:  true,
+			expected: append(append([]Header{}, headers...), Header{Name: "X-Score", Value: "1.0"}),
+			body:     "body",
+		},
+		{
+			name:     "insert header",
+			packets:  map[byte][]byte{respInsHeader: index(0, "X-First\x00yes\x00")},
+			actions:  actSupported,
+			changed:  true,
+			expected: append([]Header{{Name: "X-First", Value: "yes"}}, headers...),
+			body:     "body",
+		},
+		{
+			name:     "change second occurrence",
+			packets:  map[byte][]byte{respChgHeader: index(2, "x-spam\x00yes\x00")},
+			actions:  actSupported,
+			changed:  true,
+			expected: []Header{{Name: "From", Value: "a@example.com"}, {Name: "X-Spam", Value: "no"}, {Name: "X-Spam", Value: "yes"}},
+			body:     "body",
+		},
+		{
+			name:     "remove header",
+			packets:  map[byte][]byte{respChgHeader: index(1, "X-Spam\x00\x00")},
+			actions:  actSupported,
+			changed:  true,
+			expected: []Header{{Name: "From", Value: "a@example.com"}, {Name: "X-Spam", Value: "maybe"}},
+			body:     "body",
+		},
+		{
+			name:     "replace body",
+			packets:  map[byte][]byte{respReplBody: []byte("new body")},
+			actions:  actSupported,
+			changed:  true,
+			expected: headers,
+			body:     "new body",
+		},
+		{
+			name:     "not negotiated",
+			packets:  map[byte][]byte{respAddHeader: []byte("X-Score\x001.0\x00"), respReplBody: []byte("new body")},
+			actions:  actQuarantine,
+			changed:  false,
+			expected: headers,
+			body:     "body",
+		},
+		{
+			name:     "quarantine only",
+			packets:  map[byte][]byte{respQuarantine: []byte("spam\x00")},
+			actions:  actSupported,
+			changed:  false,
+			expected: headers,
+			body:     "body",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mods := &Modifications{}
+			for cmd, data := range test.packets {
+				if !mods.parse(cmd, data, test.actions) {
+					t.Fatalf("parse(%q) = false", cmd)
+				}
+			}
+			if mods.Changed() != test.changed {
+				t.Errorf("Changed() = %t, want %t", mods.Changed(), test.changed)
+			}
+			result, body := mods.Apply(append([]Header{}, headers...), []byte("body"))
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("headers = %#v, want %#v", result, test.expected)
+			}
+			if string(body) != test.body {
+				t.Errorf("body = %q, want %q", body, test.body)
+			}
+		})
+	}
+}
+
+func TestModificationsParseUnknown(t *testing.T) {
+	mods := &Modifications{}
+	if mods.parse(respContinue, nil, actSupported) {
+		t.Error("continue response parsed as modification")
+	}
+	var empty *Modifications
+	if empty.Changed() {
+		t.Error("nil modifications are changed")
+	}
+}
@@ -0,0 +1,383 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAddress returned when milter address cannot be parsed
+var ErrInvalidAddress = errors.New("invalid milter address")
+
+// Action is a milter verdict
+type Action int
+
+const (
+	// ActionContinue - proceed to the next step
+	ActionContinue Action = iota
+	// ActionAccept - accept the message, milter doesn't want to see anything else
+	ActionAccept
+	// ActionReject - reject the message (or recipient) permanently
+	ActionReject
+	// ActionTempFail - reject the message (or recipient) temporary
+	ActionTempFail
+	// ActionDiscard - accept the message, but silently drop it
+	ActionDiscard
+)
+
+// Response of a milter
+type Response struct {
+	Action       Action
+	Code         int
+	EnhancedCode [3]int
+	Message      string
+}
+
+// Client of a single milter
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// New milter client. Supported address formats: inet:host:port, unix:/path/to/socket, host:port
+func New(address string, timeout time.Duration) (*Client, error) {
+	network := "tcp"
+	switch {
+	case strings.HasPrefix(address, "inet:"):
+		address = strings.TrimPrefix(address, "inet:")
+	case strings.HasPrefix(address, "inet6:"):
+		network = "tcp6"
+		address = strings.TrimPrefix(address, "inet6:")
+	case strings.HasPrefix(address, "unix:"):
+		network = "unix"
+		address = strings.TrimPrefix(address, "unix:")
+	}
+	if address == "" {
+		return nil, ErrInvalidAddress
+	}
+	if network != "unix" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+		}
+	}
+
+	return &Client{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}, nil
+}
+
+// String returns milter address
+func (c *Client) String() string {
+	return c.network + ":" + c.address
+}
+
+// Session opens a new milter session and negotiates options
+func (c *Client) Session() (*Session, error) {
+	conn, err := net.DialTimeout(c.network, c.address, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{conn: conn, timeout: c.timeout}
+	if err := s.negotiate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Session of a single SMTP connection with a single milter
+type Session struct {
+	conn     net.Conn
+	timeout  time.Duration
+	actions  uint32
+	protocol uint32
+	// verdict is set when milter accepted or discarded the message and doesn't want to see anything else
+	verdict *Response
+}
+
+func (s *Session) negotiate() error {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data, protocolVersion)
+	binary.BigEndian.PutUint32(data[4:], actSupported)
+	binary.BigEndian.PutUint32(data[8:], protoSupported)
+	if err := s.write(cmdOptNeg, data); err != nil {
+		return err
+	}
+
+	cmd, resp, err := s.read()
+	if err != nil {
+		return err
+	}
+	if cmd != respOptNeg || len(resp) < 12 {
+		return fmt.Errorf("unexpected negotiation response %q", cmd)
+	}
+	if version := binary.BigEndian.Uint32(resp); version < 2 {
+		return fmt.Errorf("unsupported milter protocol version %d", version)
+	}
+	s.actions = binary.BigEndian.Uint32(resp[4:]) & actSupported
+	s.protocol = binary.BigEndian.Uint32(resp[8:]) & protoSupported
+
+	return nil
+}
+
+// Connect sends connection info
+func (s *Session) Connect(hostname string, addr net.Addr) (*Response, error) {
+	data := cstring(hostname)
+	ip, port := addrParts(addr)
+	switch {
+	case ip == nil:
+		data = append(data, 'U')
+	case ip.To4() != nil:
+		data = append(data, '4')
+	default:
+		data = append(data, '6')
+	}
+	if ip != nil {
+		data = append(data, byte(port>>8), byte(port))
+		data = append(data, cstring(ip.String())...)
+	}
+
+	return s.step(cmdConnect, data, protoNoConnect, protoNoConnectReply)
+}
+
+// Helo sends HELO/EHLO name
+func (s *Session) Helo(name string) (*Response, error) {
+	return s.step(cmdHelo, cstring(name), protoNoHelo, protoNoHeloReply)
+}
+
+// Mail sends MAIL FROM
+func (s *Session) Mail(from string) (*Response, error) {
+	if err := s.macro(cmdMail, "{mail_addr}", from); err != nil {
+		return nil, err
+	}
+	return s.step(cmdMail, cstring("<"+from+">"), protoNoMail, protoNoMailReply)
+}
+
+// Rcpt sends RCPT TO
+func (s *Session) Rcpt(to string) (*Response, error) {
+	if err := s.macro(cmdRcpt, "{rcpt_addr}", to); err != nil {
+		return nil, err
+	}
+	return s.step(cmdRcpt, cstring("<"+to+">"), protoNoRcpt, protoNoRcptReply)
+}
+
+// Header sends a single message header
+func (s *Session) Header(name, value string) (*Response, error) {
+	data := cstring(name)
+	data = append(data, cstring(strings.TrimLeft(value, " \t"))...)
+	return s.step(cmdHeader, data, protoNoHeaders, protoNoHeaderReply)
+}
+
+// EndOfHeaders notifies milter that all headers were sent
+func (s *Session) EndOfHeaders() (*Response, error) {
+	return s.step(cmdEOH, nil, protoNoEOH, protoNoEOHReply)
+}
+
+// Body sends message body (in chunks)
+func (s *Session) Body(body []byte) (*Response, error) {
+	for len(body) > 0 {
+		size := len(body)
+		if size > maxBodyChunk {
+			size = maxBodyChunk
+		}
+		resp, err := s.step(cmdBody, body[:size], protoNoBody, protoNoBodyReply)
+		if err != nil || resp.Action != ActionContinue {
+			return resp, err
+		}
+		body = body[size:]
+	}
+
+	return &Response{Action: ActionContinue}, nil
+}
+
+// EndOfMessage finishes the message and collects requested modifications
+func (s *Session) EndOfMessage() (*Response, *Modifications, error) {
+	mods := &Modifications{}
+	if s.verdict != nil {
+		return s.verdict, mods, nil
+	}
+	if err := s.write(cmdEOB, nil); err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		cmd, data, err := s.read()
+		if err != nil {
+			return nil, nil, err
+		}
+		if mods.parse(cmd, data, s.actions) {
+			continue
+		}
+		resp, err := s.response(cmd, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil { // progress
+			continue
+		}
+		s.verdict = resp
+		return resp, mods, nil
+	}
+}
+
+// Abort the current message, session can be reused for the next one
+func (s *Session) Abort() error {
+	s.verdict = nil
+	return s.write(cmdAbort, nil)
+}
+
+// Close the session
+func (s *Session) Close() error {
+	s.write(cmdQuit, nil) //nolint:errcheck // closing anyway
+	return s.conn.Close()
+}
+
+// step sends the command and reads response, unless milter asked to skip that step or its reply
+func (s *Session) step(cmd byte, data []byte, skipFlag, noReplyFlag uint32) (*Response, error) {
+	if s.verdict != nil || s.protocol&skipFlag != 0 {
+		return &Response{Action: ActionContinue}, nil
+	}
+	if err := s.write(cmd, data); err != nil {
+		return nil, err
+	}
+	if s.protocol&noReplyFlag != 0 {
+		return &Response{Action: ActionContinue}, nil
+	}
+
+	for {
+		rcmd, rdata, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.response(rcmd, rdata)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil { // progress
+			continue
+		}
+		if resp.Action == ActionAccept || resp.Action == ActionDiscard {
+			s.verdict = resp
+		}
+		return resp, nil
+	}
+}
+
+// response converts milter reply into verdict, nil means "in progress"
+func (s *Session) response(cmd byte, data []byte) (*Response, error) {
+	switch cmd {
+	case respContinue:
+		return &Response{Action: ActionContinue}, nil
+	case respAccept:
+		return &Response{Action: ActionAccept}, nil
+	case respDiscard:
+		return &Response{Action: ActionDiscard}, nil
+	case respReject:
+		return &Response{Action: ActionReject}, nil
+	case respTempFail:
+		return &Response{Action: ActionTempFail}, nil
+	case respReplyCode:
+		return parseReplyCode(string(bytes.TrimRight(data, "\x00"))), nil
+	case respProgress:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected milter response %q", cmd)
+	}
+}
+
+func (s *Session) macro(cmd byte, name, value string) error {
+	if s.verdict != nil {
+		return nil
+	}
+	data := []byte{cmd}
+	data = append(data, cstring(name)...)
+	data = append(data, cstring(value)...)
+	return s.write(cmdMacro, data)
+}
+
+func (s *Session) write(cmd byte, data []byte) error {
+	packet := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(packet, uint32(len(data)+1))
+	packet[4] = cmd
+	packet = append(packet, data...)
+
+	s.conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck // write will fail anyway
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+func (s *Session) read() (byte, []byte, error) {
+	s.conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck // read will fail anyway
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(s.conn, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size == 0 || size > 16*maxBodyChunk {
+		return 0, nil, fmt.Errorf("invalid milter packet size %d", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(s.conn, packet); err != nil {
+		return 0, nil, err
+	}
+
+	return packet[0], packet[1:], nil
+}
+
+// parseReplyCode parses custom SMTP reply, eg: 550 5.7.1 Spam message rejected
+func parseReplyCode(reply string) *Response {
+	resp := &Response{Action: ActionTempFail, Code: 451, Message: reply}
+	parts := strings.SplitN(reply, " ", 3)
+	code, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return resp
+	}
+	resp.Code = code
+	resp.Message = strings.Join(parts[1:], " ")
+	if code >= 500 {
+		resp.Action = ActionReject
+	}
+
+	if len(parts) > 1 {
+		enhanced := strings.Split(parts[1], ".")
+		if len(enhanced) == 3 {
+			for i, part := range enhanced {
+				resp.EnhancedCode[i], err = strconv.Atoi(part)
+				if err != nil {
+					resp.EnhancedCode = [3]int{}
+					return resp
+				}
+			}
+			resp.Message = strings.Join(parts[2:], " ")
+		}
+	}
+
+	return resp
+}
+
+func addrParts(addr net.Addr) (net.IP, uint16) {
+	switch netaddr := addr.(type) {
+	case *net.TCPAddr:
+		return netaddr.IP, uint16(netaddr.Port)
+	case nil:
+		return nil, 0
+	default:
+		host, port, _ := net.SplitHostPort(addr.String()) //nolint:errcheck // either way it's ok
+		portn, _ := strconv.Atoi(port)                    //nolint:errcheck // either way it's ok
+		return net.ParseIP(host), uint16(portn)
+	}
+}
+
+func cstring(str string) []byte {
+	return append([]byte(str), 0)
+}
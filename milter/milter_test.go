@@ -0,0 +1,188 @@
+package milter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		valid   bool
+	}{
+		{"inet:127.0.0.1:11332", "tcp", true},
+		{"inet6:[::1]:11332", "tcp6", true},
+		{"unix:/run/milter.sock", "unix", true},
+		{"localhost:8891", "tcp", true},
+		{"localhost", "", false},
+		{"unix:", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			client, err := New(test.address, time.Second)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("New(%q) error = nil, want error", test.address)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New(%q) error = %v", test.address, err)
+			}
+			if client.network != test.network {
+				t.Errorf("network = %q, want %q", client.network, test.network)
+			}
+		})
+	}
+}
+
+func TestParseReplyCode(t *testing.T) {
+	tests := []struct {
+		reply    string
+		action   Action
+		code     int
+		enhanced [3]int
+		message  string
+	}{
+		{"550 5.7.1 Spam message rejected", ActionReject, 550, [3]int{5, 7, 1}, "Spam message rejected"},
+		{"451 4.7.1 Try again later", ActionTempFail, 451, [3]int{4, 7, 1}, "Try again later"},
+		{"554 rejected", ActionReject, 554, [3]int{}, "rejected"},
+		{"garbage", ActionTempFail, 451, [3]int{}, "garbage"},
+	}
+	for _, test := range tests {
+		t.Run(test.reply, func(t *testing.T) {
+			resp := parseReplyCode(test.reply)
+			if resp.Action != test.action || resp.Code != test.code || resp.EnhancedCode != test.enhanced || resp.Message != test.message {
+				t.Errorf("parseReplyCode(%q) = %+v", test.reply, resp)
+			}
+		})
+	}
+}
+
+// fakeMilter reads packets from the MTA and replies with the scripted responses
+type fakeMilter struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func (f *fakeMilter) read() (byte, []byte) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(f.conn, header); err != nil {
+		f.t.Errorf("cannot read packet: %v", err)
+		return 0, nil
+	}
+	packet := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(f.conn, packet); err != nil {
+		f.t.Errorf("cannot read packet: %v", err)
+		return 0, nil
+	}
+	return packet[0], packet[1:]
+}
+
+func (f *fakeMilter) write(cmd byte, data []byte) {
+	packet := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(packet, uint32(len(data)+1))
+	packet[4] = cmd
+	if _, err := f.conn.Write(append(packet, data...)); err != nil {
+		f.t.Errorf("cannot write packet: %v", err)
+	}
+}
+
+func newTestSession(t *testing.T, actions, protocol uint32) (*Session, *fakeMilter) {
+	mta, milter := net.Pipe()
+	t.Cleanup(func() {
+		mta.Close()
+		milter.Close()
+	})
+	fake := &fakeMilter{t: t, conn: milter}
+	go func() {
+		cmd, data := fake.read()
+		if cmd != cmdOptNeg || len(data) != 12 {
+			t.Errorf("unexpected negotiation %q", cmd)
+		}
+		resp := make([]byte, 12)
+		binary.BigEndian.PutUint32(resp, protocolVersion)
+		binary.BigEndian.PutUint32(resp[4:], actions)
+		binary.BigEndian.PutUint32(resp[8:], protocol)
+		fake.write(respOptNeg, resp)
+	}()
+
+	session := &Session{conn: mta, timeout: time.Second}
+	if err := session.negotiate(); err != nil {
+		t.Fatalf("negotiate() error = %v", err)
+	}
+	return session, fake
+}
+
+func TestSessionNegotiate(t *testing.T) {
+	session, _ := newTestSession(t, actSupported|0x100, protoNoHelo|0x800000)
+	if session.actions != actSupported {
+		t.Errorf("actions = %#x, want %#x", session.actions, actSupported)
+	}
+	if session.protocol != protoNoHelo {
+		t.Errorf("protocol = %#x, want %#x", session.protocol, protoNoHelo)
+	}
+}
+
+func TestSessionSkippedStep(t *testing.T) {
+	session, _ := newTestSession(t, actSupported, protoNoHelo)
+	resp, err := session.Helo("mx.example.com") // nothing is sent, otherwise the pipe would block
+	if err != nil || resp.Action != ActionContinue {
+		t.Errorf("Helo() = %+v, %v", resp, err)
+	}
+}
+
+func TestSessionRcptReject(t *testing.T) {
+	session, fake := newTestSession(t, actSupported, 0)
+	go func() {
+		if cmd, _ := fake.read(); cmd != cmdMacro {
+			t.Errorf("expected macro, got %q", cmd)
+		}
+		if cmd, data := fake.read(); cmd != cmdRcpt || string(data) != "<rcpt@example.com>\x00" {
+			t.Errorf("unexpected rcpt packet %q %q", cmd, data)
+		}
+		fake.write(respProgress, nil)
+		fake.write(respReplyCode, []byte("550 5.1.1 No such user\x00"))
+	}()
+
+	resp, err := session.Rcpt("rcpt@example.com")
+	if err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	if resp.Action != ActionReject || resp.Code != 550 || resp.Message != "No such user" {
+		t.Errorf("Rcpt() = %+v", resp)
+	}
+}
+
+func TestSessionEndOfMessage(t *testing.T) {
+	session, fake := newTestSession(t, actSupported, 0)
+	go func() {
+		if cmd, _ := fake.read(); cmd != cmdEOB {
+			t.Errorf("expected end of body, got %q", cmd)
+		}
+		fake.write(respAddHeader, []byte("X-Spam\x00yes\x00"))
+		fake.write(respQuarantine, []byte("spam\x00"))
+		fake.write(respAccept, nil)
+	}()
+
+	resp, mods, err := session.EndOfMessage()
+	if err != nil {
+		t.Fatalf("EndOfMessage() error = %v", err)
+	}
+	if resp.Action != ActionAccept {
+		t.Errorf("action = %v, want accept", resp.Action)
+	}
+	if !mods.Changed() || mods.Quarantine != "spam" {
+		t.Errorf("modifications = %+v", mods)
+	}
+
+	// the verdict is final, milter doesn't see the rest of the message
+	resp, err = session.Body([]byte("body"))
+	if err != nil || resp.Action != ActionContinue {
+		t.Errorf("Body() after verdict = %+v, %v", resp, err)
+	}
+}
@@ -0,0 +1,78 @@
+package milter
+
+// protocol version, postfix and sendmail 8.14+ use v6
+const protocolVersion = 6
+
+// maxBodyChunk is the max size of a single body packet
+const maxBodyChunk = 65535
+
+// commands sent by MTA to milter
+const (
+	cmdAbort   = 'A'
+	cmdBody    = 'B'
+	cmdConnect = 'C'
+	cmdMacro   = 'D'
+	cmdEOB     = 'E'
+	cmdHelo    = 'H'
+	cmdHeader  = 'L'
+	cmdMail    = 'M'
+	cmdEOH     = 'N'
+	cmdOptNeg  = 'O'
+	cmdQuit    = 'Q'
+	cmdRcpt    = 'R'
+)
+
+// responses sent by milter to MTA
+const (
+	respAccept     = 'a'
+	respReplBody   = 'b'
+	respContinue   = 'c'
+	respDiscard    = 'd'
+	respAddHeader  = 'h'
+	respInsHeader  = 'i'
+	respChgHeader  = 'm'
+	respOptNeg     = 'O'
+	respProgress   = 'p'
+	respQuarantine = 'q'
+	respReject     = 'r'
+	respTempFail   = 't'
+	respReplyCode  = 'y'
+)
+
+// actions (modifications) postmoogle allows milters to perform
+const (
+	actAddHeader  uint32 = 0x01
+	actChgBody    uint32 = 0x02
+	actChgHeader  uint32 = 0x10
+	actQuarantine uint32 = 0x20
+
+	actSupported = actAddHeader | actChgBody | actChgHeader | actQuarantine
+)
+
+// protocol flags (steps milter may ask to skip or to not reply to)
+const (
+	protoNoConnect      uint32 = 0x01
+	protoNoHelo         uint32 = 0x02
+	protoNoMail         uint32 = 0x04
+	protoNoRcpt         uint32 = 0x08
+	protoNoBody         uint32 = 0x10
+	protoNoHeaders      uint32 = 0x20
+	protoNoEOH          uint32 = 0x40
+	protoNoHeaderReply  uint32 = 0x80
+	protoNoUnknown      uint32 = 0x100
+	protoNoData         uint32 = 0x200
+	protoNoConnectReply uint32 = 0x1000
+	protoNoHeloReply    uint32 = 0x2000
+	protoNoMailReply    uint32 = 0x4000
+	protoNoRcptReply    uint32 = 0x8000
+	protoNoDataReply    uint32 = 0x10000
+	protoNoUnknownReply uint32 = 0x20000
+	protoNoEOHReply     uint32 = 0x40000
+	protoNoBodyReply    uint32 = 0x80000
+
+	protoSupported = protoNoConnect | protoNoHelo | protoNoMail | protoNoRcpt |
+		protoNoBody | protoNoHeaders | protoNoEOH | protoNoHeaderReply |
+		protoNoUnknown | protoNoData | protoNoConnectReply | protoNoHeloReply |
+		protoNoMailReply | protoNoRcptReply | protoNoDataReply | protoNoUnknownReply |
+		protoNoEOHReply | protoNoBodyReply
+)
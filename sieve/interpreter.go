@@ -0,0 +1,570 @@
+package sieve
+
+import (
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxMatchVariables is the count of ${0}..${9} match variables
+const maxMatchVariables = 10
+
+// internalFlags is the name of imap4flags' internal variable
+const internalFlags = "__flags__"
+
+// Message is an email the script is evaluated against
+type Message struct {
+	Header       textproto.MIMEHeader
+	EnvelopeFrom string
+	EnvelopeTo   string
+	Text         string
+	HTML         string
+	Raw          []byte
+}
+
+// Folder is a target of the fileinto action
+type Folder struct {
+	Name  string
+	Flags []string
+}
+
+// Vacation is an auto-response to the sender
+type Vacation struct {
+	Days      int
+	Subject   string
+	From      string
+	Addresses []string
+	Mime      bool
+	Handle    string
+	Reason    string
+}
+
+// Result of the script evaluation
+type Result struct {
+	// Keep is true if the message should be posted to the room (explicitly or implicitly)
+	Keep bool
+	// Flags of the kept message
+	Flags []string
+	// FileInto folders the message should be delivered to
+	FileInto []*Folder
+	// Redirect addresses the message should be forwarded to
+	Redirect []string
+	// Reject reason, if the message should be rejected
+	Reject string
+	// Vacation response, if any
+	Vacation *Vacation
+}
+
+type runtime struct {
+	script    *Script
+	msg       *Message
+	result    *Result
+	vars      map[string]string
+	matchVars []string
+	implicit  bool
+	explicit  bool
+	stopped   bool
+	regexps   map[string]*regexp.Regexp
+}
+
+// Run evaluates the script against the message.
+// On runtime errors the result contains implicit keep, as required by RFC 5228
+func (s *Script) Run(msg *Message) (*Result, error) {
+	r := &runtime{
+		script:   s,
+		msg:      msg,
+		result:   &Result{},
+		vars:     map[string]string{},
+		implicit: true,
+		regexps:  map[string]*regexp.Regexp{},
+	}
+	if err := r.block(s.commands); err != nil {
+		return &Result{Keep: true}, err
+	}
+	if r.implicit || r.explicit {
+		r.result.Keep = true
+		if !r.explicit {
+			r.result.Flags = r.flags(internalFlags)
+		}
+	}
+
+	return r.result, nil
+}
+
+func (r *runtime) block(commands []*command) error {
+	var matched bool
+	for _, cmd := range commands {
+		if r.stopped {
+			return nil
+		}
+		var err error
+		switch cmd.name {
+		case "if":
+			if matched, err = r.test(cmd.test); err == nil && matched {
+				err = r.block(cmd.block)
+			}
+		case "elsif":
+			if matched {
+				continue
+			}
+			if matched, err = r.test(cmd.test); err == nil && matched {
+				err = r.block(cmd.block)
+			}
+		case "else":
+			if !matched {
+				err = r.block(cmd.block)
+			}
+		default:
+			err = r.command(cmd)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *runtime) command(cmd *command) error {
+	args, err := r.script.parseArgs(commandSpecs[cmd.name], cmd.name, cmd.line, cmd.args)
+	if err != nil {
+		return err
+	}
+
+	switch cmd.name {
+	case "stop":
+		r.stopped = true
+	case "keep":
+		r.explicit = true
+		r.result.Flags = r.flags(internalFlags)
+		if args.has("flags") {
+			r.result.Flags = splitFlags(r.expandList(args.tags["flags"].strs))
+		}
+	case "discard":
+		r.implicit = false
+	case "redirect":
+		address := r.expand(args.positional[0].strs[0])
+		if _, err := mail.ParseAddress(address); err != nil {
+			return newError(cmd.line, "invalid redirect address %q", address)
+		}
+		r.result.Redirect = append(r.result.Redirect, address)
+		if !args.has("copy") {
+			r.implicit = false
+		}
+	case "fileinto":
+		folder := &Folder{Name: r.expand(args.positional[0].strs[0]), Flags: r.flags(internalFlags)}
+		if args.has("flags") {
+			folder.Flags = splitFlags(r.expandList(args.tags["flags"].strs))
+		}
+		r.result.FileInto = append(r.result.FileInto, folder)
+		if !args.has("copy") {
+			r.implicit = false
+		}
+	case "reject", "ereject":
+		r.result.Reject = r.expand(args.positional[0].strs[0])
+		r.implicit = false
+	case "set":
+		r.set(args)
+	case "setflag", "addflag", "removeflag":
+		r.setflag(cmd.name, args)
+	case "vacation":
+		return r.vacation(cmd, args)
+	}
+
+	return nil
+}
+
+// set implements the variables extension, modifiers are applied in order of their precedence
+func (r *runtime) set(args *parsedArgs) {
+	name := strings.ToLower(args.positional[0].strs[0])
+	value := r.expand(args.positional[1].strs[0])
+	switch {
+	case args.has("lower"):
+		value = strings.ToLower(value)
+	case args.has("upper"):
+		value = strings.ToUpper(value)
+	}
+	if value != "" {
+		switch {
+		case args.has("lowerfirst"):
+			value = strings.ToLower(value[:1]) + value[1:]
+		case args.has("upperfirst"):
+			value = strings.ToUpper(value[:1]) + value[1:]
+		}
+	}
+	if args.has("quotewildcard") {
+		value = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(value)
+	}
+	if args.has("length") {
+		value = strconv.Itoa(len([]rune(value)))
+	}
+	r.vars[name] = value
+}
+
+func (r *runtime) setflag(name string, args *parsedArgs) {
+	variable := internalFlags
+	list := args.positional[0]
+	if len(args.positional) == 2 {
+		variable = strings.ToLower(args.positional[0].strs[0])
+		list = args.positional[1]
+	}
+	flags := splitFlags(r.expandList(list.strs))
+
+	switch name {
+	case "setflag":
+		r.vars[variable] = strings.Join(uniqueFlags(flags), " ")
+	case "addflag":
+		r.vars[variable] = strings.Join(uniqueFlags(append(r.flags(variable), flags...)), " ")
+	case "removeflag":
+		remove := map[string]bool{}
+		for _, flag := range flags {
+			remove[strings.ToLower(flag)] = true
+		}
+		kept := []string{}
+		for _, flag := range r.flags(variable) {
+			if !remove[strings.ToLower(flag)] {
+				kept = append(kept, flag)
+			}
+		}
+		r.vars[variable] = strings.Join(kept, " ")
+	}
+}
+
+func (r *runtime) vacation(cmd *command, args *parsedArgs) error {
+	vacation := &Vacation{Days: 7, Reason: r.expand(args.positional[0].strs[0]), Mime: args.has("mime")}
+	if days, ok := args.tags["days"]; ok {
+		if days.kind != argNumber {
+			return newError(cmd.line, "vacation: number expected after :days")
+		}
+		vacation.Days = days.num
+		if vacation.Days < 1 {
+			vacation.Days = 1
+		}
+	}
+	for tag, value := range map[string]*string{"subject": &vacation.Subject, "from": &vacation.From, "handle": &vacation.Handle} {
+		if a, ok := args.tags[tag]; ok {
+			if a.kind != argStrings {
+				return newError(cmd.line, "vacation: string expected after :%s", tag)
+			}
+			*value = r.expand(a.strs[0])
+		}
+	}
+	if a, ok := args.tags["addresses"]; ok {
+		if a.kind != argStrings {
+			return newError(cmd.line, "vacation: string list expected after :addresses")
+		}
+		vacation.Addresses = r.expandList(a.strs)
+	}
+	if vacation.Handle == "" {
+		vacation.Handle = vacation.Subject + "|" + vacation.From + "|" + vacation.Reason
+	}
+	r.result.Vacation = vacation
+	return nil
+}
+
+func (r *runtime) test(t *test) (bool, error) {
+	args, err := r.script.parseArgs(testSpecs[t.name], t.name, t.line, t.args)
+	if err != nil {
+		return false, err
+	}
+
+	switch t.name {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "not":
+		ok, err := r.test(t.tests[0])
+		return !ok, err
+	case "allof":
+		for _, sub := range t.tests {
+			ok, err := r.test(sub)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case "anyof":
+		for _, sub := range t.tests {
+			ok, err := r.test(sub)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case "exists":
+		for _, name := range r.expandList(args.positional[0].strs) {
+			if len(r.msg.Header.Values(name)) == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "size":
+		size := len(r.msg.Raw)
+		if over, ok := args.tags["over"]; ok && over.kind == argNumber {
+			return size > over.num, nil
+		}
+		if under, ok := args.tags["under"]; ok && under.kind == argNumber {
+			return size < under.num, nil
+		}
+		return false, newError(t.line, "size: :over or :under with a number expected")
+	case "header":
+		return r.match(args, r.headers(r.expandList(args.positional[0].strs))), nil
+	case "address":
+		values := []string{}
+		for _, header := range r.headers(r.expandList(args.positional[0].strs)) {
+			values = append(values, addresses(header, args)...)
+		}
+		return r.match(args, values), nil
+	case "envelope":
+		values := []string{}
+		for _, part := range r.expandList(args.positional[0].strs) {
+			switch strings.ToLower(part) {
+			case "from":
+				values = append(values, addresses(r.msg.EnvelopeFrom, args)...)
+			case "to":
+				values = append(values, addresses(r.msg.EnvelopeTo, args)...)
+			}
+		}
+		return r.match(args, values), nil
+	case "body":
+		return r.match(args, r.body(args)), nil
+	case "string":
+		return r.match(args, r.expandList(args.positional[0].strs)), nil
+	case "hasflag":
+		flags := r.flags(internalFlags)
+		if len(args.positional) == 2 {
+			flags = []string{}
+			for _, variable := range args.positional[0].strs {
+				flags = append(flags, r.flags(strings.ToLower(variable))...)
+			}
+		}
+		return r.match(args, flags), nil
+	}
+
+	return false, newError(t.line, "unknown test %q", t.name)
+}
+
+// headers returns decoded values of the headers
+func (r *runtime) headers(names []string) []string {
+	decoder := new(mime.WordDecoder)
+	values := []string{}
+	for _, name := range names {
+		for _, value := range r.msg.Header.Values(name) {
+			decoded, err := decoder.DecodeHeader(value)
+			if err != nil {
+				decoded = value
+			}
+			values = append(values, strings.TrimSpace(decoded))
+		}
+	}
+	return values
+}
+
+// body returns parts of the body according to the transform
+func (r *runtime) body(args *parsedArgs) []string {
+	if args.has("raw") {
+		return []string{string(r.msg.Raw)}
+	}
+	content, ok := args.tags["content"]
+	if !ok {
+		if r.msg.Text == "" {
+			return []string{r.msg.HTML}
+		}
+		return []string{r.msg.Text}
+	}
+
+	values := []string{}
+	for _, ctype := range r.expandList(content.strs) {
+		ctype = strings.ToLower(ctype)
+		if ctype == "" || ctype == "text" || ctype == "text/plain" {
+			values = append(values, r.msg.Text)
+		}
+		if ctype == "" || ctype == "text" || ctype == "text/html" {
+			values = append(values, r.msg.HTML)
+		}
+	}
+	return values
+}
+
+// addresses parses address list and returns the requested parts of each address
+func addresses(value string, args *parsedArgs) []string {
+	list := []string{}
+	parsed, err := mail.ParseAddressList(value)
+	if err != nil || len(parsed) == 0 {
+		list = append(list, strings.Trim(strings.TrimSpace(value), "<>"))
+	} else {
+		for _, addr := range parsed {
+			list = append(list, addr.Address)
+		}
+	}
+
+	for i, addr := range list {
+		index := strings.LastIndex(addr, "@")
+		switch {
+		case args.has("localpart"):
+			if index != -1 {
+				list[i] = addr[:index]
+			}
+		case args.has("domain"):
+			list[i] = addr[index+1:]
+		}
+	}
+	return list
+}
+
+// match compares values with the keys using match type and comparator of the test
+func (r *runtime) match(args *parsedArgs, values []string) bool {
+	keys := r.expandList(args.positional[len(args.positional)-1].strs)
+	octet := false
+	if comparator, ok := args.tags["comparator"]; ok {
+		octet = comparator.strs[0] == "i;octet"
+	}
+
+	for _, value := range values {
+		for _, key := range keys {
+			switch {
+			case args.has("contains"):
+				if octet && strings.Contains(value, key) || !octet && strings.Contains(strings.ToLower(value), strings.ToLower(key)) {
+					return true
+				}
+			case args.has("matches"):
+				if groups := r.wildcard(key, octet).FindStringSubmatch(value); groups != nil {
+					if r.script.requires["variables"] {
+						r.matchVars = groups
+					}
+					return true
+				}
+			default:
+				if octet && value == key || !octet && strings.EqualFold(value, key) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// wildcard converts :matches pattern into a regular expression with groups for match variables
+func (r *runtime) wildcard(pattern string, octet bool) *regexp.Regexp {
+	cacheKey := strconv.FormatBool(octet) + pattern
+	if re, ok := r.regexps[cacheKey]; ok {
+		return re
+	}
+
+	var expr strings.Builder
+	expr.WriteString("(?s)")
+	if !octet {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			expr.WriteString("(.*?)")
+		case '?':
+			expr.WriteString("(.)")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	re := regexp.MustCompile(expr.String())
+	r.regexps[cacheKey] = re
+	return re
+}
+
+// expand substitutes ${variable} and ${N} (match variables) within the string, if variables extension is used
+func (r *runtime) expand(str string) string {
+	if !r.script.requires["variables"] || !strings.Contains(str, "${") {
+		return str
+	}
+
+	var out strings.Builder
+	for {
+		start := strings.Index(str, "${")
+		if start == -1 {
+			out.WriteString(str)
+			return out.String()
+		}
+		end := strings.IndexByte(str[start:], '}')
+		if end == -1 {
+			out.WriteString(str)
+			return out.String()
+		}
+		name := str[start+2 : start+end]
+		value, ok := r.variable(name)
+		out.WriteString(str[:start])
+		if ok {
+			out.WriteString(value)
+		} else {
+			out.WriteString(str[start : start+end+1])
+		}
+		str = str[start+end+1:]
+	}
+}
+
+// variable returns value of the variable, ok=false if the name is not a valid variable name
+func (r *runtime) variable(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	if index, err := strconv.Atoi(name); err == nil {
+		if index < 0 || index >= maxMatchVariables {
+			return "", false
+		}
+		if index < len(r.matchVars) {
+			return r.matchVars[index], true
+		}
+		return "", true
+	}
+	for i := 0; i < len(name); i++ {
+		if !isIdentifierStart(name[i]) && !isDigit(name[i]) && name[i] != '.' {
+			return "", false
+		}
+	}
+	return r.vars[strings.ToLower(name)], true
+}
+
+func (r *runtime) expandList(list []string) []string {
+	expanded := make([]string, 0, len(list))
+	for _, item := range list {
+		expanded = append(expanded, r.expand(item))
+	}
+	return expanded
+}
+
+// flags returns flags stored in the variable
+func (r *runtime) flags(variable string) []string {
+	return splitFlags([]string{r.vars[variable]})
+}
+
+func splitFlags(list []string) []string {
+	flags := []string{}
+	for _, item := range list {
+		flags = append(flags, strings.Fields(item)...)
+	}
+	return uniqueFlags(flags)
+}
+
+func uniqueFlags(flags []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		key := strings.ToLower(flag)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, flag)
+	}
+	return unique
+}
@@ -0,0 +1,219 @@
+package sieve
+
+import (
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenTag
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  int
+	line int
+}
+
+// lexer splits RFC 5228 script into tokens
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: strings.ReplaceAll(src, "\r\n", "\n"), line: 1}
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return newError(l.line, format, args...)
+}
+
+// tokens returns all tokens of the script
+func (l *lexer) tokens() ([]token, error) {
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, line: l.line}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte(";,()[]{}", c) != -1:
+		l.pos++
+		return token{kind: tokenPunct, text: string(c), line: l.line}, nil
+	case c == '"':
+		return l.quoted()
+	case c == ':':
+		l.pos++
+		name := l.identifier()
+		if name == "" {
+			return token{}, l.errorf("tag name expected after ':'")
+		}
+		return token{kind: tokenTag, text: strings.ToLower(name), line: l.line}, nil
+	case isDigit(c):
+		return l.number()
+	case isIdentifierStart(c):
+		name := l.identifier()
+		if strings.EqualFold(name, "text") && l.pos < len(l.src) && l.src[l.pos] == ':' {
+			l.pos++
+			return l.multiline()
+		}
+		return token{kind: tokenIdentifier, text: strings.ToLower(name), line: l.line}, nil
+	}
+
+	return token{}, l.errorf("unexpected character %q", c)
+}
+
+// skip skips whitespace and comments
+func (l *lexer) skip() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			end := strings.IndexByte(l.src[l.pos:], '\n')
+			if end == -1 {
+				l.pos = len(l.src)
+				return nil
+			}
+			l.pos += end
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end == -1 {
+				return l.errorf("unterminated comment")
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) identifier() string {
+	start := l.pos
+	for l.pos < len(l.src) && (isIdentifierStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	num, err := strconv.Atoi(l.src[start:l.pos])
+	if err != nil {
+		return token{}, l.errorf("invalid number %q", l.src[start:l.pos])
+	}
+	if l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case 'K', 'k':
+			num *= 1 << 10
+			l.pos++
+		case 'M', 'm':
+			num *= 1 << 20
+			l.pos++
+		case 'G', 'g':
+			num *= 1 << 30
+			l.pos++
+		}
+	}
+	return token{kind: tokenNumber, num: num, line: l.line}, nil
+}
+
+func (l *lexer) quoted() (token, error) {
+	line := l.line
+	l.pos++ // opening quote
+	var str strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return token{kind: tokenString, text: str.String(), line: line}, nil
+		case '\\':
+			l.pos++
+			if l.pos < len(l.src) {
+				str.WriteByte(l.src[l.pos])
+			}
+		case '\n':
+			l.line++
+			str.WriteByte(c)
+		default:
+			str.WriteByte(c)
+		}
+		l.pos++
+	}
+	return token{}, newError(line, "unterminated string")
+}
+
+// multiline reads "text:" string, terminated by a line containing a single dot
+func (l *lexer) multiline() (token, error) {
+	line := l.line
+	end := strings.IndexByte(l.src[l.pos:], '\n')
+	if end == -1 {
+		return token{}, l.errorf("unterminated multi-line string")
+	}
+	l.pos += end + 1
+	l.line++
+
+	var str strings.Builder
+	for l.pos < len(l.src) {
+		end = strings.IndexByte(l.src[l.pos:], '\n')
+		var text string
+		if end == -1 {
+			text = l.src[l.pos:]
+			l.pos = len(l.src)
+		} else {
+			text = l.src[l.pos : l.pos+end]
+			l.pos += end + 1
+		}
+		l.line++
+		if strings.TrimRight(text, "\r") == "." {
+			return token{kind: tokenString, text: str.String(), line: line}, nil
+		}
+		str.WriteString(strings.TrimPrefix(text, "."))
+		str.WriteByte('\n')
+	}
+	return token{}, newError(line, "unterminated multi-line string")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,402 @@
+package sieve
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is a syntax or runtime error of the script
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func newError(line int, format string, args ...any) *Error {
+	return &Error{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type argKind int
+
+const (
+	argTag argKind = iota
+	argNumber
+	argStrings
+)
+
+// arg is a positional or tagged argument of a command or test
+type arg struct {
+	kind   argKind
+	tag    string
+	num    int
+	strs   []string
+	isList bool
+	line   int
+}
+
+type test struct {
+	name  string
+	args  []arg
+	tests []*test
+	line  int
+}
+
+type command struct {
+	name  string
+	args  []arg
+	test  *test
+	block []*command
+	line  int
+}
+
+// Script is a parsed and validated Sieve script
+type Script struct {
+	commands []*command
+	requires map[string]bool
+}
+
+// capabilities supported by the interpreter
+var capabilities = map[string]bool{
+	"fileinto":                   true,
+	"reject":                     true,
+	"ereject":                    true,
+	"envelope":                   true,
+	"body":                       true,
+	"variables":                  true,
+	"imap4flags":                 true,
+	"vacation":                   true,
+	"copy":                       true,
+	"comparator-i;octet":         true,
+	"comparator-i;ascii-casemap": true,
+}
+
+// commands and tests with the capability they require (empty = core)
+var (
+	knownCommands = map[string]string{
+		"require":    "",
+		"if":         "",
+		"elsif":      "",
+		"else":       "",
+		"stop":       "",
+		"keep":       "",
+		"discard":    "",
+		"redirect":   "",
+		"fileinto":   "fileinto",
+		"reject":     "reject",
+		"ereject":    "ereject",
+		"set":        "variables",
+		"setflag":    "imap4flags",
+		"addflag":    "imap4flags",
+		"removeflag": "imap4flags",
+		"vacation":   "vacation",
+	}
+	knownTests = map[string]string{
+		"address":  "",
+		"allof":    "",
+		"anyof":    "",
+		"exists":   "",
+		"false":    "",
+		"header":   "",
+		"not":      "",
+		"size":     "",
+		"true":     "",
+		"envelope": "envelope",
+		"body":     "body",
+		"string":   "variables",
+		"hasflag":  "imap4flags",
+	}
+)
+
+// Parse parses and validates the script
+func Parse(src string) (*Script, error) {
+	tokens, err := newLexer(src).tokens()
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	commands, err := p.commands(false)
+	if err != nil {
+		return nil, err
+	}
+	script := &Script{commands: commands, requires: map[string]bool{}}
+	if err := script.validate(commands, true); err != nil {
+		return nil, err
+	}
+
+	return script, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isPunct(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokenPunct && tok.text == text
+}
+
+func (p *parser) expect(text string) error {
+	tok := p.advance()
+	if tok.kind != tokenPunct || tok.text != text {
+		return newError(tok.line, "%q expected", text)
+	}
+	return nil
+}
+
+func (p *parser) commands(inBlock bool) ([]*command, error) {
+	var commands []*command
+	for {
+		tok := p.peek()
+		if tok.kind == tokenEOF {
+			if inBlock {
+				return nil, newError(tok.line, "\"}\" expected")
+			}
+			return commands, nil
+		}
+		if inBlock && p.isPunct("}") {
+			p.advance()
+			return commands, nil
+		}
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+}
+
+func (p *parser) command() (*command, error) {
+	tok := p.advance()
+	if tok.kind != tokenIdentifier {
+		return nil, newError(tok.line, "command expected")
+	}
+	cmd := &command{name: tok.text, line: tok.line}
+	args, err := p.arguments()
+	if err != nil {
+		return nil, err
+	}
+	cmd.args = args
+	if p.peek().kind == tokenIdentifier {
+		cmd.test, err = p.test()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case p.isPunct(";"):
+		p.advance()
+	case p.isPunct("{"):
+		p.advance()
+		cmd.block, err = p.commands(true)
+		if err != nil {
+			return nil, err
+		}
+		if cmd.block == nil {
+			cmd.block = []*command{}
+		}
+	default:
+		return nil, newError(p.peek().line, "\";\" or block expected after %q", cmd.name)
+	}
+
+	return cmd, nil
+}
+
+func (p *parser) test() (*test, error) {
+	tok := p.advance()
+	if tok.kind != tokenIdentifier {
+		return nil, newError(tok.line, "test expected")
+	}
+	t := &test{name: tok.text, line: tok.line}
+	args, err := p.arguments()
+	if err != nil {
+		return nil, err
+	}
+	t.args = args
+
+	switch {
+	case p.isPunct("("):
+		p.advance()
+		for {
+			sub, err := p.test()
+			if err != nil {
+				return nil, err
+			}
+			t.tests = append(t.tests, sub)
+			if p.isPunct(",") {
+				p.advance()
+				continue
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	case p.peek().kind == tokenIdentifier:
+		sub, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		t.tests = append(t.tests, sub)
+	}
+
+	return t, nil
+}
+
+func (p *parser) arguments() ([]arg, error) {
+	var args []arg
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == tokenTag:
+			p.advance()
+			args = append(args, arg{kind: argTag, tag: tok.text, line: tok.line})
+		case tok.kind == tokenNumber:
+			p.advance()
+			args = append(args, arg{kind: argNumber, num: tok.num, line: tok.line})
+		case tok.kind == tokenString:
+			p.advance()
+			args = append(args, arg{kind: argStrings, strs: []string{tok.text}, line: tok.line})
+		case p.isPunct("["):
+			p.advance()
+			list := arg{kind: argStrings, isList: true, line: tok.line}
+			for {
+				str := p.advance()
+				if str.kind != tokenString {
+					return nil, newError(str.line, "string expected in the list")
+				}
+				list.strs = append(list.strs, str.text)
+				if p.isPunct(",") {
+					p.advance()
+					continue
+				}
+				if err := p.expect("]"); err != nil {
+					return nil, err
+				}
+				break
+			}
+			args = append(args, list)
+		default:
+			return args, nil
+		}
+	}
+}
+
+// validate checks command and test names, required capabilities and control structure
+func (s *Script) validate(commands []*command, topLevel bool) error {
+	requiresAllowed := topLevel
+	var prev string
+	for _, cmd := range commands {
+		capability, ok := knownCommands[cmd.name]
+		if !ok {
+			return newError(cmd.line, "unknown command %q", cmd.name)
+		}
+		if capability != "" && !s.requires[capability] {
+			return newError(cmd.line, "%q requires the %q capability, add `require \"%s\";`", cmd.name, capability, capability)
+		}
+
+		if _, err := s.parseArgs(commandSpecs[cmd.name], cmd.name, cmd.line, cmd.args); err != nil {
+			return err
+		}
+		switch cmd.name {
+		case "require":
+			if !requiresAllowed {
+				return newError(cmd.line, "require is allowed only at the beginning of the script")
+			}
+			for _, name := range cmd.args[0].strs {
+				name = strings.ToLower(name)
+				if !capabilities[name] {
+					return newError(cmd.line, "unsupported capability %q", name)
+				}
+				s.requires[name] = true
+			}
+		case "if", "elsif", "else":
+			if cmd.name != "if" && prev != "if" && prev != "elsif" {
+				return newError(cmd.line, "%q without \"if\"", cmd.name)
+			}
+			if cmd.name == "else" && cmd.test != nil {
+				return newError(cmd.line, "\"else\" doesn't accept a test")
+			}
+			if cmd.name != "else" && cmd.test == nil {
+				return newError(cmd.line, "%q requires a test", cmd.name)
+			}
+			if cmd.block == nil {
+				return newError(cmd.line, "%q requires a block", cmd.name)
+			}
+			if cmd.test != nil {
+				if err := s.validateTest(cmd.test); err != nil {
+					return err
+				}
+			}
+			if err := s.validate(cmd.block, false); err != nil {
+				return err
+			}
+		default:
+			if cmd.block != nil {
+				return newError(cmd.line, "%q doesn't accept a block", cmd.name)
+			}
+			if cmd.test != nil {
+				return newError(cmd.line, "%q doesn't accept a test", cmd.name)
+			}
+		}
+		if cmd.name != "require" {
+			requiresAllowed = false
+		}
+		prev = cmd.name
+	}
+
+	return nil
+}
+
+func (s *Script) validateTest(t *test) error {
+	capability, ok := knownTests[t.name]
+	if !ok {
+		return newError(t.line, "unknown test %q", t.name)
+	}
+	if capability != "" && !s.requires[capability] {
+		return newError(t.line, "%q requires the %q capability, add `require \"%s\";`", t.name, capability, capability)
+	}
+	if _, err := s.parseArgs(testSpecs[t.name], t.name, t.line, t.args); err != nil {
+		return err
+	}
+	switch t.name {
+	case "allof", "anyof":
+		if len(t.tests) == 0 {
+			return newError(t.line, "%q requires a test list", t.name)
+		}
+	case "not":
+		if len(t.tests) != 1 {
+			return newError(t.line, "\"not\" requires a single test")
+		}
+	default:
+		if len(t.tests) != 0 {
+			return newError(t.line, "%q doesn't accept nested tests", t.name)
+		}
+	}
+	for _, sub := range t.tests {
+		if err := s.validateTest(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
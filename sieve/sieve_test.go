@@ -0,0 +1,398 @@
+package sieve
+
+import (
+	"errors"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testMessage() *Message {
+	return &Message{
+		Header: textproto.MIMEHeader{
+			"From":     {`"Jane Doe" <Jane.Doe@Example.com>`},
+			"To":       {"team@example.org, boss@example.org"},
+			"Subject":  {"[URGENT] Server down"},
+			"X-Spam":   {"yes"},
+			"X-Folder": {"=?UTF-8?Q?Caf=C3=A9?="},
+		},
+		EnvelopeFrom: "bounce@example.com",
+		EnvelopeTo:   "team@example.org",
+		Text:         "The server is down since 10:00",
+		HTML:         "<p>The server is <b>down</b></p>",
+		Raw:          []byte(strings.Repeat("x", 2048)),
+	}
+}
+
+func run(t *testing.T, src string) *Result {
+	t.Helper()
+	script, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	result, err := script.Run(testMessage())
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	return result
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+	}{
+		{name: "unknown command", src: "frobnicate;", line: 1},
+		{name: "missing capability", src: "fileinto \"spam\";", line: 1},
+		{name: "unsupported capability", src: "require \"notify\";", line: 1},
+		{name: "require after command", src: "keep;\nrequire \"fileinto\";", line: 2},
+		{name: "missing semicolon", src: "keep\ndiscard;", line: 1},
+		{name: "unterminated string", src: "if header :is \"subject\" \"x {\nkeep;\n}", line: 1},
+		{name: "unterminated multi-line string", src: "require \"reject\";\nreject text:\nno end", line: 2},
+		{name: "else without if", src: "else { keep; }", line: 1},
+		{name: "if without test", src: "if { keep; }", line: 1},
+		{name: "unknown test", src: "if spam { discard; }", line: 1},
+		{name: "unknown tag", src: "if header :regex \"subject\" \"x\" { discard; }", line: 1},
+		{name: "conflicting match types", src: "if header :is :contains \"subject\" \"x\" { discard; }", line: 1},
+		{name: "unsupported comparator", src: "if header :comparator \"i;unicode-casemap\" \"subject\" \"x\" { discard; }", line: 1},
+		{name: "missing argument", src: "if header \"subject\" { discard; }", line: 1},
+		{name: "tag after positional", src: "if header \"subject\" :is \"x\" { discard; }", line: 1},
+		{name: "not with two tests", src: "if not (true, false) { discard; }", line: 1},
+		{name: "empty anyof", src: "if anyof () { discard; }", line: 1},
+		{name: "tag requires capability", src: "require \"fileinto\";\nfileinto :copy \"spam\";", line: 2},
+		{name: "command with block", src: "keep { discard; }", line: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.src)
+			if err == nil {
+				t.Fatal("Parse() = nil, want error")
+			}
+			var serr *Error
+			if !errors.As(err, &serr) {
+				t.Fatalf("Parse() = %T, want *Error", err)
+			}
+			if serr.Line != test.line {
+				t.Errorf("error line = %d, want %d (%v)", serr.Line, test.line, err)
+			}
+		})
+	}
+}
+
+func TestMatchTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		test  string
+		match bool
+	}{
+		{name: "is", test: `header :is "x-spam" "yes"`, match: true},
+		{name: "is is case-insensitive", test: `header :is "x-spam" "YES"`, match: true},
+		{name: "is whole value", test: `header :is "subject" "server down"`, match: false},
+		{name: "is by default", test: `header "x-spam" "yes"`, match: true},
+		{name: "contains", test: `header :contains "subject" "server"`, match: true},
+		{name: "contains any key", test: `header :contains "subject" ["nope", "down"]`, match: true},
+		{name: "contains any header", test: `header :contains ["x-none", "subject"] "urgent"`, match: true},
+		{name: "contains no match", test: `header :contains "subject" "up"`, match: false},
+		{name: "matches", test: `header :matches "subject" "*urgent*"`, match: true},
+		{name: "matches single character", test: `header :matches "x-spam" "y?s"`, match: true},
+		{name: "matches whole value", test: `header :matches "subject" "urgent*"`, match: false},
+		{name: "matches escaped wildcard", test: `header :matches "subject" "\\[URGENT\\]*"`, match: true},
+		{name: "decoded header", test: `header :is "x-folder" "café"`, match: true},
+		{name: "missing header", test: `header :contains "x-none" ""`, match: false},
+		{name: "address all", test: `address :is "from" "jane.doe@example.com"`, match: true},
+		{name: "address localpart", test: `address :localpart :is "from" "jane.doe"`, match: true},
+		{name: "address domain", test: `address :domain :is "to" "example.org"`, match: true},
+		{name: "address list", test: `address :is "to" "boss@example.org"`, match: true},
+		{name: "exists", test: `exists ["from", "x-spam"]`, match: true},
+		{name: "exists all", test: `exists ["from", "x-none"]`, match: false},
+		{name: "not", test: `not exists "x-none"`, match: true},
+		{name: "allof", test: `allof (exists "from", header :is "x-spam" "no")`, match: false},
+		{name: "anyof", test: `anyof (false, header :is "x-spam" "yes")`, match: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := run(t, "if "+test.test+" { discard; }")
+			if matched := !result.Keep; matched != test.match {
+				t.Errorf("%s = %t, want %t", test.test, matched, test.match)
+			}
+		})
+	}
+}
+
+func TestComparators(t *testing.T) {
+	tests := []struct {
+		name       string
+		comparator string
+		test       string
+		match      bool
+	}{
+		{name: "ascii-casemap is", comparator: "i;ascii-casemap", test: `:is "x-spam" "YES"`, match: true},
+		{name: "octet is", comparator: "i;octet", test: `:is "x-spam" "YES"`, match: false},
+		{name: "octet is exact", comparator: "i;octet", test: `:is "x-spam" "yes"`, match: true},
+		{name: "ascii-casemap contains", comparator: "i;ascii-casemap", test: `:contains "subject" "urgent"`, match: true},
+		{name: "octet contains", comparator: "i;octet", test: `:contains "subject" "urgent"`, match: false},
+		{name: "ascii-casemap matches", comparator: "i;ascii-casemap", test: `:matches "subject" "*SERVER*"`, match: true},
+		{name: "octet matches", comparator: "i;octet", test: `:matches "subject" "*SERVER*"`, match: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := `require "comparator-` + test.comparator + `";` + "\n" +
+				`if header :comparator "` + test.comparator + `" ` + test.test + ` { discard; }`
+			result := run(t, src)
+			if matched := !result.Keep; matched != test.match {
+				t.Errorf("%s = %t, want %t", test.test, matched, test.match)
+			}
+		})
+	}
+}
+
+func TestEnvelopeAndBody(t *testing.T) {
+	tests := []struct {
+		name  string
+		test  string
+		match bool
+	}{
+		{name: "envelope from", test: `envelope :is "from" "bounce@example.com"`, match: true},
+		{name: "envelope to domain", test: `envelope :domain :is "to" "example.org"`, match: true},
+		{name: "envelope to localpart", test: `envelope :localpart :is "to" "boss"`, match: false},
+		{name: "body text", test: `body :contains "since 10:00"`, match: true},
+		{name: "body text only", test: `body :text :contains "<b>"`, match: false},
+		{name: "body html", test: `body :content "text/html" :contains "<b>down</b>"`, match: true},
+		{name: "body raw", test: `body :raw :contains "xxxx"`, match: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := run(t, `require ["envelope", "body"];`+"\nif "+test.test+" { discard; }")
+			if matched := !result.Keep; matched != test.match {
+				t.Errorf("%s = %t, want %t", test.test, matched, test.match)
+			}
+		})
+	}
+}
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		test  string
+		match bool
+	}{
+		{name: "over", test: "size :over 2000", match: true},
+		{name: "over exact size", test: "size :over 2048", match: false},
+		{name: "under", test: "size :under 2049", match: true},
+		{name: "under exact size", test: "size :under 2048", match: false},
+		{name: "kilobytes", test: "size :over 1K", match: true},
+		{name: "kilobytes lower case", test: "size :under 2k", match: false},
+		{name: "megabytes", test: "size :under 1M", match: true},
+		{name: "gigabytes", test: "size :over 1G", match: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := run(t, "if "+test.test+" { discard; }")
+			if matched := !result.Keep; matched != test.match {
+				t.Errorf("%s = %t, want %t", test.test, matched, test.match)
+			}
+		})
+	}
+}
+
+func TestVariables(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		folder string
+	}{
+		{
+			name:   "set",
+			src:    `set "folder" "alerts"; fileinto "${folder}";`,
+			folder: "alerts",
+		},
+		{
+			name:   "modifiers",
+			src:    `set :lower :upperfirst "folder" "ALERTS"; fileinto "${folder}";`,
+			folder: "Alerts",
+		},
+		{
+			name:   "length",
+			src:    `set :length "len" "café"; fileinto "${len}";`,
+			folder: "4",
+		},
+		{
+			name:   "quotewildcard",
+			src:    `set :quotewildcard "pattern" "a*b?"; fileinto "${pattern}";`,
+			folder: `a\*b\?`,
+		},
+		{
+			name:   "match variables",
+			src:    `if header :matches "from" "*<*@*>" { fileinto "${3}-${2}"; }`,
+			folder: "Example.com-Jane.Doe",
+		},
+		{
+			name:   "whole match variable",
+			src:    `if header :matches "x-spam" "y*" { fileinto "${0}.${1}"; }`,
+			folder: "yes.es",
+		},
+		{
+			name:   "unset match variable",
+			src:    `if header :matches "x-spam" "*" { fileinto "[${5}]"; }`,
+			folder: "[]",
+		},
+		{
+			name:   "unknown variable",
+			src:    `fileinto "[${nope}]";`,
+			folder: "[]",
+		},
+		{
+			name:   "invalid variable name",
+			src:    `fileinto "${in valid}";`,
+			folder: "${in valid}",
+		},
+		{
+			name:   "string test",
+			src:    `set "a" "x"; if string :is "${a}" "x" { fileinto "yes"; } else { fileinto "no"; }`,
+			folder: "yes",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := run(t, `require ["variables", "fileinto"];`+"\n"+test.src)
+			if len(result.FileInto) != 1 || result.FileInto[0].Name != test.folder {
+				t.Errorf("FileInto = %+v, want %q", result.FileInto, test.folder)
+			}
+		})
+	}
+}
+
+func TestVariablesRequireCapability(t *testing.T) {
+	result := run(t, `require "fileinto"; if header :matches "x-spam" "*" { fileinto "${1}"; }`)
+	if len(result.FileInto) != 1 || result.FileInto[0].Name != "${1}" {
+		t.Errorf("FileInto = %+v, want not expanded name", result.FileInto)
+	}
+}
+
+func TestActions(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected *Result
+	}{
+		{
+			name:     "implicit keep",
+			src:      ``,
+			expected: &Result{Keep: true, Flags: []string{}},
+		},
+		{
+			name:     "explicit keep",
+			src:      `keep;`,
+			expected: &Result{Keep: true, Flags: []string{}},
+		},
+		{
+			name:     "discard",
+			src:      `discard;`,
+			expected: &Result{},
+		},
+		{
+			name:     "keep after discard",
+			src:      `discard; keep;`,
+			expected: &Result{Keep: true, Flags: []string{}},
+		},
+		{
+			name:     "fileinto cancels implicit keep",
+			src:      `fileinto "spam";`,
+			expected: &Result{FileInto: []*Folder{{Name: "spam", Flags: []string{}}}},
+		},
+		{
+			name:     "fileinto copy",
+			src:      `fileinto :copy "archive";`,
+			expected: &Result{Keep: true, Flags: []string{}, FileInto: []*Folder{{Name: "archive", Flags: []string{}}}},
+		},
+		{
+			name:     "fileinto and keep",
+			src:      `fileinto "archive"; keep;`,
+			expected: &Result{Keep: true, Flags: []string{}, FileInto: []*Folder{{Name: "archive", Flags: []string{}}}},
+		},
+		{
+			name:     "redirect",
+			src:      `redirect "other@example.net";`,
+			expected: &Result{Redirect: []string{"other@example.net"}},
+		},
+		{
+			name:     "redirect copy",
+			src:      `redirect :copy "other@example.net";`,
+			expected: &Result{Keep: true, Flags: []string{}, Redirect: []string{"other@example.net"}},
+		},
+		{
+			name:     "reject",
+			src:      `reject "go away";`,
+			expected: &Result{Reject: "go away"},
+		},
+		{
+			name:     "stop",
+			src:      `stop; discard;`,
+			expected: &Result{Keep: true, Flags: []string{}},
+		},
+		{
+			name:     "flags",
+			src:      `addflag "\\Seen"; addflag ["urgent", "\\seen"]; fileinto :flags "spam" "junk"; keep;`,
+			expected: &Result{Keep: true, Flags: []string{`\Seen`, "urgent"}, FileInto: []*Folder{{Name: "junk", Flags: []string{"spam"}}}},
+		},
+		{
+			name:     "removeflag",
+			src:      `setflag "a b c"; removeflag "B";`,
+			expected: &Result{Keep: true, Flags: []string{"a", "c"}},
+		},
+		{
+			name:     "elsif",
+			src:      `if false { fileinto "a"; } elsif true { fileinto "b"; } else { fileinto "c"; }`,
+			expected: &Result{FileInto: []*Folder{{Name: "b", Flags: []string{}}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := run(t, `require ["fileinto", "reject", "imap4flags", "copy"];`+"\n"+test.src)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Run() = %+v, want %+v", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestVacation(t *testing.T) {
+	result := run(t, `require ["vacation", "variables"];
+if header :matches "subject" "*" {
+	vacation :days 3 :subject "Re: ${1}" :from "team@example.org" :addresses ["alias@example.org"] text:
+I'm away.
+..
+.
+;
+}`)
+	expected := &Vacation{
+		Days:      3,
+		Subject:   "Re: [URGENT] Server down",
+		From:      "team@example.org",
+		Addresses: []string{"alias@example.org"},
+		Handle:    "Re: [URGENT] Server down|team@example.org|I'm away.\n.\n",
+		Reason:    "I'm away.\n.\n",
+	}
+	if !reflect.DeepEqual(result.Vacation, expected) {
+		t.Errorf("Vacation = %+v, want %+v", result.Vacation, expected)
+	}
+	if !result.Keep {
+		t.Error("Keep = false, vacation should not cancel implicit keep")
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+	script, err := Parse(`require "vacation"; vacation :days "three" "away";`)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	result, err := script.Run(testMessage())
+	if err == nil {
+		t.Fatal("Run() = nil, want error")
+	}
+	if !result.Keep {
+		t.Error("Keep = false, runtime errors should keep the message")
+	}
+}
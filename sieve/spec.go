@@ -0,0 +1,191 @@
+package sieve
+
+// tagSpec describes a tagged argument
+type tagSpec struct {
+	// value is true if the tag is followed by a value (e.g. :days 7)
+	value bool
+	// group of mutually exclusive tags (e.g. match types)
+	group string
+	// capability required by the tag
+	capability string
+}
+
+// argSpec describes arguments of a command or test
+type argSpec struct {
+	tags map[string]tagSpec
+	// positional arguments, the first optional ones are omitted first
+	positional []argKind
+	optional   int
+}
+
+// parsedArgs of a command or test
+type parsedArgs struct {
+	tags       map[string]arg
+	positional []arg
+}
+
+func (a *parsedArgs) has(tag string) bool {
+	_, ok := a.tags[tag]
+	return ok
+}
+
+var (
+	matchTags = map[string]tagSpec{
+		"comparator": {value: true},
+		"is":         {group: "match"},
+		"contains":   {group: "match"},
+		"matches":    {group: "match"},
+	}
+	addressPartTags = map[string]tagSpec{
+		"all":       {group: "address-part"},
+		"localpart": {group: "address-part"},
+		"domain":    {group: "address-part"},
+	}
+)
+
+func merge(specs ...map[string]tagSpec) map[string]tagSpec {
+	merged := map[string]tagSpec{}
+	for _, spec := range specs {
+		for tag, ts := range spec {
+			merged[tag] = ts
+		}
+	}
+	return merged
+}
+
+var commandSpecs = map[string]argSpec{
+	"require":  {positional: []argKind{argStrings}},
+	"if":       {},
+	"elsif":    {},
+	"else":     {},
+	"stop":     {},
+	"discard":  {},
+	"keep":     {tags: map[string]tagSpec{"flags": {value: true, capability: "imap4flags"}}},
+	"redirect": {tags: map[string]tagSpec{"copy": {capability: "copy"}}, positional: []argKind{argStrings}},
+	"fileinto": {
+		tags: map[string]tagSpec{
+			"copy":  {capability: "copy"},
+			"flags": {value: true, capability: "imap4flags"},
+		},
+		positional: []argKind{argStrings},
+	},
+	"reject":  {positional: []argKind{argStrings}},
+	"ereject": {positional: []argKind{argStrings}},
+	"set": {
+		tags: map[string]tagSpec{
+			"lower":         {group: "case"},
+			"upper":         {group: "case"},
+			"lowerfirst":    {group: "casefirst"},
+			"upperfirst":    {group: "casefirst"},
+			"quotewildcard": {},
+			"length":        {},
+		},
+		positional: []argKind{argStrings, argStrings},
+	},
+	"setflag":    {positional: []argKind{argStrings, argStrings}, optional: 1},
+	"addflag":    {positional: []argKind{argStrings, argStrings}, optional: 1},
+	"removeflag": {positional: []argKind{argStrings, argStrings}, optional: 1},
+	"vacation": {
+		tags: map[string]tagSpec{
+			"days":      {value: true},
+			"subject":   {value: true},
+			"from":      {value: true},
+			"addresses": {value: true},
+			"mime":      {},
+			"handle":    {value: true},
+		},
+		positional: []argKind{argStrings},
+	},
+}
+
+var testSpecs = map[string]argSpec{
+	"address":  {tags: merge(matchTags, addressPartTags), positional: []argKind{argStrings, argStrings}},
+	"envelope": {tags: merge(matchTags, addressPartTags), positional: []argKind{argStrings, argStrings}},
+	"header":   {tags: matchTags, positional: []argKind{argStrings, argStrings}},
+	"string":   {tags: matchTags, positional: []argKind{argStrings, argStrings}},
+	"hasflag":  {tags: matchTags, positional: []argKind{argStrings, argStrings}, optional: 1},
+	"exists":   {positional: []argKind{argStrings}},
+	"size": {tags: map[string]tagSpec{
+		"over":  {value: true, group: "size"},
+		"under": {value: true, group: "size"},
+	}},
+	"body": {
+		tags: merge(matchTags, map[string]tagSpec{
+			"raw":     {group: "transform"},
+			"content": {value: true, group: "transform"},
+			"text":    {group: "transform"},
+		}),
+		positional: []argKind{argStrings},
+	},
+	"allof": {},
+	"anyof": {},
+	"not":   {},
+	"true":  {},
+	"false": {},
+}
+
+// parseArgs splits arguments into tagged and positional ones, validating them against the spec
+func (s *Script) parseArgs(spec argSpec, name string, line int, args []arg) (*parsedArgs, error) {
+	parsed := &parsedArgs{tags: map[string]arg{}}
+	groups := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a.kind != argTag {
+			parsed.positional = append(parsed.positional, a)
+			continue
+		}
+		if len(parsed.positional) > 0 {
+			return nil, newError(a.line, "%q: tagged arguments should precede positional ones", name)
+		}
+		ts, ok := spec.tags[a.tag]
+		if !ok {
+			return nil, newError(a.line, "%q: unknown tagged argument :%s", name, a.tag)
+		}
+		if ts.capability != "" && !s.requires[ts.capability] {
+			return nil, newError(a.line, ":%s requires the %q capability", a.tag, ts.capability)
+		}
+		if ts.group != "" {
+			if other, ok := groups[ts.group]; ok {
+				return nil, newError(a.line, "%q: :%s cannot be used with :%s", name, a.tag, other)
+			}
+			groups[ts.group] = a.tag
+		}
+		if _, ok := parsed.tags[a.tag]; ok {
+			return nil, newError(a.line, "%q: duplicate tagged argument :%s", name, a.tag)
+		}
+		if ts.value {
+			if i+1 >= len(args) || args[i+1].kind == argTag {
+				return nil, newError(a.line, "%q: value expected after :%s", name, a.tag)
+			}
+			i++
+			parsed.tags[a.tag] = args[i]
+			continue
+		}
+		parsed.tags[a.tag] = a
+	}
+
+	positional := spec.positional
+	if missing := len(positional) - len(parsed.positional); missing > 0 && missing <= spec.optional {
+		positional = positional[missing:]
+	}
+	if len(parsed.positional) != len(positional) {
+		return nil, newError(line, "%q expects %d positional argument(s), got %d", name, len(positional), len(parsed.positional))
+	}
+	for i, kind := range positional {
+		if parsed.positional[i].kind != kind {
+			return nil, newError(parsed.positional[i].line, "%q: invalid type of argument #%d", name, i+1)
+		}
+	}
+	if comparator, ok := parsed.tags["comparator"]; ok {
+		if comparator.kind != argStrings || len(comparator.strs) != 1 {
+			return nil, newError(comparator.line, "%q: comparator name expected", name)
+		}
+		switch comparator.strs[0] {
+		case "i;ascii-casemap", "i;octet":
+		default:
+			return nil, newError(comparator.line, "unsupported comparator %q", comparator.strs[0])
+		}
+	}
+
+	return parsed, nil
+}
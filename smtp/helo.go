@@ -0,0 +1,174 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/rs/zerolog"
+
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// heloTimeout is a timeout of DNS lookups of a single FCrDNS check
+const heloTimeout = 5 * time.Second
+
+// Actions of failed HELO checks, empty action = check is disabled
+const (
+	HeloActionReject   = "reject"
+	HeloActionGreylist = "greylist"
+	HeloActionScore    = "score"
+)
+
+// ErrHeloRejected returned when client failed HELO checks with reject action
+var ErrHeloRejected = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "your HELO or reverse DNS doesn't look right, kupo.",
+}
+
+// Resolver used for DNS lookups of HELO checks, net.DefaultResolver by default
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// HeloConfig of HELO checks, each option is an action on failed check (see HeloAction* constants), empty = disabled
+type HeloConfig struct {
+	// FQDN action when HELO is not a syntactically valid FQDN
+	FQDN string
+	// Domains action when HELO claims one of our own domains
+	Domains string
+	// FCrDNS action when client IP has no forward-confirmed reverse DNS
+	FCrDNS string
+	// Resolver for DNS lookups, optional
+	Resolver Resolver
+}
+
+// Validate actions of the HELO checks
+func (cfg *HeloConfig) Validate() error {
+	for name, action := range map[string]string{"fqdn": cfg.FQDN, "domains": cfg.Domains, "fcrdns": cfg.FCrDNS} {
+		switch action {
+		case "", HeloActionReject, HeloActionGreylist, HeloActionScore:
+		default:
+			return fmt.Errorf("invalid helo.%s action %q, must be one of: %s, %s, %s or empty", name, action, HeloActionReject, HeloActionGreylist, HeloActionScore)
+		}
+	}
+
+	return nil
+}
+
+// heloChecks of inbound connections
+type heloChecks struct {
+	cfg     HeloConfig
+	domains []string
+	log     *zerolog.Logger
+}
+
+func newHeloChecks(cfg *HeloConfig, domains []string, log *zerolog.Logger) *heloChecks {
+	if cfg == nil {
+		cfg = &HeloConfig{}
+	}
+	checks := &heloChecks{cfg: *cfg, domains: domains, log: log}
+	if checks.cfg.Resolver == nil {
+		checks.cfg.Resolver = net.DefaultResolver
+	}
+
+	return checks
+}
+
+// Check HELO name and client address, returns actions of the failed checks
+func (c *heloChecks) Check(helo string, addr net.Addr) []string {
+	actions := []string{}
+	if c.cfg.FQDN != "" && !isFQDN(helo) {
+		c.log.Info().Str("helo", helo).Str("addr", utils.AddrIP(addr)).Msg("HELO is not a valid FQDN")
+		actions = append(actions, c.cfg.FQDN)
+	}
+	if c.cfg.Domains != "" && c.isOwnDomain(helo) {
+		c.log.Info().Str("helo", helo).Str("addr", utils.AddrIP(addr)).Msg("HELO claims our own domain")
+		actions = append(actions, c.cfg.Domains)
+	}
+	if c.cfg.FCrDNS != "" && !c.fcrdns(addr) {
+		c.log.Info().Str("helo", helo).Str("addr", utils.AddrIP(addr)).Msg("client has no forward-confirmed reverse DNS")
+		actions = append(actions, c.cfg.FCrDNS)
+	}
+
+	return actions
+}
+
+// isOwnDomain checks if HELO is exactly one of our domains
+func (c *heloChecks) isOwnDomain(helo string) bool {
+	helo = strings.TrimSuffix(strings.ToLower(helo), ".")
+	for _, domain := range c.domains {
+		if helo == strings.ToLower(domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fcrdns checks that one of the PTR names of the address resolves back to it
+func (c *heloChecks) fcrdns(addr net.Addr) bool {
+	ip := net.ParseIP(utils.AddrIP(addr))
+	if ip == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), heloTimeout)
+	defer cancel()
+
+	names, err := c.cfg.Resolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		c.log.Debug().Err(err).Str("addr", ip.String()).Msg("cannot lookup PTR")
+		return false
+	}
+	for _, name := range names {
+		ips, err := c.cfg.Resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			c.log.Debug().Err(err).Str("name", name).Msg("cannot lookup A/AAAA")
+			continue
+		}
+		for _, resolved := range ips {
+			if resolved.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isFQDN checks if name is a syntactically valid fully qualified domain name
+func isFQDN(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) == 0 || len(name) > 253 || net.ParseIP(name) != nil {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if !isDNSLabel(label) {
+			return false
+		}
+	}
+	// TLD cannot be numeric
+	return strings.Trim(labels[len(labels)-1], "0123456789") != ""
+}
+
+func isDNSLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,193 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// fakeResolver resolves from the static PTR and A/AAAA records
+type fakeResolver struct {
+	ptr map[string][]string
+	ips map[string][]string
+}
+
+func (r *fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	names, ok := r.ptr[addr]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return names, nil
+}
+
+func (r *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.ips[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func newTestHeloChecks(cfg *HeloConfig) *heloChecks {
+	log := zerolog.Nop()
+	cfg.Resolver = &fakeResolver{
+		ptr: map[string][]string{
+			"192.0.2.1":   {"mail.example.org."},
+			"192.0.2.2":   {"mismatch.example.org."},
+			"192.0.2.3":   {"broken.example.org.", "mail.example.org."},
+			"192.0.2.4":   {"missing.example.org."},
+			"2001:db8::1": {"mail6.example.org."},
+		},
+		ips: map[string][]string{
+			"mail.example.org.":     {"192.0.2.1", "192.0.2.3"},
+			"mismatch.example.org.": {"198.51.100.1"},
+			"mail6.example.org.":    {"2001:db8::1"},
+		},
+	}
+
+	return newHeloChecks(cfg, []string{"example.com", "Example.net"}, &log)
+}
+
+func TestHeloConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   HeloConfig
+		valid bool
+	}{
+		{name: "disabled", cfg: HeloConfig{}, valid: true},
+		{name: "all actions", cfg: HeloConfig{FQDN: HeloActionReject, Domains: HeloActionGreylist, FCrDNS: HeloActionScore}, valid: true},
+		{name: "invalid fqdn", cfg: HeloConfig{FQDN: "drop"}, valid: false},
+		{name: "invalid domains", cfg: HeloConfig{Domains: "Reject"}, valid: false},
+		{name: "invalid fcrdns", cfg: HeloConfig{FCrDNS: " score"}, valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cfg.Validate()
+			if (err == nil) != test.valid {
+				t.Errorf("Validate() = %v, valid = %t", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestIsFQDN(t *testing.T) {
+	tests := []struct {
+		name string
+		helo string
+		fqdn bool
+	}{
+		{name: "fqdn", helo: "mail.example.org", fqdn: true},
+		{name: "trailing dot", helo: "mail.example.org.", fqdn: true},
+		{name: "second level", helo: "example.org", fqdn: true},
+		{name: "single label", helo: "localhost", fqdn: false},
+		{name: "empty", helo: "", fqdn: false},
+		{name: "ipv4 literal", helo: "[192.0.2.1]", fqdn: false},
+		{name: "bare ipv4", helo: "192.0.2.1", fqdn: false},
+		{name: "ipv6 literal", helo: "[IPv6:2001:db8::1]", fqdn: false},
+		{name: "bare ipv6", helo: "2001:db8::1", fqdn: false},
+		{name: "numeric tld", helo: "mail.example.123", fqdn: false},
+		{name: "underscore", helo: "mail_server.example.org", fqdn: false},
+		{name: "leading hyphen", helo: "-mail.example.org", fqdn: false},
+		{name: "empty label", helo: "mail..example.org", fqdn: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if fqdn := isFQDN(test.helo); fqdn != test.fqdn {
+				t.Errorf("isFQDN(%q) = %t, want %t", test.helo, fqdn, test.fqdn)
+			}
+		})
+	}
+}
+
+func TestHeloChecksIsOwnDomain(t *testing.T) {
+	checks := newTestHeloChecks(&HeloConfig{})
+	tests := []struct {
+		name string
+		helo string
+		own  bool
+	}{
+		{name: "own domain", helo: "example.com", own: true},
+		{name: "case insensitive", helo: "EXAMPLE.COM", own: true},
+		{name: "mixed case config", helo: "example.net", own: true},
+		{name: "trailing dot", helo: "example.com.", own: true},
+		{name: "subdomain", helo: "mail.example.com", own: false},
+		{name: "suffix", helo: "notexample.com", own: false},
+		{name: "other domain", helo: "example.org", own: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if own := checks.isOwnDomain(test.helo); own != test.own {
+				t.Errorf("isOwnDomain(%q) = %t, want %t", test.helo, own, test.own)
+			}
+		})
+	}
+}
+
+func TestHeloChecksFCrDNS(t *testing.T) {
+	checks := newTestHeloChecks(&HeloConfig{})
+	tests := []struct {
+		name string
+		addr net.Addr
+		pass bool
+	}{
+		{name: "forward confirmed", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 25}, pass: true},
+		{name: "forward confirmed ipv6", addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 25}, pass: true},
+		{name: "second PTR name confirmed", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.3"), Port: 25}, pass: true},
+		{name: "forward mismatch", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 25}, pass: false},
+		{name: "forward lookup error", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.4"), Port: 25}, pass: false},
+		{name: "no PTR", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.5"), Port: 25}, pass: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pass := checks.fcrdns(test.addr); pass != test.pass {
+				t.Errorf("fcrdns(%s) = %t, want %t", test.addr, pass, test.pass)
+			}
+		})
+	}
+}
+
+func TestHeloChecksCheck(t *testing.T) {
+	checks := newTestHeloChecks(&HeloConfig{
+		FQDN:    HeloActionReject,
+		Domains: HeloActionGreylist,
+		FCrDNS:  HeloActionScore,
+	})
+	confirmed := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 25}
+	unconfirmed := &net.TCPAddr{IP: net.ParseIP("192.0.2.5"), Port: 25}
+	tests := []struct {
+		name    string
+		helo    string
+		addr    net.Addr
+		actions []string
+	}{
+		{name: "pass", helo: "mail.example.org", addr: confirmed, actions: []string{}},
+		{name: "ip literal", helo: "[192.0.2.1]", addr: confirmed, actions: []string{HeloActionReject}},
+		{name: "own domain", helo: "example.com", addr: confirmed, actions: []string{HeloActionGreylist}},
+		{name: "own subdomain", helo: "mx.example.com", addr: confirmed, actions: []string{}},
+		{name: "no fcrdns", helo: "mail.example.org", addr: unconfirmed, actions: []string{HeloActionScore}},
+		{name: "all failed", helo: "localhost", addr: unconfirmed, actions: []string{HeloActionReject, HeloActionScore}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actions := checks.Check(test.helo, test.addr); !reflect.DeepEqual(actions, test.actions) {
+				t.Errorf("Check(%q, %s) = %v, want %v", test.helo, test.addr, actions, test.actions)
+			}
+		})
+	}
+}
+
+func TestHeloChecksDisabled(t *testing.T) {
+	checks := newTestHeloChecks(&HeloConfig{})
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.5"), Port: 25}
+	if actions := checks.Check("localhost", addr); len(actions) != 0 {
+		t.Errorf("Check() = %v, want no actions", actions)
+	}
+}
@@ -30,6 +30,8 @@ type Config struct {
 	Bot     matrixbot
 	Callers []Caller
 	Relay   *RelayConfig
+	Milters *MilterConfig
+	Helo    *HeloConfig
 }
 
 type TLSConfig struct {
@@ -60,14 +62,16 @@ type Manager struct {
 }
 
 type matrixbot interface {
-	AllowAuth(string, string) (id.RoomID, bool)
-	IsGreylisted(net.Addr) bool
+	AllowAuth(string, string, net.Addr) (id.RoomID, bool)
+	IsGreylisted(net.Addr, string, string, bool, func() bool) bool
 	IsBanned(net.Addr) bool
 	IsTrusted(net.Addr) bool
-	Ban(net.Addr)
-	GetMapping(string) (id.RoomID, bool)
+	Offend(net.Addr, string)
+	GetMapping(string, string) (id.RoomID, bool)
+	ReverseSRS(string) (string, bool)
 	GetIFOptions(id.RoomID) email.IncomingFilteringOptions
 	IncomingEmail(context.Context, *email.Email) error
+	SendRejection(*email.Email, string)
 	GetDKIMprivkey() string
 }
 
@@ -83,6 +87,11 @@ func NewManager(cfg *Config) *Manager {
 		bot:     cfg.Bot,
 		domains: cfg.Domains,
 		sender:  newClient(cfg.Relay, cfg.Logger),
+		milters: newMilters(cfg.Milters, cfg.Logger),
+		helo:    newHeloChecks(cfg.Helo, cfg.Domains, cfg.Logger),
+	}
+	if cfg.Milters != nil {
+		mailsrv.milterDefaultAction = cfg.Milters.DefaultAction
 	}
 	for _, caller := range cfg.Callers {
 		caller.SetSendmail(mailsrv.sender.Send)
@@ -0,0 +1,256 @@
+package smtp
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/rs/zerolog"
+
+	"gitlab.com/etke.cc/postmoogle/milter"
+	"gitlab.com/etke.cc/postmoogle/utils"
+)
+
+// milterTimeout is a timeout of a single milter operation
+const milterTimeout = 30 * time.Second
+
+var (
+	// ErrMilterReject returned when milter rejected the message without custom reply
+	ErrMilterReject = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "message rejected by filter, kupo.",
+	}
+	// ErrMilterTempFail returned when milter temporary rejected the message or not available
+	ErrMilterTempFail = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      "message cannot be processed right now, try again later, kupo.",
+	}
+
+	// errMilterDiscard is used internally to silently drop the message
+	errMilterDiscard = errors.New("message discarded by milter")
+)
+
+type MilterConfig struct {
+	Addresses []string
+	// DefaultAction when milter is not available: accept, reject, tempfail
+	DefaultAction string
+}
+
+func newMilters(cfg *MilterConfig, log *zerolog.Logger) []*milter.Client {
+	if cfg == nil {
+		return nil
+	}
+	clients := make([]*milter.Client, 0, len(cfg.Addresses))
+	for _, address := range cfg.Addresses {
+		client, err := milter.New(address, milterTimeout)
+		if err != nil {
+			log.Error().Err(err).Str("address", address).Msg("cannot configure milter")
+			continue
+		}
+		clients = append(clients, client)
+	}
+
+	return clients
+}
+
+// milterChain passes SMTP session through all configured milters
+type milterChain struct {
+	log           *zerolog.Logger
+	clients       []*milter.Client
+	sessions      map[*milter.Client]*milter.Session
+	defaultAction string
+}
+
+func newMilterChain(clients []*milter.Client, defaultAction string, log *zerolog.Logger) *milterChain {
+	return &milterChain{
+		log:           log,
+		clients:       clients,
+		sessions:      make(map[*milter.Client]*milter.Session, len(clients)),
+		defaultAction: defaultAction,
+	}
+}
+
+// Connect opens milter sessions and sends connection info and HELO
+func (c *milterChain) Connect(helo string, addr net.Addr) error {
+	for _, client := range c.clients {
+		session, err := client.Session()
+		if err != nil {
+			if ferr := c.fallback(client, err); ferr != nil {
+				return ferr
+			}
+			continue
+		}
+		c.sessions[client] = session
+	}
+
+	hostname := "[" + utils.AddrIP(addr) + "]"
+	return c.each(func(s *milter.Session) (*milter.Response, error) {
+		resp, err := s.Connect(hostname, addr)
+		if err != nil || resp.Action != milter.ActionContinue {
+			return resp, err
+		}
+		return s.Helo(helo)
+	})
+}
+
+// Mail sends MAIL FROM to milters
+func (c *milterChain) Mail(from string) error {
+	return c.each(func(s *milter.Session) (*milter.Response, error) {
+		return s.Mail(from)
+	})
+}
+
+// Rcpt sends RCPT TO to milters
+func (c *milterChain) Rcpt(to string) error {
+	return c.each(func(s *milter.Session) (*milter.Response, error) {
+		return s.Rcpt(to)
+	})
+}
+
+// Data sends the message to milters and applies the requested modifications.
+// Returns modified message (the original one, if there are no header or body changes) and quarantine reason (if any)
+func (c *milterChain) Data(data []byte) ([]byte, string, error) {
+	var quarantine string
+	for _, client := range c.clients {
+		session, ok := c.sessions[client]
+		if !ok {
+			continue
+		}
+		headers, body := milter.Split(data)
+		resp, mods, err := c.message(session, headers, body)
+		if err != nil {
+			c.drop(client)
+			if ferr := c.fallback(client, err); ferr != nil {
+				return nil, "", ferr
+			}
+			continue
+		}
+		if verr := c.verdict(resp); verr != nil {
+			return nil, "", verr
+		}
+		if mods == nil {
+			continue
+		}
+		if mods.Quarantine != "" {
+			c.log.Info().Str("milter", client.String()).Str("reason", mods.Quarantine).Msg("message quarantined")
+			quarantine = mods.Quarantine
+		}
+		if mods.Changed() {
+			data = milter.Join(mods.Apply(headers, body))
+		}
+	}
+
+	return data, quarantine, nil
+}
+
+func (c *milterChain) message(s *milter.Session, headers []milter.Header, body []byte) (*milter.Response, *milter.Modifications, error) {
+	for _, header := range headers {
+		resp, err := s.Header(header.Name, header.Value)
+		if err != nil || resp.Action != milter.ActionContinue {
+			return resp, nil, err
+		}
+	}
+	resp, err := s.EndOfHeaders()
+	if err != nil || resp.Action != milter.ActionContinue {
+		return resp, nil, err
+	}
+	resp, err = s.Body(body)
+	if err != nil || resp.Action != milter.ActionContinue {
+		return resp, nil, err
+	}
+
+	return s.EndOfMessage()
+}
+
+// Reset aborts current message in all milter sessions
+func (c *milterChain) Reset() {
+	for client, session := range c.sessions {
+		if err := session.Abort(); err != nil {
+			c.log.Warn().Err(err).Str("milter", client.String()).Msg("cannot abort milter session")
+			c.drop(client)
+		}
+	}
+}
+
+// Close all milter sessions
+func (c *milterChain) Close() {
+	for client := range c.sessions {
+		c.drop(client)
+	}
+}
+
+// each sends a command to all active milter sessions, stops on the first rejection.
+// Discard verdict is not returned here, because the message will be dropped at the end of DATA
+func (c *milterChain) each(cmd func(*milter.Session) (*milter.Response, error)) error {
+	for _, client := range c.clients {
+		session, ok := c.sessions[client]
+		if !ok {
+			continue
+		}
+		resp, err := cmd(session)
+		if err != nil {
+			c.drop(client)
+			if ferr := c.fallback(client, err); ferr != nil {
+				return ferr
+			}
+			continue
+		}
+		if resp.Action == milter.ActionDiscard {
+			continue
+		}
+		if verr := c.verdict(resp); verr != nil {
+			return verr
+		}
+	}
+
+	return nil
+}
+
+// verdict converts milter response to SMTP error
+func (c *milterChain) verdict(resp *milter.Response) error {
+	switch resp.Action {
+	case milter.ActionReject, milter.ActionTempFail:
+		if resp.Code == 0 {
+			if resp.Action == milter.ActionReject {
+				return ErrMilterReject
+			}
+			return ErrMilterTempFail
+		}
+		return &smtp.SMTPError{
+			Code:         resp.Code,
+			EnhancedCode: smtp.EnhancedCode(resp.EnhancedCode),
+			Message:      resp.Message,
+		}
+	case milter.ActionDiscard:
+		return errMilterDiscard
+	default:
+		return nil
+	}
+}
+
+// fallback applies default action when milter is not available
+func (c *milterChain) fallback(client *milter.Client, err error) error {
+	c.log.Error().Err(err).Str("milter", client.String()).Str("action", c.defaultAction).Msg("milter is not available")
+	switch c.defaultAction {
+	case "accept":
+		return nil
+	case "reject":
+		return ErrMilterReject
+	default:
+		return ErrMilterTempFail
+	}
+}
+
+func (c *milterChain) drop(client *milter.Client) {
+	session, ok := c.sessions[client]
+	if !ok {
+		return
+	}
+	delete(c.sessions, client)
+	if err := session.Close(); err != nil {
+		c.log.Warn().Err(err).Str("milter", client.String()).Msg("cannot close milter session")
+	}
+}
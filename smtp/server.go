@@ -8,6 +8,8 @@ import (
 	"github.com/rs/zerolog"
 
 	"gitlab.com/etke.cc/postmoogle/email"
+	"gitlab.com/etke.cc/postmoogle/milter"
+	"gitlab.com/etke.cc/postmoogle/utils"
 )
 
 var (
@@ -23,6 +25,18 @@ var (
 		EnhancedCode: smtp.EnhancedCode{5, 5, 0},
 		Message:      "no such user here, kupo.",
 	}
+	// ErrAuthFailed returned when SMTP login or password is invalid
+	ErrAuthFailed = &smtp.SMTPError{
+		Code:         535,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 8},
+		Message:      "authentication failed, kupo.",
+	}
+	// errGreylisted returned to greylisted senders
+	errGreylisted = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 5, 1},
+		Message:      "You have been greylisted, try again a bit later.",
+	}
 )
 
 type mailServer struct {
@@ -30,6 +44,10 @@ type mailServer struct {
 	log     *zerolog.Logger
 	domains []string
 	sender  MailSender
+	milters []*milter.Client
+
+	milterDefaultAction string
+	helo                *heloChecks
 }
 
 // Login used for outgoing mail submissions only (when you use postmoogle as smtp server in your scripts)
@@ -41,15 +59,15 @@ func (m *mailServer) Login(state *smtp.ConnectionState, username, password strin
 
 	if !email.AddressValid(username) {
 		m.log.Debug().Str("address", username).Msg("address is invalid")
-		m.bot.Ban(state.RemoteAddr)
-		return nil, ErrBanned
+		m.bot.Offend(state.RemoteAddr, utils.OffenceAddress)
+		return nil, ErrAuthFailed
 	}
 
-	roomID, allow := m.bot.AllowAuth(username, password)
+	roomID, allow := m.bot.AllowAuth(username, password, state.RemoteAddr)
 	if !allow {
 		m.log.Debug().Str("username", username).Msg("username or password is invalid")
-		m.bot.Ban(state.RemoteAddr)
-		return nil, ErrBanned
+		m.bot.Offend(state.RemoteAddr, utils.OffenceAuth)
+		return nil, ErrAuthFailed
 	}
 
 	return &outgoingSession{
@@ -72,14 +90,39 @@ func (m *mailServer) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session,
 		return nil, ErrBanned
 	}
 
+	var suspicious bool
+	if !m.bot.IsTrusted(state.RemoteAddr) {
+		for _, action := range m.helo.Check(state.Hostname, state.RemoteAddr) {
+			switch action {
+			case HeloActionReject:
+				m.bot.Offend(state.RemoteAddr, utils.OffenceHelo)
+				return nil, ErrHeloRejected
+			case HeloActionGreylist:
+				suspicious = true
+			case HeloActionScore:
+				m.bot.Offend(state.RemoteAddr, utils.OffenceHelo)
+			}
+		}
+	}
+
+	milters := newMilterChain(m.milters, m.milterDefaultAction, m.log)
+	if err := milters.Connect(state.Hostname, state.RemoteAddr); err != nil {
+		milters.Close()
+		return nil, err
+	}
+
 	return &incomingSession{
 		ctx:          sentry.SetHubOnContext(context.Background(), sentry.CurrentHub().Clone()),
 		getRoomID:    m.bot.GetMapping,
+		reverseSRS:   m.bot.ReverseSRS,
 		getFilters:   m.bot.GetIFOptions,
 		receiveEmail: m.ReceiveEmail,
-		ban:          m.bot.Ban,
+		rejected:     m.bot.SendRejection,
+		offend:       m.bot.Offend,
 		greylisted:   m.bot.IsGreylisted,
 		trusted:      m.bot.IsTrusted,
+		milters:      milters,
+		suspicious:   suspicious,
 		log:          m.log,
 		domains:      m.domains,
 		addr:         state.RemoteAddr,
@@ -7,7 +7,9 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"strings"
 
+	"blitiri.com.ar/go/spf"
 	"github.com/emersion/go-msgauth/dkim"
 	"github.com/emersion/go-smtp"
 	"github.com/getsentry/sentry-go"
@@ -23,14 +25,18 @@ import (
 // incomingSession represents an SMTP-submission session receiving emails from remote servers
 type incomingSession struct {
 	log          *zerolog.Logger
-	getRoomID    func(string) (id.RoomID, bool)
+	getRoomID    func(string, string) (id.RoomID, bool)
+	reverseSRS   func(string) (string, bool)
 	getFilters   func(id.RoomID) email.IncomingFilteringOptions
 	receiveEmail func(context.Context, *email.Email) error
-	greylisted   func(net.Addr) bool
+	rejected     func(*email.Email, string)
+	greylisted   func(net.Addr, string, string, bool, func() bool) bool
 	trusted      func(net.Addr) bool
-	ban          func(net.Addr)
+	offend       func(net.Addr, string)
+	milters      *milterChain
 	domains      []string
 	roomID       id.RoomID
+	suspicious   bool // failed HELO checks with greylist action
 
 	ctx  context.Context
 	addr net.Addr
@@ -40,11 +46,15 @@ type incomingSession struct {
 
 func (s *incomingSession) Mail(from string, opts smtp.MailOptions) error {
 	sentry.GetHubFromContext(s.ctx).Scope().SetTag("from", from)
-	if !email.AddressValid(from) {
+	// empty sender (null reverse-path) is used by delivery status notifications
+	if from != "" && !email.AddressValid(from) {
 		s.log.Debug().Str("from", from).Msg("address is invalid")
-		s.ban(s.addr)
+		s.offend(s.addr, utils.OffenceAddress)
 		return ErrBanned
 	}
+	if err := s.milters.Mail(from); err != nil {
+		return err
+	}
 	s.from = from
 	s.log.Debug().Str("from", from).Any("options", opts).Msg("incoming mail")
 	return nil
@@ -52,8 +62,9 @@ func (s *incomingSession) Mail(from string, opts smtp.MailOptions) error {
 
 func (s *incomingSession) Rcpt(to string) error {
 	sentry.GetHubFromContext(s.ctx).Scope().SetTag("to", to)
-	s.tos = append(s.tos, to)
-	hostname := utils.Hostname(to)
+	// VERP addresses (bounces) are routed to the mailbox itself
+	rcptTo, _ := utils.ParseVERP(to)
+	hostname := utils.Hostname(rcptTo)
 	var domainok bool
 	for _, domain := range s.domains {
 		if hostname == domain {
@@ -63,15 +74,40 @@ func (s *incomingSession) Rcpt(to string) error {
 	}
 	if !domainok {
 		s.log.Debug().Str("to", to).Msg("wrong domain")
+		s.offend(s.addr, utils.OffenceNoUser)
 		return ErrNoUser
 	}
 
+	// SRS addresses (bounces of forwarded emails) are relayed to the original sender
+	if utils.IsSRS(rcptTo) {
+		if _, ok := s.reverseSRS(rcptTo); !ok {
+			s.log.Debug().Str("to", to).Msg("invalid SRS address")
+			s.offend(s.addr, utils.OffenceNoUser)
+			return ErrNoUser
+		}
+		if err := s.milters.Rcpt(to); err != nil {
+			return err
+		}
+		s.tos = append(s.tos, to)
+		return nil
+	}
+
 	var ok bool
-	s.roomID, ok = s.getRoomID(utils.Mailbox(to))
+	s.roomID, ok = s.getRoomID(utils.Mailbox(rcptTo), hostname)
 	if !ok {
 		s.log.Debug().Str("to", to).Msg("mapping not found")
+		s.offend(s.addr, utils.OffenceNoUser)
 		return ErrNoUser
 	}
+	// greylisting before DATA, so deferred senders don't send the message body.
+	// Real address of trusted proxy's connection is known at DATA only, so it's greylisted there
+	if !s.trusted(s.addr) && s.greylisted(s.addr, s.from, to, s.suspicious, func() bool { return authenticated(s.from, s.addr, nil, s.log) }) {
+		return errGreylisted
+	}
+	if err := s.milters.Rcpt(to); err != nil {
+		return err
+	}
+	s.tos = append(s.tos, to)
 
 	s.log.Debug().Str("to", to).Msg("mail")
 	return nil
@@ -117,16 +153,12 @@ func (s *incomingSession) Data(r io.Reader) error {
 	addr := s.getAddr(envelope)
 	reader.Seek(0, io.SeekStart) //nolint:errcheck
 	validations := s.getFilters(s.roomID)
-	if !validateIncoming(s.from, s.tos[0], addr, s.log, validations) {
-		s.ban(addr)
+	if s.from != "" && !validateIncoming(s.from, s.tos[0], addr, s.log, validations) {
+		s.offend(addr, utils.OffenceSpam)
 		return ErrBanned
 	}
-	if s.greylisted(addr) {
-		return &smtp.SMTPError{
-			Code:         451,
-			EnhancedCode: smtp.EnhancedCode{4, 5, 1},
-			Message:      "You have been greylisted, try again a bit later.",
-		}
+	if s.trusted(s.addr) && s.greylisted(addr, s.from, s.tos[0], s.suspicious, func() bool { return authenticated(s.from, addr, data, s.log) }) {
+		return errGreylisted
 	}
 	if validations.SpamcheckDKIM() {
 		results, verr := dkim.Verify(reader)
@@ -142,19 +174,66 @@ func (s *incomingSession) Data(r io.Reader) error {
 		}
 	}
 
+	filtered, quarantine, err := s.milters.Data(data)
+	if err != nil {
+		if err == errMilterDiscard {
+			s.log.Info().Str("from", s.from).Msg("message discarded by milter")
+			return nil
+		}
+		if serr, ok := err.(*smtp.SMTPError); ok && serr.Code >= 500 {
+			s.offend(addr, utils.OffenceSpam)
+		}
+		return err
+	}
+	if !bytes.Equal(filtered, data) {
+		envelope, err = parser.ReadEnvelope(bytes.NewReader(filtered))
+		if err != nil {
+			return err
+		}
+	}
+
 	eml := email.FromEnvelope(s.tos[0], envelope)
+	eml.Quarantine = quarantine
+	eml.EnvelopeFrom = s.from
+	eml.Raw = filtered
+	eml.Original = data
+	// emails rejected by some recipients are delivered to the others,
+	// so the transaction is rejected only if all recipients rejected the email
+	rejections := map[string]string{}
 	for _, to := range s.tos {
 		eml.RcptTo = to
 		err := s.receiveEmail(s.ctx, eml)
+		var rejected *email.RejectedError
+		if errors.As(err, &rejected) {
+			rejections[to] = rejected.Reason
+			continue
+		}
 		if err != nil {
 			return err
 		}
 	}
+	if len(rejections) == len(s.tos) {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      rejections[s.tos[0]],
+		}
+	}
+	for to, reason := range rejections {
+		eml.RcptTo = to
+		s.rejected(eml, reason)
+	}
 	return nil
 }
 
-func (s *incomingSession) Reset()        {}
-func (s *incomingSession) Logout() error { return nil }
+func (s *incomingSession) Reset() {
+	s.milters.Reset()
+}
+
+func (s *incomingSession) Logout() error {
+	s.milters.Close()
+	return nil
+}
 
 // outgoingSession represents an SMTP-submission session sending emails from external scripts, using postmoogle as SMTP server
 type outgoingSession struct {
@@ -162,7 +241,7 @@ type outgoingSession struct {
 	sendmail  func(string, string, string) error
 	privkey   string
 	domains   []string
-	getRoomID func(string) (id.RoomID, bool)
+	getRoomID func(string, string) (id.RoomID, bool)
 
 	ctx      context.Context
 	tos      []string
@@ -188,7 +267,7 @@ func (s *outgoingSession) Mail(from string, opts smtp.MailOptions) error {
 		return ErrNoUser
 	}
 
-	roomID, ok := s.getRoomID(utils.Mailbox(from))
+	roomID, ok := s.getRoomID(utils.Mailbox(from), hostname)
 	if !ok {
 		s.log.Debug().Str("from", from).Msg("mapping not found")
 		return ErrNoUser
@@ -248,3 +327,34 @@ func validateIncoming(from, to string, senderAddr net.Addr, log *zerolog.Logger,
 
 	return v.Email(from, sender)
 }
+
+// authenticated checks if sender passes SPF check or email has valid DKIM signature of the sender's domain (data = nil: SPF only)
+func authenticated(from string, senderAddr net.Addr, data []byte, log *zerolog.Logger) bool {
+	sender := net.ParseIP(utils.AddrIP(senderAddr))
+	result, err := spf.CheckHostWithSender(sender, "", from)
+	if result == spf.Pass {
+		return true
+	}
+	log.Debug().Err(err).Str("from", from).Str("result", string(result)).Msg("SPF check didn't pass")
+	if data == nil { // before DATA
+		return false
+	}
+
+	domain := strings.ToLower(utils.Hostname(from))
+	results, err := dkim.Verify(bytes.NewReader(data))
+	if err != nil {
+		log.Debug().Err(err).Str("from", from).Msg("cannot verify DKIM")
+		return false
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		signer := strings.ToLower(result.Domain)
+		if signer == domain || strings.HasSuffix(domain, "."+signer) {
+			return true
+		}
+	}
+
+	return false
+}
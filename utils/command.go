@@ -8,18 +8,34 @@ import (
 // ErrInvalidArgs returned when a command's arguments are invalid
 var ErrInvalidArgs = fmt.Errorf("invalid arguments")
 
-// ParseSend parses "!pm send" command, returns to, subject, body, err
-func ParseSend(commandSlice []string) (string, string, string, error) {
+// sendFromPrefix marks the sender alias argument of the "!pm send" command, e.g. from:alias
+const sendFromPrefix = "from:"
+
+// ParseSend parses "!pm send" command, returns to, from (optional), subject, body, err.
+// Recipients may be separated by commas and/or spaces, the sender alias is set explicitly with the from: prefix
+func ParseSend(commandSlice []string) (string, string, string, string, error) {
 	message := strings.Join(commandSlice, " ")
 	lines := strings.Split(message, "\n")
 	if len(lines) < 3 {
-		return "", "", "", ErrInvalidArgs
+		return "", "", "", "", ErrInvalidArgs
 	}
 
-	commandSlice = strings.Split(lines[0], " ")
-	to := commandSlice[1]
+	var from string
+	tos := []string{}
+	args := strings.Fields(strings.ReplaceAll(lines[0], ",", " "))
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(strings.ToLower(arg), sendFromPrefix) {
+			from = arg[len(sendFromPrefix):]
+			continue
+		}
+		tos = append(tos, arg)
+	}
+	if len(tos) == 0 {
+		return "", "", "", "", ErrInvalidArgs
+	}
+	to := strings.Join(tos, ",")
 	subject := lines[1]
 	body := strings.Join(lines[2:], "\n")
 
-	return to, subject, body, nil
+	return to, from, subject, body, nil
 }
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSend(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		to      string
+		from    string
+		subject string
+		body    string
+		err     error
+	}{
+		{
+			name:    "single recipient",
+			message: "send a@example.com\nSubject\nbody",
+			to:      "a@example.com",
+			subject: "Subject",
+			body:    "body",
+		},
+		{
+			name:    "comma-separated recipients",
+			message: "send a@example.com,b@example.com\nSubject\nline 1\nline 2",
+			to:      "a@example.com,b@example.com",
+			subject: "Subject",
+			body:    "line 1\nline 2",
+		},
+		{
+			name:    "recipients separated by comma and space",
+			message: "send a@example.com, b@example.com\nSubject\nbody",
+			to:      "a@example.com,b@example.com",
+			subject: "Subject",
+			body:    "body",
+		},
+		{
+			name:    "sender alias",
+			message: "send a@example.com from:alias\nSubject\nbody",
+			to:      "a@example.com",
+			from:    "alias",
+			subject: "Subject",
+			body:    "body",
+		},
+		{
+			name:    "sender alias before recipients",
+			message: "send From:alias@example.org a@example.com b@example.com\nSubject\nbody",
+			to:      "a@example.com,b@example.com",
+			from:    "alias@example.org",
+			subject: "Subject",
+			body:    "body",
+		},
+		{
+			name:    "no recipients",
+			message: "send from:alias\nSubject\nbody",
+			err:     ErrInvalidArgs,
+		},
+		{
+			name:    "no body",
+			message: "send a@example.com\nSubject",
+			err:     ErrInvalidArgs,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			to, from, subject, body, err := ParseSend(strings.Split(test.message, " "))
+			if err != test.err {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if to != test.to || from != test.from || subject != test.subject || body != test.body {
+				t.Errorf("ParseSend() = (%q, %q, %q, %q), want (%q, %q, %q, %q)", to, from, subject, body, test.to, test.from, test.subject, test.body)
+			}
+		})
+	}
+}
@@ -15,6 +15,8 @@ type File struct {
 	MsgType event.MessageType
 	Length  int
 	Content []byte
+	// ContentID of the inline file, referenced as cid: URL in the email HTML
+	ContentID string
 }
 
 func NewFile(name string, content []byte) *File {
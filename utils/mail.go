@@ -2,6 +2,9 @@ package utils
 
 import "strings"
 
+// verpSeparator separates address and VERP token, e.g. mailbox+bounce-token@example.com
+const verpSeparator = "+bounce-"
+
 // Mailbox returns mailbox part from email address
 func Mailbox(email string) string {
 	index := strings.LastIndex(email, "@")
@@ -39,3 +42,34 @@ func EmailsList(mailbox string, domain string) string {
 func Hostname(email string) string {
 	return email[strings.LastIndex(email, "@")+1:]
 }
+
+// VERP returns VERP address with the token, e.g. mailbox@example.com -> mailbox+bounce-token@example.com
+func VERP(email, token string) string {
+	return Mailbox(email) + verpSeparator + token + "@" + Hostname(email)
+}
+
+// ParseVERP returns address without VERP token and the token itself, empty token = not a VERP address
+func ParseVERP(email string) (string, string) {
+	mailbox := Mailbox(email)
+	index := strings.LastIndex(mailbox, verpSeparator)
+	if index == -1 {
+		return email, ""
+	}
+
+	return mailbox[:index] + "@" + Hostname(email), mailbox[index+len(verpSeparator):]
+}
+
+// Subaddress splits mailbox into the existing mailbox and tag, e.g. mailbox+tag -> mailbox, tag.
+// The longest existing mailbox wins, so my-team-tag is split into my-team and tag if my-team exists
+func Subaddress(mailbox string, exists func(string) bool) (string, string) {
+	if subaddress == "" {
+		return mailbox, ""
+	}
+	for index := strings.LastIndex(mailbox, subaddress); index > 0; index = strings.LastIndex(mailbox[:index], subaddress) {
+		if base := mailbox[:index]; exists(base) {
+			return base, mailbox[index+len(subaddress):]
+		}
+	}
+
+	return mailbox, ""
+}
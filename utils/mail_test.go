@@ -0,0 +1,49 @@
+package utils
+
+import "testing"
+
+func TestSubaddress(t *testing.T) {
+	mailboxes := map[string]bool{"my": true, "my-team": true, "box": true}
+	exists := func(mailbox string) bool { return mailboxes[mailbox] }
+	tests := []struct {
+		name      string
+		separator string
+		mailbox   string
+		base      string
+		tag       string
+	}{
+		{name: "plus", separator: "+", mailbox: "box+tag", base: "box", tag: "tag"},
+		{name: "plus with separator in tag", separator: "+", mailbox: "box+tag+more", base: "box", tag: "tag+more"},
+		{name: "longest existing mailbox", separator: "-", mailbox: "my-team-tag", base: "my-team", tag: "tag"},
+		{name: "shorter existing mailbox", separator: "-", mailbox: "my-other-tag", base: "my", tag: "other-tag"},
+		{name: "unknown mailbox", separator: "-", mailbox: "unknown-tag", base: "unknown-tag", tag: ""},
+		{name: "no tag", separator: "+", mailbox: "box", base: "box", tag: ""},
+		{name: "leading separator", separator: "+", mailbox: "+box", base: "+box", tag: ""},
+		{name: "disabled", separator: "none", mailbox: "box+tag", base: "box+tag", tag: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := SetSubaddressSeparator(test.separator); err != nil {
+				t.Fatalf("SetSubaddressSeparator(%q) = %v", test.separator, err)
+			}
+			base, tag := Subaddress(test.mailbox, exists)
+			if base != test.base || tag != test.tag {
+				t.Errorf("Subaddress(%q) = (%q, %q), want (%q, %q)", test.mailbox, base, tag, test.base, test.tag)
+			}
+		})
+	}
+}
+
+func TestSetSubaddressSeparator(t *testing.T) {
+	defer SetSubaddressSeparator("+") //nolint:errcheck // valid separator
+	for _, separator := range []string{"+", "-", "none", ""} {
+		if err := SetSubaddressSeparator(separator); err != nil {
+			t.Errorf("SetSubaddressSeparator(%q) = %v, want nil", separator, err)
+		}
+	}
+	for _, separator := range []string{".", "_", "=", "++", "plus"} {
+		if err := SetSubaddressSeparator(separator); err == nil {
+			t.Errorf("SetSubaddressSeparator(%q) = nil, want error", separator)
+		}
+	}
+}
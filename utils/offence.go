@@ -0,0 +1,15 @@
+package utils
+
+// Offence kinds, used to calculate score of a host for automatic banning
+const (
+	// OffenceAuth - wrong SMTP login or password
+	OffenceAuth = "auth"
+	// OffenceAddress - invalid email address
+	OffenceAddress = "address"
+	// OffenceNoUser - email to non-existing mailbox
+	OffenceNoUser = "nouser"
+	// OffenceSpam - email didn't pass spam checks
+	OffenceSpam = "spam"
+	// OffenceHelo - client didn't pass HELO or reverse DNS checks
+	OffenceHelo = "helo"
+)
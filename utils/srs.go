@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"time"
+)
+
+// Sender Rewriting Scheme, e.g. user@example.com -> SRS0=HHHH=TT=example.com=user@forwarder.com
+const (
+	srsPrefix    = "SRS0="
+	srsSeparator = "="
+	// srsMaxAge of SRS address in days, bounces to older addresses are rejected
+	srsMaxAge = 21
+	// srsTimestampRange is 2 base32 characters
+	srsTimestampRange = 1024
+	srsAlphabet       = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+var srsEncoding = base32.NewEncoding(srsAlphabet).WithPadding(base32.NoPadding)
+
+// SRS returns SRS address of the email, so it can be used as envelope sender of the forwarded email from the domain
+func SRS(email, domain string, secret []byte) string {
+	timestamp := srsTimestamp(time.Now())
+	hostname := Hostname(email)
+	localpart := Mailbox(email)
+
+	return srsPrefix + srsHash(secret, timestamp, hostname, localpart) + srsSeparator +
+		timestamp + srsSeparator + hostname + srsSeparator + localpart + "@" + domain
+}
+
+// IsSRS checks if the email is SRS address
+func IsSRS(email string) bool {
+	return strings.HasPrefix(strings.ToUpper(email), srsPrefix)
+}
+
+// ReverseSRS returns original email of the SRS address, false if address is invalid, forged or expired
+func ReverseSRS(email string, secret []byte) (string, bool) {
+	if !IsSRS(email) {
+		return "", false
+	}
+	parts := strings.SplitN(Mailbox(email)[len(srsPrefix):], srsSeparator, 4)
+	if len(parts) != 4 || parts[2] == "" || parts[3] == "" {
+		return "", false
+	}
+	hash, timestamp, hostname, localpart := strings.ToLower(parts[0]), strings.ToLower(parts[1]), parts[2], parts[3]
+	if !hmac.Equal([]byte(hash), []byte(srsHash(secret, timestamp, hostname, localpart))) {
+		return "", false
+	}
+	if len(timestamp) != 2 {
+		return "", false
+	}
+	then := strings.IndexByte(srsAlphabet, timestamp[0])<<5 | strings.IndexByte(srsAlphabet, timestamp[1])
+	now := int(time.Now().Unix() / 86400 % srsTimestampRange)
+	if then < 0 || (now-then+srsTimestampRange)%srsTimestampRange > srsMaxAge {
+		return "", false
+	}
+
+	return localpart + "@" + hostname, true
+}
+
+// srsTimestamp returns days since epoch (modulo 1024) as 2 base32 characters
+func srsTimestamp(now time.Time) string {
+	days := now.Unix() / 86400 % srsTimestampRange
+
+	return string([]byte{srsAlphabet[days>>5], srsAlphabet[days&31]})
+}
+
+func srsHash(secret []byte, timestamp, hostname, localpart string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.ToLower(timestamp + hostname + localpart)))
+
+	return srsEncoding.EncodeToString(mac.Sum(nil))[:4]
+}
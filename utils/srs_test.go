@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSRSSecret = []byte("secret")
+
+func TestSRS(t *testing.T) {
+	address := SRS("User.Name@Example.com", "forwarder.org", testSRSSecret)
+	if !strings.HasPrefix(address, srsPrefix) || !strings.HasSuffix(address, "=Example.com=User.Name@forwarder.org") {
+		t.Fatalf("SRS() = %q", address)
+	}
+	if !IsSRS(address) || !IsSRS(strings.ToLower(address)) {
+		t.Errorf("IsSRS(%q) = false, want true", address)
+	}
+
+	original, ok := ReverseSRS(address, testSRSSecret)
+	if !ok || original != "User.Name@Example.com" {
+		t.Errorf("ReverseSRS() = (%q, %t), want the original address", original, ok)
+	}
+	// hash and timestamp are case-insensitive, as some servers lower-case the local part
+	if original, ok := ReverseSRS(strings.ToLower(address), testSRSSecret); !ok || original != "user.name@example.com" {
+		t.Errorf("ReverseSRS() of lower-cased address = (%q, %t), want the original address", original, ok)
+	}
+}
+
+func TestReverseSRSInvalid(t *testing.T) {
+	address := SRS("user@example.com", "forwarder.org", testSRSSecret)
+	parts := strings.SplitN(Mailbox(address)[len(srsPrefix):], srsSeparator, 4)
+	old := srsTimestamp(time.Now().AddDate(0, 0, -srsMaxAge-1))
+	expired := srsPrefix + srsHash(testSRSSecret, old, "example.com", "user") + srsSeparator + old + "=example.com=user@forwarder.org"
+	future := srsTimestamp(time.Now().AddDate(0, 0, srsMaxAge+1))
+	fromFuture := srsPrefix + srsHash(testSRSSecret, future, "example.com", "user") + srsSeparator + future + "=example.com=user@forwarder.org"
+
+	tests := []struct {
+		name    string
+		address string
+		secret  []byte
+	}{
+		{name: "not SRS", address: "user@example.com", secret: testSRSSecret},
+		{name: "wrong secret", address: address, secret: []byte("other")},
+		{name: "forged hash", address: srsPrefix + "aaaa=" + strings.Join(parts[1:], srsSeparator), secret: testSRSSecret},
+		{name: "forged address", address: strings.Replace(address, "=user@", "=admin@", 1), secret: testSRSSecret},
+		{name: "missing parts", address: srsPrefix + parts[0] + "=" + parts[1] + "=example.com@forwarder.org", secret: testSRSSecret},
+		{name: "invalid timestamp", address: srsPrefix + srsHash(testSRSSecret, "a", "example.com", "user") + "=a=example.com=user@forwarder.org", secret: testSRSSecret},
+		{name: "expired", address: expired, secret: testSRSSecret},
+		{name: "from the future", address: fromFuture, secret: testSRSSecret},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if original, ok := ReverseSRS(test.address, test.secret); ok {
+				t.Errorf("ReverseSRS(%q) = %q, want invalid", test.address, original)
+			}
+		})
+	}
+}
+
+func TestSRSTimestamp(t *testing.T) {
+	epoch := time.Unix(0, 0)
+	if timestamp := srsTimestamp(epoch); timestamp != "aa" {
+		t.Errorf("srsTimestamp(epoch) = %q, want aa", timestamp)
+	}
+	// the timestamp wraps around every 1024 days
+	wrapped := epoch.AddDate(0, 0, srsTimestampRange+33)
+	if timestamp := srsTimestamp(wrapped); timestamp != "bb" {
+		t.Errorf("srsTimestamp(+1057 days) = %q, want bb", timestamp)
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"net"
 	"strconv"
 	"strings"
@@ -9,8 +10,9 @@ import (
 )
 
 var (
-	log     *zerolog.Logger
-	domains []string
+	log        *zerolog.Logger
+	domains    []string
+	subaddress string
 )
 
 // SetLogger for utils
@@ -23,6 +25,26 @@ func SetDomains(slice []string) {
 	domains = slice
 }
 
+// SetSubaddressSeparator for later use, "none" disables subaddressing.
+// Only + and - separators are supported
+func SetSubaddressSeparator(separator string) error {
+	switch separator {
+	case "none", "":
+		subaddress = ""
+	case "+", "-":
+		subaddress = separator
+	default:
+		return fmt.Errorf("invalid subaddress separator %q, must be one of: +, - or none", separator)
+	}
+
+	return nil
+}
+
+// SubaddressSeparator returns subaddress separator, empty string = subaddressing is disabled
+func SubaddressSeparator() string {
+	return subaddress
+}
+
 // AddrIP returns IP from a network address
 func AddrIP(addr net.Addr) string {
 	key := addr.String()
@@ -33,6 +55,38 @@ func AddrIP(addr net.Addr) string {
 	return key
 }
 
+// AddrNetwork returns network of an address: /24 for IPv4 and /64 for IPv6
+func AddrNetwork(addr net.Addr) string {
+	ip := net.ParseIP(AddrIP(addr))
+	if ip == nil {
+		return AddrIP(addr)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// ParseNetwork parses IP or CIDR network, single IP is converted to /32 (IPv4) or /128 (IPv6) network
+func ParseNetwork(str string) (*net.IPNet, error) {
+	str = strings.TrimSpace(str)
+	if strings.Contains(str, "/") {
+		_, ipnet, err := net.ParseCIDR(str)
+		return ipnet, err
+	}
+
+	ip := net.ParseIP(str)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: str}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
 // SanitizeDomain checks that input domain is available for use
 func SanitizeDomain(domain string) string {
 	domain = strings.TrimSpace(domain)
@@ -123,3 +177,32 @@ func SanitizeStringSlice(str string) string {
 
 	return strings.Join(parts, ",")
 }
+
+// SliceContains checks if the slice contains the item
+func SliceContains(slice []string, item string) bool {
+	for _, v := range slice {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+// SliceAdd appends the item to the slice, if it's not there yet
+func SliceAdd(slice []string, item string) []string {
+	if SliceContains(slice, item) {
+		return slice
+	}
+	return append(slice, item)
+}
+
+// SliceRemove returns a copy of the slice without the item
+func SliceRemove(slice []string, item string) []string {
+	filtered := make([]string, 0, len(slice))
+	for _, v := range slice {
+		if v != item {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
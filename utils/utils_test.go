@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSlice(t *testing.T) {
+	list := []string{"a@example.com", "b@example.com"}
+	if !SliceContains(list, "b@example.com") || SliceContains(list, "c@example.com") {
+		t.Errorf("SliceContains() of %v is wrong", list)
+	}
+	if added := SliceAdd(list, "a@example.com"); !reflect.DeepEqual(added, list) {
+		t.Errorf("SliceAdd() of existing item = %v, want %v", added, list)
+	}
+	if added := SliceAdd(list, "c@example.com"); !reflect.DeepEqual(added, []string{"a@example.com", "b@example.com", "c@example.com"}) {
+		t.Errorf("SliceAdd() = %v", added)
+	}
+	if removed := SliceRemove(list, "a@example.com"); !reflect.DeepEqual(removed, []string{"b@example.com"}) {
+		t.Errorf("SliceRemove() = %v", removed)
+	}
+	if list[0] != "a@example.com" {
+		t.Errorf("SliceRemove() modified the slice: %v", list)
+	}
+}